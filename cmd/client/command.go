@@ -4,22 +4,51 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 
+	"github.com/liangchenye/update-service/keymanager"
 	"github.com/liangchenye/update-service/utils"
 )
 
 var addCommand = cli.Command{
-	Name:  "add",
-	Usage: "add a repository url",
+	Name:      "add",
+	Usage:     "add a repository url",
+	ArgsUsage: "proto url",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "the public key file of the offline root key, which should sign the role of the repository",
+		},
+		cli.StringFlag{
+			Name:  "channel",
+			Usage: "the release channel to sync, for example 'stable', the whole repository by default",
+		},
+	},
 
 	Action: func(context *cli.Context) error {
 		proto := context.Args().Get(0)
 		url := context.Args().Get(1)
+
+		var rootKey []byte
+		if context.String("root") != "" {
+			var err error
+			if rootKey, err = ioutil.ReadFile(context.String("root")); err == nil {
+				_, err = utils.KeyID(rootKey)
+			}
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+		}
+
 		ucc, _ := DefaultUpdateClientConfig()
-		if err := ucc.Add(proto, url); err != nil {
+		if err := ucc.Add(proto, url, string(rootKey), context.String("channel")); err != nil {
 			fmt.Println(err)
 			return err
 		}
@@ -42,7 +71,7 @@ var removeCommand = cli.Command{
 			return err
 		}
 
-		fmt.Printf("Success in removing %s.\n", proto, url)
+		fmt.Printf("Success in removing %s %s.\n", proto, url)
 		return nil
 	},
 }
@@ -60,7 +89,11 @@ var listCommand = cli.Command{
 		} else if len(context.Args()) == 2 {
 			proto := context.Args().Get(0)
 			url := context.Args().Get(1)
-			repo, _ := NewUpdateClientRepo(proto, url)
+			repo, err := NewUpdateClientRepo(proto, url)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
 			apps, err := repo.List()
 			if err != nil {
 				fmt.Println(err)
@@ -72,7 +105,7 @@ var listCommand = cli.Command{
 			}
 
 			ucc, _ := DefaultUpdateClientConfig()
-			ucc.Add(proto, url)
+			ucc.Add(proto, url, "", "")
 		}
 		return nil
 	},
@@ -80,28 +113,96 @@ var listCommand = cli.Command{
 
 var pushCommand = cli.Command{
 	Name:  "push",
-	Usage: "push a file to a repository",
+	Usage: "push files to a repository, several files are published together",
+
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "encrypt",
+			Usage: "encrypt the file to the public key of the repository",
+		},
+		cli.StringFlag{
+			Name:  "tag",
+			Usage: "push the file as a version, for example '1.4.2', which is pulled by 'name@^1.4'",
+		},
+		cli.StringFlag{
+			Name:  "os",
+			Usage: "the OS of an app, it is pushed as 'os-arch-name', for example 'linux'",
+		},
+		cli.StringFlag{
+			Name:  "arch",
+			Usage: "the architecture of an app, for example 'amd64' or 'armv7'",
+		},
+		cli.StringSliceFlag{
+			Name:  "channel",
+			Value: &cli.StringSlice{},
+			Usage: "add the file to a release channel, for example 'beta'",
+		},
+		cli.StringFlag{
+			Name:  "media-type",
+			Usage: "the media type of the file, guessed by the file extension by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "annotation",
+			Value: &cli.StringSlice{},
+			Usage: "annotate the file by 'key=value', for example 'release-notes=fix the crash'",
+		},
+		cli.Int64Flag{
+			Name:  "part-size",
+			Usage: "push the file as parts of the size in bytes, which could be resumed",
+		},
+		cli.IntFlag{
+			Name:  "jobs",
+			Value: 4,
+			Usage: "the count of the concurrent uploads of the parts",
+		},
+	},
 
 	Action: func(context *cli.Context) error {
 		//TODO: we can have a default repo
-		if len(context.Args()) != 3 {
-			err := errors.New("wrong syntax: push 'proto' 'repo url' 'local filepath'")
+		if len(context.Args()) < 3 {
+			err := errors.New("wrong syntax: push 'proto' 'repo url' 'local filepath...'")
 			fmt.Println(err)
 			return err
 		}
 
 		proto := context.Args().Get(0)
 		url := context.Args().Get(1)
-		file := context.Args().Get(2)
-		repo, _ := NewUpdateClientRepo(proto, url)
+		files := context.Args()[2:]
+		repo, err := NewUpdateClientRepo(proto, url)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		// the key of an encrypted file is verified by the cached meta data and the pinned root key
+		ucc, _ := DefaultUpdateClientConfig()
+		repo.SetCacheDir(ucc.GetCacheDir())
+		repo.RootKey = ucc.GetRootKey(proto, url)
 
-		content, err := ioutil.ReadFile(file)
+		annotations, err := parseAnnotations(context.StringSlice("annotation"))
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
 
-		err = repo.Put(filepath.Base(file), content)
+		// several files are published together, clients never see a part of them
+		if len(files) > 1 {
+			if err := repo.Begin(); err != nil {
+				fmt.Println(err)
+				return err
+			}
+		}
+		for _, file := range files {
+			if err = pushFile(context, &repo, file, annotations); err != nil {
+				break
+			}
+		}
+		if len(files) > 1 {
+			if err == nil {
+				err = repo.Commit()
+			} else {
+				repo.Abort()
+			}
+		}
 		if err != nil {
 			fmt.Println(err)
 			return err
@@ -110,14 +211,84 @@ var pushCommand = cli.Command{
 	},
 }
 
+func pushFile(context *cli.Context, repo *UpdateClientRepo, file string, annotations map[string]string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(file)
+	if context.String("os") != "" || context.String("arch") != "" {
+		name = fmt.Sprintf("%s-%s-%s", context.String("os"), context.String("arch"), name)
+	}
+	if context.String("tag") != "" {
+		name = name + ":" + context.String("tag")
+	}
+	if _, err := repo.protocol.ParseFullName(name); err != nil {
+		return err
+	}
+	mediaType := context.String("media-type")
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(filepath.Ext(file))
+	}
+	channels := context.StringSlice("channel")
+	if context.Bool("encrypt") {
+		return repo.PutEncrypted(name, content, annotations, channels)
+	} else if context.Int64("part-size") > 0 {
+		return repo.PutParts(name, content, context.Int64("part-size"), context.Int("jobs"), mediaType, annotations, channels)
+	}
+	return repo.Put(name, content, mediaType, annotations, channels)
+}
+
 var pullCommand = cli.Command{
 	Name:  "pull",
 	Usage: "pull a file from a repository",
 
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "decrypt",
+			Usage: "decrypt an encrypted file by '--key' or the key manager",
+		},
+		cli.StringFlag{
+			Name:  "key",
+			Usage: "the private key file of the repository to decrypt the file",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-mode",
+			Value: "remote",
+			Usage: "the key manager mode to decrypt the file without '--key'",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-uri",
+			Usage: "the key manager url to decrypt the file without '--key'",
+		},
+		cli.BoolFlag{
+			Name:  "require-log",
+			Usage: "require the meta data to be in the transparency log of the repository",
+		},
+		cli.IntFlag{
+			Name:  "jobs",
+			Value: 4,
+			Usage: "the count of the concurrent downloads of the parts of a multi-part file",
+		},
+		cli.StringFlag{
+			Name:  "channel",
+			Usage: "the release channel to pull from, the channel of 'uc add' by default",
+		},
+		cli.BoolFlag{
+			Name:  "no-delta",
+			Usage: "download the file as a whole even if a previous version is cached",
+		},
+		cli.BoolFlag{
+			Name:  "auto-platform",
+			Usage: "pull the app 'name[:tag]' which runs best on this OS and architecture, like 'linux-386-name' on linux/amd64",
+		},
+	},
+
 	Action: func(context *cli.Context) error {
 		//TODO: we can have a default repo
 		if len(context.Args()) != 3 {
-			err := errors.New("wrong syntax: pull  'proto' 'repo url' 'name' ")
+			err := errors.New("wrong syntax: pull  'proto' 'repo url' 'name[@version constraint]' ")
 			fmt.Println(err)
 			return err
 		}
@@ -125,20 +296,78 @@ var pullCommand = cli.Command{
 		proto := context.Args().Get(0)
 		url := context.Args().Get(1)
 		name := context.Args().Get(2)
-		repo, _ := NewUpdateClientRepo(proto, url)
+		repo, err := NewUpdateClientRepo(proto, url)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
 		ucc, _ := DefaultUpdateClientConfig()
 		repo.SetCacheDir(ucc.GetCacheDir())
+		repo.RootKey = ucc.GetRootKey(proto, url)
+		repo.Channel = ucc.GetChannel(proto, url)
+		if context.String("channel") != "" {
+			repo.Channel = context.String("channel")
+		}
 
 		fmt.Println("start to download and verify meta data")
-		err := repo.Sync()
+		err = repo.Sync()
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
 		fmt.Println("success in downloading and verifying meta data")
 
+		if context.Bool("auto-platform") {
+			base, constraint := utils.SplitFullName(name)
+			if i := strings.LastIndex(name, "@"); i > 0 {
+				base, constraint = name[:i], name[i+1:]
+			}
+			name, err = repo.ResolvePlatform(base, constraint)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			goos, arch := RunningPlatform()
+			fmt.Printf("resolved to: %s for %s/%s\n", name, goos, arch)
+		} else if i := strings.LastIndex(name, "@"); i > 0 {
+			name, err = repo.Resolve(name[:i], name[i+1:])
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			fmt.Println("resolved to: ", name)
+		}
+
+		if context.Bool("require-log") {
+			if err := repo.VerifyLog(); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			fmt.Println("the meta data is in the transparency log")
+		}
+
+		item, err := repo.GetItem(name)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if item.IsExpired() {
+			fmt.Printf("warning: %s is expired at %s\n", item.FullName, item.GetExpired().Format(time.RFC3339))
+		}
+
 		fmt.Println("start to download file")
-		savedURL, err := repo.Get(name)
+		var savedURL string
+		if len(item.Deltas) > 0 && !context.Bool("no-delta") {
+			if deltaURL, d, deltaErr := repo.GetDelta(item); deltaErr == nil {
+				savedURL = deltaURL
+				fmt.Printf("patched from %s by a delta of %d bytes\n", d.From, d.Length)
+			}
+		}
+		if savedURL == "" && item.IsMultiPart() {
+			savedURL, err = repo.GetParts(item, context.Int("jobs"))
+		} else if savedURL == "" {
+			savedURL, err = repo.Get(name)
+		}
 		if err != nil {
 			fmt.Println(err)
 			return err
@@ -146,20 +375,243 @@ var pullCommand = cli.Command{
 		fmt.Println("file downloaded to: ", savedURL)
 
 		fmt.Println("start to compare the hash value")
-		sha, err := repo.GetSHAS(name)
+
+		data, _ := ioutil.ReadFile(savedURL)
+		if err := item.VerifyContent(data); err != nil {
+			message := fmt.Sprintf("The downloaded file is invalid: %v", err)
+			return errors.New(message)
+		}
+		fmt.Printf("the downloaded file is valid, %d bytes.\n", len(data))
+		if item.MediaType != "" {
+			fmt.Println("media type: ", item.MediaType)
+		}
+		var keys []string
+		for k := range item.Annotations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, item.Annotations[k])
+		}
+
+		if context.Bool("decrypt") {
+			plain, err := decryptFile(context, repo, data)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			if err := ioutil.WriteFile(savedURL+".decrypted", plain, 0600); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			fmt.Println("file decrypted to: ", savedURL+".decrypted")
+		}
+		return nil
+	},
+}
+
+var promoteCommand = cli.Command{
+	Name:      "promote",
+	Usage:     "move a file from a release channel to another one without uploading it again",
+	ArgsUsage: "proto url name",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "the release channel to move the file from, for example 'beta', empty to only add it",
+		},
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "the release channel to move the file to, for example 'stable'",
+		},
+	},
+
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 3 || context.String("to") == "" {
+			err := errors.New("wrong syntax: promote [--from 'channel'] --to 'channel' 'proto' 'repo url' 'name'")
+			fmt.Println(err)
+			return err
+		}
+
+		proto := context.Args().Get(0)
+		url := context.Args().Get(1)
+		name := context.Args().Get(2)
+		repo, err := NewUpdateClientRepo(proto, url)
 		if err != nil {
 			fmt.Println(err)
 			return err
 		}
+		if err := repo.Promote(name, context.String("from"), context.String("to")); err != nil {
+			fmt.Println(err)
+			return err
+		}
 
-		data, _ := ioutil.ReadFile(savedURL)
-		shaCal, _ := utils.SHA512(data)
+		fmt.Printf("Success in promoting %s to %s.\n", name, context.String("to"))
+		return nil
+	},
+}
 
-		if sha != shaCal {
-			message := fmt.Sprintf("The downloaded file is invalid, expected sha: <%s>, but get: <%s>", sha, shaCal)
-			return errors.New(message)
+// parseAnnotations parses the 'key=value' annotations of a file
+func parseAnnotations(values []string) (map[string]string, error) {
+	annotations := make(map[string]string)
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid annotation '%s', should be 'key=value'", v)
+		}
+		annotations[strings.ToLower(kv[0])] = kv[1]
+	}
+
+	return annotations, nil
+}
+
+// decryptFile unwraps the data key by a local private key, or by a key
+// manager, for example a remote signing service the client is authorized to.
+func decryptFile(context *cli.Context, repo UpdateClientRepo, data []byte) ([]byte, error) {
+	env, err := utils.ParseEncryptedEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if context.String("key") != "" {
+		keyBytes, err := ioutil.ReadFile(context.String("key"))
+		if err != nil {
+			return nil, err
+		}
+		privBytes, err := utils.ImportPrivateKey(keyBytes, "")
+		if err != nil {
+			return nil, err
+		}
+		return env.Decrypt(func(wrapped []byte) ([]byte, error) {
+			return utils.UnwrapKey(privBytes, wrapped)
+		})
+	}
+
+	if context.String("keymanager-uri") == "" {
+		return nil, errors.New("'--key' or '--keymanager-uri' is required to decrypt")
+	}
+	km, err := keymanager.NewKeyManager(context.String("keymanager-mode"), context.String("keymanager-uri"))
+	if err == nil && km == nil {
+		err = keymanager.ErrorsKMNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return env.Decrypt(func(wrapped []byte) ([]byte, error) {
+		return km.Decrypt(repo.appliance(), wrapped)
+	})
+}
+
+// signPayloadTypes are the payload types 'uc sign' signs by the '--type' names,
+// a signature of one type never verifies another type
+var signPayloadTypes = map[string]string{
+	"meta":        utils.DefaultPayloadType,
+	"channel":     utils.ChannelMetaPayloadType,
+	"role":        utils.RolePayloadType,
+	"revocations": utils.RevocationPayloadType,
+	"delegations": utils.DelegationsPayloadType,
+	"targets":     utils.TargetsPayloadType,
+}
+
+var signCommand = cli.Command{
+	Name:  "sign",
+	Usage: "sign an exported meta data or role offline by a private key",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "key",
+			Usage: "the private key file in PEM PKCS#1, PEM PKCS#8 or JWK",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "meta",
+			Usage: "what is signed: meta, channel, role, revocations, delegations or targets",
+		},
+	},
+
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 || context.String("key") == "" {
+			err := errors.New("wrong syntax: sign --key 'private key file' 'meta file' ['signature file']")
+			fmt.Println(err)
+			return err
+		}
+
+		payloadType, ok := signPayloadTypes[context.String("type")]
+		if !ok {
+			err := fmt.Errorf("Invalid type '%s', should be meta, channel, role, revocations, delegations or targets", context.String("type"))
+			fmt.Println(err)
+			return err
 		}
-		fmt.Println("the downloaded file is valid.")
+
+		metaFile := context.Args().Get(0)
+		sigFile := context.Args().Get(1)
+		if sigFile == "" {
+			sigFile = metaFile + ".sig"
+		}
+
+		privBytes, err := ioutil.ReadFile(context.String("key"))
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		metaBytes, err := ioutil.ReadFile(metaFile)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		data, err := signMeta(privBytes, payloadType, metaBytes)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if err := ioutil.WriteFile(sigFile, data, 0644); err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		fmt.Printf("Success in signing %s to %s.\n", metaFile, sigFile)
+		return nil
+	},
+}
+
+var keygenCommand = cli.Command{
+	Name:      "keygen",
+	Usage:     "generate a key pair offline, for example the root key of a repository",
+	ArgsUsage: "name",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "keytype",
+			Value: utils.DefaultKeyType,
+			Usage: "the key type: rsa, rsa-pss, ecdsa-p256 or ed25519",
+		},
+	},
+
+	Action: func(context *cli.Context) error {
+		name := context.Args().Get(0)
+		if name == "" {
+			err := errors.New("wrong syntax: keygen [--keytype 'key type'] 'name'")
+			fmt.Println(err)
+			return err
+		}
+
+		privBytes, pubBytes, err := utils.GenerateKeyPair(context.String("keytype"))
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if err := ioutil.WriteFile(name+".pem", privBytes, 0600); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if err := ioutil.WriteFile(name+".pub", pubBytes, 0644); err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		fmt.Printf("Success in generating %s.pem and %s.pub.\n", name, name)
 		return nil
 	},
 }
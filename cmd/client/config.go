@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/liangchenye/update-service/cmd/server/api"
+	"github.com/liangchenye/update-service/protocol"
 	"github.com/liangchenye/update-service/service"
 	"github.com/liangchenye/update-service/storage"
 	"github.com/liangchenye/update-service/utils"
@@ -31,23 +37,32 @@ const (
 
 // UpdateClientRepo is the saved repo
 type UpdateClientRepo struct {
-	Proto      string
-	URL        string
+	Proto string
+	URL   string
+	// RootKey is the PEM public key of the offline root key pinned at 'uc add',
+	// the role of a repository with a root key should be signed by it.
+	RootKey string `json:",omitempty"`
+	// Channel is the release channel to sync, empty for the whole repository
+	Channel    string `json:",omitempty"`
 	uri        string
 	host       string
 	namespace  string
 	repository string
 
+	protocol protocol.Protocol
 	//TODO: should use interface here
 	protoRepo api.AppV1Repo
+	// transaction stages the pushed files to be published together, see Begin
+	transaction string
 
 	store    storage.UpdateServiceStorage
 	cacheDir string
 }
 
 func NewUpdateClientRepo(proto, uri string) (ucr UpdateClientRepo, err error) {
-	if proto != "appv1" {
-		return UpdateClientRepo{}, errors.New("Only appv1 supported now")
+	ucr.protocol, err = protocol.GetProtocol(proto)
+	if err != nil {
+		return UpdateClientRepo{}, err
 	}
 
 	ucr.Proto = proto
@@ -71,7 +86,7 @@ func NewUpdateClientRepo(proto, uri string) (ucr UpdateClientRepo, err error) {
 	}
 	ucr.namespace = strs[1]
 	ucr.repository = strs[2]
-	ucr.protoRepo, err = api.NewAppV1Repo(ucr.uri, ucr.namespace, ucr.repository)
+	ucr.protoRepo, err = api.NewRepo(ucr.uri, ucr.protocol.Proto(), ucr.protocol.Version(), ucr.namespace, ucr.repository)
 	if err != nil {
 		return UpdateClientRepo{}, err
 	}
@@ -84,13 +99,122 @@ func (ucr *UpdateClientRepo) SetCacheDir(dir string) {
 	ucr.store, _ = storage.NewUpdateServiceStorage(dir)
 }
 
-func (ucr *UpdateClientRepo) Put(name string, content []byte) error {
-	_, err := ucr.protoRepo.PutFile(name, "", "", content)
+// Put puts a file with its media type, annotations and release channels, all are optional
+func (ucr *UpdateClientRepo) Put(name string, content []byte, mediaType string, annotations map[string]string, channels []string) error {
+	var status int
+	var err error
+	if ucr.transaction != "" {
+		status, err = ucr.protoRepo.StageFile(ucr.transaction, name, "", content, mediaType, annotations, channels)
+	} else {
+		status, err = ucr.protoRepo.PutFileWithMeta(name, "", "", content, mediaType, annotations, channels)
+	}
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("Fail to put %s: %s", name, http.StatusText(status))
+	}
+	return err
+}
+
+// PutEncrypted encrypts a file to the public key of the repository and puts it,
+// only the key manager of the repository, or holders of its private key could decrypt it.
+func (ucr *UpdateClientRepo) PutEncrypted(name string, content []byte, annotations map[string]string, channels []string) error {
+	pubBytes, err := ucr.encryptionKey()
+	if err != nil {
+		return err
+	}
+
+	data, err := utils.Encrypt(pubBytes, content)
+	if err != nil {
+		return err
+	}
+
+	return ucr.Put(name, data, utils.EncryptedPayloadType, annotations, channels)
+}
+
+// encryptionKey gets the public key of the repository verified as Sync does: it
+// should be a key of the role, which is signed by the pinned root key and not
+// older than the cached one, or the public key synced before by a server without roles
+func (ucr *UpdateClientRepo) encryptionKey() ([]byte, error) {
+	pubBytes, status, err := ucr.protoRepo.GetPublicKey("")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, errors.New("Fail to get the public key of the repository")
+	}
+
+	role, _, err := ucr.getRole()
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		keyID, err := utils.KeyID(pubBytes)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := role.Keys[keyID]; !ok {
+			return nil, errors.New("Fail to encrypt, the public key of the repository is not a key of its role")
+		}
+	} else {
+		key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "pubkey")
+		synced, err := ucr.store.Get(key)
+		if err != nil {
+			return nil, errors.New("Fail to encrypt, the public key of a repository without role should be synced by pulling first")
+		}
+		if !bytes.Equal(synced, pubBytes) {
+			return nil, errors.New("Fail to encrypt, the public key of the repository is not the synced one")
+		}
+	}
+
+	if err := utils.CheckEncryptionKey(pubBytes); err != nil {
+		return nil, fmt.Errorf("Fail to encrypt to the public key of the repository, the key type of the repository should be rsa, rsa-pss or ecdsa-p256: %v", err)
+	}
+	return pubBytes, nil
+}
+
+// Begin begins a transaction, the files put after it are staged and only
+// published together by Commit
+func (ucr *UpdateClientRepo) Begin() error {
+	id, err := ucr.protoRepo.BeginTransaction("")
+	if err != nil {
+		return err
+	}
+	ucr.transaction = id
+	return nil
+}
+
+// Commit publishes the files staged since Begin under one signature
+func (ucr *UpdateClientRepo) Commit() error {
+	if ucr.transaction == "" {
+		return errors.New("No transaction is begun")
+	}
+	if _, err := ucr.protoRepo.CommitTransaction(ucr.transaction, ""); err != nil {
+		return err
+	}
+	ucr.transaction = ""
+	return nil
+}
+
+// Abort drops the files staged since Begin
+func (ucr *UpdateClientRepo) Abort() error {
+	if ucr.transaction == "" {
+		return errors.New("No transaction is begun")
+	}
+	_, err := ucr.protoRepo.AbortTransaction(ucr.transaction, "")
+	ucr.transaction = ""
 	return err
 }
 
+func (ucr *UpdateClientRepo) appliance() utils.Appliance {
+	return utils.Appliance{Proto: ucr.protocol.Proto(), Version: ucr.protocol.Version(), Namespace: ucr.namespace, Repository: ucr.repository}
+}
+
+// protoPath is the part of the cache keys of the protocol, like 'app/v1'
+func (ucr *UpdateClientRepo) protoPath() string {
+	return ucr.protocol.Proto() + "/" + ucr.protocol.Version()
+}
+
 func (ucr *UpdateClientRepo) List() ([]string, error) {
-	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, "app/v1", ucr.namespace, ucr.repository, "meta.json")
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "meta.json")
 	metaBytes, err := ucr.store.Get(key)
 	if err != nil {
 		metaBytes, _, err = ucr.protoRepo.GetMeta("")
@@ -107,70 +231,580 @@ func (ucr *UpdateClientRepo) List() ([]string, error) {
 
 	var ret []string
 	for _, item := range meta.Items {
-		ret = append(ret, item.FullName)
+		if !item.Hidden {
+			ret = append(ret, item.FullName)
+		}
 	}
 
 	return ret, nil
 }
 
+// Sync downloads and verifies the meta data, only the meta data of the release
+// channel if the repository has a channel. Nothing is cached unless every
+// verification passes, the cached meta data is always the verified one.
 func (ucr *UpdateClientRepo) Sync() error {
-	metaBytes, _, err := ucr.protoRepo.GetMeta("")
+	metaBytes, metaSignBytes, err := ucr.getMetaAndSign()
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, "app/v1", ucr.namespace, ucr.repository, "meta.json")
-	_, err = ucr.store.Put(key, metaBytes)
+	pubBytes, _, err := ucr.protoRepo.GetPublicKey("")
 	if err != nil {
 		return err
 	}
+	role, roleBytes, err := ucr.getRole()
+	if err != nil {
+		return err
+	}
+
+	// keys revoked by the server are never trusted
+	if err := ucr.checkRevocations(role, pubBytes, metaSignBytes); err != nil {
+		return err
+	}
+	if err := ucr.verifyMeta(role, pubBytes, metaBytes, metaSignBytes); err != nil {
+		return err
+	}
+	// the meta data of a channel should not be served as the one of another channel
+	var meta service.UpdateService
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return err
+	}
+	if meta.Channel != ucr.Channel {
+		return fmt.Errorf("Fail to get the meta data of channel '%s', get the one of channel '%s'", ucr.Channel, meta.Channel)
+	}
+	if err := ucr.verifyDelegations(role, pubBytes, metaBytes); err != nil {
+		return err
+	}
+
+	// the role is only cached once the meta data is verified by it
+	synced := map[string][]byte{"meta.json": metaBytes, "metasign": metaSignBytes, "pubkey": pubBytes}
+	if role != nil {
+		synced["role"] = roleBytes
+	}
+	for _, name := range []string{"pubkey", "role", "metasign", "meta.json"} {
+		data, ok := synced[name]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, name)
+		if _, err := ucr.store.Put(key, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getMetaAndSign downloads the meta data and its signatures of the release channel,
+// or of the whole repository
+func (ucr *UpdateClientRepo) getMetaAndSign() ([]byte, []byte, error) {
+	var metaBytes, metaSignBytes []byte
+	var status int
+	var err error
+	if ucr.Channel != "" {
+		metaBytes, status, err = ucr.protoRepo.GetChannelMeta(ucr.Channel, "")
+		if err == nil && status == http.StatusOK {
+			metaSignBytes, status, err = ucr.protoRepo.GetChannelMetaSign(ucr.Channel, "")
+		}
+	} else {
+		metaBytes, status, err = ucr.protoRepo.GetMeta("")
+		if err == nil {
+			metaSignBytes, status, err = ucr.protoRepo.GetMetaSign("")
+		}
+	}
+	if err == nil && status != http.StatusOK && ucr.Channel != "" {
+		err = fmt.Errorf("Fail to get the meta data of channel '%s': %s", ucr.Channel, http.StatusText(status))
+	}
 
-	metaSignBytes, _, err := ucr.protoRepo.GetMetaSign("")
+	return metaBytes, metaSignBytes, err
+}
+
+// getRole gets the role of the repository, it should be signed by the root key if one
+// is pinned, and not be expired or older than the cached one. Servers without roles
+// have no role, but a repository whose role is cached or whose root key is pinned
+// never falls back to be verified by a single key.
+func (ucr *UpdateClientRepo) getRole() (*utils.Role, []byte, error) {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "role")
+	cachedBytes, cachedErr := ucr.store.Get(key)
+
+	roleBytes, status, err := ucr.protoRepo.GetRole("")
+	if err != nil || status != http.StatusOK {
+		if ucr.RootKey != "" {
+			return nil, nil, errors.New("Fail to get the role signed by the root key")
+		}
+		if cachedErr == nil {
+			return nil, nil, errors.New("Fail to get the role, which was served before")
+		}
+		return nil, nil, nil
+	}
+
+	var role utils.Role
+	if err := json.Unmarshal(roleBytes, &role); err != nil {
+		return nil, nil, err
+	}
+	// an old role, which may list compromised keys, should not be replayed
+	var cached utils.Role
+	if cachedErr == nil {
+		if err := json.Unmarshal(cachedBytes, &cached); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := role.CheckUpdate(cached, time.Now()); err != nil {
+		return nil, nil, err
+	}
+	if ucr.RootKey != "" {
+		if err := ucr.verifyRole(role); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &role, roleBytes, nil
+}
+
+// verifyMeta verifies the meta signatures by the threshold of the role, or by
+// the public key of servers without roles
+func (ucr *UpdateClientRepo) verifyMeta(role *utils.Role, pubBytes, metaBytes, metaSignBytes []byte) error {
+	// meta data is signed in canonical json, metas signed before that are verified as they are
+	canonicalBytes, err := utils.CanonicalizeJSON(metaBytes)
 	if err != nil {
 		return err
 	}
-	key = fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, "app/v1", ucr.namespace, ucr.repository, "metasign")
-	_, err = ucr.store.Put(key, metaSignBytes)
+
+	// the meta data of a channel is signed as another payload type
+	payloadType := utils.DefaultPayloadType
+	if ucr.Channel != "" {
+		payloadType = utils.ChannelMetaPayloadType
+	}
+
+	if role != nil {
+		env, err := utils.ParseSignatureEnvelope(metaSignBytes)
+		if err != nil {
+			return err
+		}
+		return role.VerifyThreshold(payloadType, canonicalBytes, env)
+	}
+
+	if err := utils.VerifyMetaSign(pubBytes, payloadType, canonicalBytes, metaSignBytes); err == nil {
+		return nil
+	}
+
+	return utils.VerifyMetaSign(pubBytes, payloadType, metaBytes, metaSignBytes)
+}
+
+// verifyDelegations verifies every item matching a delegation by the targets
+// signed by the keys of the delegation. The delegation list should be signed by
+// the threshold of the role, or by the public key of a server without roles, and
+// not be older than the cached one, neither should the delegated targets.
+func (ucr *UpdateClientRepo) verifyDelegations(role *utils.Role, pubBytes, metaBytes []byte) error {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "delegations")
+	var cached utils.Delegations
+	if cachedBytes, err := ucr.store.Get(key); err == nil {
+		if sd, err := utils.ParseSignedDelegations(cachedBytes); err == nil {
+			cached = sd.Signed
+		}
+	}
+
+	data, status, err := ucr.protoRepo.GetDelegations("")
 	if err != nil {
-		//TODO: Need to rollback
 		return err
 	}
+	if status != http.StatusOK {
+		if cached.Version > 0 {
+			return errors.New("Fail to get the delegations, which were served before")
+		}
+		return nil
+	}
 
-	pubBytes, _, err := ucr.protoRepo.GetPublicKey("")
+	sd, err := utils.ParseSignedDelegations(data)
 	if err != nil {
 		return err
 	}
-	key = fmt.Sprintf("%s/%s/%s/%s", ucr.host, "app/v1", ucr.namespace, "pubkey")
-	_, err = ucr.store.Put(key, pubBytes)
+	if role != nil {
+		err = sd.VerifyRole(*role)
+	} else {
+		err = sd.Verify(pubBytes)
+	}
 	if err != nil {
-		//TODO: Need to rollback
+		return fmt.Errorf("Fail to verify the delegations: %v", err)
+	}
+	if sd.Signed.Version < cached.Version {
+		return fmt.Errorf("Delegations version %d is older than the cached version %d", sd.Signed.Version, cached.Version)
+	}
+	if _, err := ucr.store.Put(key, data); err != nil {
+		return err
+	}
+
+	var meta service.UpdateService
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
 		return err
 	}
+	targets := make(map[string]utils.DelegatedTargets)
+	for _, item := range meta.Items {
+		d, ok := sd.Signed.Find(item.FullName)
+		if !ok {
+			continue
+		}
+		if _, ok := targets[d.Name]; !ok {
+			if targets[d.Name], err = ucr.getDelegatedTargets(d); err != nil {
+				return err
+			}
+		}
+		dt := targets[d.Name]
+		if err := dt.VerifyItem(item.FullName, item.SHAS); err != nil {
+			return err
+		}
+	}
 
-	return utils.SHA256Verify(pubBytes, metaBytes, metaSignBytes)
+	return nil
 }
 
-func (ucr *UpdateClientRepo) GetSHAS(name string) (string, error) {
-	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, "app/v1", ucr.namespace, ucr.repository, "meta.json")
-	metaBytes, err := ucr.store.Get(key)
+// getDelegatedTargets gets the targets of a delegation verified by its keys,
+// they should not be older than the cached ones
+func (ucr *UpdateClientRepo) getDelegatedTargets(d utils.Delegation) (utils.DelegatedTargets, error) {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "delegations.d", d.Name)
+	var cached utils.DelegatedTargets
+	if cachedBytes, err := ucr.store.Get(key); err == nil {
+		if st, err := utils.ParseSignedDelegatedTargets(cachedBytes); err == nil {
+			cached = st.Signed
+		}
+	}
+
+	data, status, err := ucr.protoRepo.GetDelegatedTargets(d.Name, "")
 	if err != nil {
-		metaBytes, _, err = ucr.protoRepo.GetMeta("")
+		return utils.DelegatedTargets{}, err
+	}
+	if status != http.StatusOK {
+		return utils.DelegatedTargets{}, fmt.Errorf("Fail to get the targets signed by the delegation '%s'", d.Name)
+	}
+
+	st, err := utils.ParseSignedDelegatedTargets(data)
+	if err != nil {
+		return utils.DelegatedTargets{}, err
+	}
+	if err := st.Verify(d); err != nil {
+		return utils.DelegatedTargets{}, fmt.Errorf("Fail to verify the targets of the delegation '%s': %v", d.Name, err)
+	}
+	if err := st.Signed.CheckUpdate(cached); err != nil {
+		return utils.DelegatedTargets{}, err
+	}
+	if _, err := ucr.store.Put(key, data); err != nil {
+		return utils.DelegatedTargets{}, err
+	}
+
+	return st.Signed, nil
+}
+
+// VerifyLog requires the meta data got by Sync to be in the transparency log of
+// the repository. The signed tree head should be consistent with the cached one,
+// so a server showing different meta data to different clients is detected.
+func (ucr *UpdateClientRepo) VerifyLog() error {
+	prefix := fmt.Sprintf("%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository)
+	var cached [3][]byte
+	for i, name := range []string{"meta.json", "metasign", "pubkey"} {
+		data, err := ucr.store.Get(prefix + "/" + name)
 		if err != nil {
-			return "", err
+			return fmt.Errorf("Fail to load the synced %s: %v", name, err)
 		}
+		cached[i] = data
+	}
+	metaBytes, metaSignBytes, pubBytes := cached[0], cached[1], cached[2]
+
+	data, status, err := ucr.protoRepo.GetTreeHead("")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Fail to get the tree head of the transparency log")
+	}
+	sth, err := utils.ParseSignedTreeHead(data)
+	if err != nil {
+		return err
+	}
+	// the tree head is verified by the keys of the role synced with the meta data
+	if roleBytes, err := ucr.store.Get(prefix + "/role"); err == nil {
+		var role utils.Role
+		if err := json.Unmarshal(roleBytes, &role); err != nil {
+			return err
+		}
+		err = sth.VerifyRole(role)
+	} else {
+		err = sth.Verify(pubBytes)
+	}
+	if err != nil {
+		return fmt.Errorf("Fail to verify the tree head: %v", err)
+	}
+
+	key := prefix + "/sth"
+	if cachedBytes, err := ucr.store.Get(key); err == nil {
+		old, err := utils.ParseSignedTreeHead(cachedBytes)
+		if err != nil {
+			return err
+		}
+		if err := ucr.verifyConsistency(old.Signed, sth.Signed); err != nil {
+			return err
+		}
+	}
+
+	leaf, err := service.LogLeaf(metaBytes, metaSignBytes)
+	if err != nil {
+		return err
+	}
+	leafHash := utils.MerkleLeafHash(leaf)
+	proofBytes, status, err := ucr.protoRepo.GetInclusionProof(leafHash, sth.Signed.TreeSize, "")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Fail to get the inclusion proof, the meta data is not in the transparency log")
+	}
+	var proof utils.InclusionProof
+	if err := json.Unmarshal(proofBytes, &proof); err != nil {
+		return err
+	}
+	if err := utils.VerifyInclusion(leafHash, proof.LeafIndex, sth.Signed.TreeSize, proof.AuditPath, sth.Signed.RootHash); err != nil {
+		return fmt.Errorf("Fail to verify the meta data is in the transparency log: %v", err)
+	}
+
+	_, err = ucr.store.Put(key, data)
+	return err
+}
+
+// verifyConsistency makes sure the new tree head extends the cached one
+func (ucr *UpdateClientRepo) verifyConsistency(old, th utils.TreeHead) error {
+	if th.TreeSize < old.TreeSize {
+		return fmt.Errorf("Tree size %d is smaller than the cached size %d", th.TreeSize, old.TreeSize)
+	}
+	if th.TreeSize == old.TreeSize || old.TreeSize == 0 {
+		return utils.VerifyConsistency(old.TreeSize, th.TreeSize, old.RootHash, th.RootHash, nil)
+	}
+
+	data, status, err := ucr.protoRepo.GetConsistencyProof(old.TreeSize, th.TreeSize, "")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Fail to get the consistency proof of the transparency log")
+	}
+	var proof utils.ConsistencyProof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return err
+	}
+	if err := utils.VerifyConsistency(old.TreeSize, th.TreeSize, old.RootHash, th.RootHash, proof.Proof); err != nil {
+		return fmt.Errorf("Transparency log is not consistent with the cached tree head: %v", err)
+	}
+
+	return nil
+}
+
+// checkRevocations refuses the meta signatures made by revoked keys.
+// The revocation list should be signed by the pinned root key or the threshold of
+// the role, or by the current public key of a server without roles. It should not
+// be older than the cached one or drop any key the cached one revokes, and no key
+// of the role should be revoked. Servers without revocations are trusted as they are.
+func (ucr *UpdateClientRepo) checkRevocations(role *utils.Role, pubBytes []byte, metaSignBytes []byte) error {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "revocations")
+	var cached utils.RevocationList
+	if cachedBytes, err := ucr.store.Get(key); err == nil {
+		if srl, err := utils.ParseSignedRevocationList(cachedBytes); err == nil {
+			cached = srl.Signed
+		}
+	}
+
+	data, status, err := ucr.protoRepo.GetRevocations("")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		if cached.Version > 0 {
+			return errors.New("Fail to get the revocation list, which was served before")
+		}
+		return nil
+	}
+
+	srl, err := utils.ParseSignedRevocationList(data)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		err = srl.Verify(pubBytes)
+	} else if err = srl.VerifyRole(*role); err != nil && ucr.RootKey != "" {
+		payload, perr := utils.CanonicalJSON(srl.Signed)
+		if perr != nil {
+			return perr
+		}
+		err = srl.Signatures.Verify([]byte(ucr.RootKey), utils.RevocationPayloadType, payload)
+	}
+	if err != nil {
+		return fmt.Errorf("Fail to verify the revocation list: %v", err)
+	}
+	if err := srl.Signed.CheckUpdate(cached); err != nil {
+		return err
+	}
+	if _, err := ucr.store.Put(key, data); err != nil {
+		return err
+	}
+
+	if role != nil {
+		if err := srl.Signed.CheckRole(*role); err != nil {
+			return err
+		}
+	}
+	if env, err := utils.ParseSignatureEnvelope(metaSignBytes); err == nil {
+		return srl.Signed.CheckEnvelope(env)
+	}
+
+	return nil
+}
+
+// verifyRole makes sure the role is signed by the pinned root key, so the
+// online keys are trusted only if the offline root delegates to them
+func (ucr *UpdateClientRepo) verifyRole(role utils.Role) error {
+	roleSignBytes, status, err := ucr.protoRepo.GetRoleSign("")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Fail to get the root signatures of the role")
+	}
+
+	payload, err := utils.CanonicalJSON(role)
+	if err != nil {
+		return err
+	}
+	env, err := utils.ParseSignatureEnvelope(roleSignBytes)
+	if err != nil {
+		return err
+	}
+	if err := env.Verify([]byte(ucr.RootKey), utils.RolePayloadType, payload); err != nil {
+		return fmt.Errorf("Fail to verify the role by the root key: %v", err)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "rolesign")
+	_, err = ucr.store.Put(key, roleSignBytes)
+	return err
+}
+
+// getMeta gets the cached meta data, which is only cached once Sync verifies it.
+// It never falls back to the meta data of the server, the files and versions
+// are checked against it.
+func (ucr *UpdateClientRepo) getMeta() (service.UpdateService, error) {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, "meta.json")
+	metaBytes, err := ucr.store.Get(key)
+	if err != nil {
+		return service.UpdateService{}, fmt.Errorf("Fail to get the verified meta data, it should be synced first: %v", err)
 	}
 
 	var meta service.UpdateService
 	err = json.Unmarshal(metaBytes, &meta)
+	return meta, err
+}
+
+// GetItem gets the meta data of a file from the cached meta data
+func (ucr *UpdateClientRepo) GetItem(name string) (service.UpdateServiceItem, error) {
+	meta, err := ucr.getMeta()
 	if err != nil {
-		return "", err
+		return service.UpdateServiceItem{}, err
 	}
 	for _, item := range meta.Items {
 		if item.FullName == name {
-			return item.SHAS[0], nil
+			return item, nil
 		}
 	}
 
-	return "", errors.New("Cannot find the appliance")
+	return service.UpdateServiceItem{}, errors.New("Cannot find the appliance")
+}
+
+// Resolve gets the full name of the version of a name resolved by a constraint
+// like '^1.4' by the server. The resolution is checked against the cached meta
+// data, so a server could not roll a client back to an older version.
+func (ucr *UpdateClientRepo) Resolve(name, constraint string) (string, error) {
+	data, status, err := ucr.protoRepo.Resolve(name, constraint, ucr.Channel, "")
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Fail to resolve %s@%s: %s", name, constraint, string(data))
+	}
+	var resolved service.UpdateServiceItem
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return "", err
+	}
+
+	meta, err := ucr.getMeta()
+	if err != nil {
+		return "", err
+	}
+	expected, err := service.ResolveVersion(meta.Items, name, constraint)
+	if err != nil {
+		return "", err
+	}
+	if expected.FullName != resolved.FullName {
+		return "", fmt.Errorf("The server resolves %s@%s to %s, but the signed meta data resolves it to %s", name, constraint, resolved.FullName, expected.FullName)
+	}
+
+	return resolved.FullName, nil
+}
+
+// ResolvePlatform gets the full name of the version of an app which runs best on
+// the running platform, see RunningPlatform. It is checked against the cached meta
+// data as Resolve.
+func (ucr *UpdateClientRepo) ResolvePlatform(name, constraint string) (string, error) {
+	goos, arch := RunningPlatform()
+	data, status, err := ucr.protoRepo.ResolvePlatform(name, constraint, ucr.Channel, goos, arch, "")
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Fail to resolve %s for %s/%s: %s", name, goos, arch, string(data))
+	}
+	var resolved service.UpdateServiceItem
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return "", err
+	}
+
+	meta, err := ucr.getMeta()
+	if err != nil {
+		return "", err
+	}
+	expected, err := service.ResolvePlatformVersion(meta.Items, name, constraint, goos, arch)
+	if err != nil {
+		return "", err
+	}
+	if expected.FullName != resolved.FullName {
+		return "", fmt.Errorf("The server resolves %s for %s/%s to %s, but the signed meta data resolves it to %s", name, goos, arch, resolved.FullName, expected.FullName)
+	}
+
+	return resolved.FullName, nil
+}
+
+// RunningPlatform gets the OS and the architecture of the running client, the
+// variant of 'arm', like 'armv7', is taken from GOARM the client is built with
+func RunningPlatform() (string, string) {
+	arch := runtime.GOARCH
+	if arch == "arm" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "GOARM" && setting.Value != "" {
+					arch = "armv" + strings.SplitN(setting.Value, ",", 2)[0]
+				}
+			}
+		}
+	}
+	return runtime.GOOS, arch
+}
+
+// Promote moves a file from a release channel to another one
+func (ucr *UpdateClientRepo) Promote(name, from, to string) error {
+	_, err := ucr.protoRepo.Promote(name, from, to, "")
+	return err
+}
+
+func (ucr *UpdateClientRepo) GetSHAS(name string) (string, error) {
+	item, err := ucr.GetItem(name)
+	if err != nil {
+		return "", err
+	}
+
+	return item.SHAS[0], nil
 }
 
 func (ucr *UpdateClientRepo) Get(name string) (string, error) {
@@ -179,10 +813,76 @@ func (ucr *UpdateClientRepo) Get(name string) (string, error) {
 		return "", err
 	}
 
-	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", ucr.host, "app/v1", ucr.namespace, ucr.repository, name)
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, name)
 	return ucr.store.Put(key, content)
 }
 
+// GetDelta builds a file by a delta from a previous version in the cache, instead
+// of downloading it as a whole. The previous version, the delta and the built file
+// are all verified by the signed meta data, it returns the saved path and the delta.
+func (ucr *UpdateClientRepo) GetDelta(item service.UpdateServiceItem) (string, service.Delta, error) {
+	for _, d := range item.Deltas {
+		oldKey := fmt.Sprintf("%s/%s/%s/%s/blob/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, d.From)
+		old, err := ucr.store.Get(oldKey)
+		if err != nil {
+			continue
+		}
+		if sha, _ := utils.SHA512(old); sha != d.FromSHA512 {
+			continue
+		}
+
+		data, status, err := ucr.protoRepo.PullDelta(item.FullName, d.From, "")
+		if err != nil || status != http.StatusOK {
+			continue
+		}
+		if sha, _ := utils.SHA512(data); sha != d.SHA512 || int64(len(data)) != d.Length {
+			continue
+		}
+		content, err := utils.ApplyDelta(old, data, item.Length)
+		if err != nil || item.VerifyContent(content) != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, item.FullName)
+		savedURL, err := ucr.store.Put(key, content)
+		if err != nil {
+			return "", service.Delta{}, err
+		}
+		return savedURL, d, nil
+	}
+
+	return "", service.Delta{}, fmt.Errorf("No delta of %s could be applied to the cached versions", item.FullName)
+}
+
+// signMeta signs the canonical json of a payload type, for example the meta data,
+// and returns the signature envelope. The private key could be in any format
+// utils.ImportPrivateKey supports.
+func signMeta(privBytes []byte, payloadType string, metaBytes []byte) ([]byte, error) {
+	payload, err := utils.CanonicalizeJSON(metaBytes)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, err = utils.ImportPrivateKey(privBytes, "")
+	if err != nil {
+		return nil, err
+	}
+	pubBytes, err := utils.GetPublicKeyFromPrivate(privBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	env := utils.NewSignatureEnvelope(payloadType)
+	sig, err := utils.SHA256Sign(privBytes, env.PAE(payload))
+	if err != nil {
+		return nil, err
+	}
+	if err := env.AddSignature(pubBytes, sig); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(env)
+}
+
 // UpdateClientConfig is the local configuation of a update client
 type UpdateClientConfig struct {
 	CacheDir    string
@@ -251,22 +951,52 @@ func (ucc *UpdateClientConfig) save() error {
 	return nil
 }
 
-// Add adds a repo url to the config file
-func (ucc *UpdateClientConfig) Add(proto, url string) error {
+// Add adds a repo url to the config file, 'rootKey' is the PEM public key of
+// the offline root key of the repo or empty, 'channel' is the release channel
+// to sync or empty for the whole repo
+func (ucc *UpdateClientConfig) Add(proto, url string, rootKey string, channel string) error {
 	if proto == "" || url == "" {
 		return errors.New("Proto and URL cannot be empty")
 	}
+	if _, err := protocol.GetProtocol(proto); err != nil {
+		return err
+	}
+	if channel != "" && !service.IsValidChannel(channel) {
+		return fmt.Errorf("Invalid channel name: '%s'", channel)
+	}
 
 	for _, repo := range ucc.Repos {
 		if repo.Proto == proto && repo.URL == url {
 			return ErrorsUCRepoAlreadyExist
 		}
 	}
-	ucc.Repos = append(ucc.Repos, UpdateClientRepo{Proto: proto, URL: url})
+	ucc.Repos = append(ucc.Repos, UpdateClientRepo{Proto: proto, URL: url, RootKey: rootKey, Channel: channel})
 
 	return ucc.save()
 }
 
+// GetRootKey gets the root key pinned to a repo url
+func (ucc *UpdateClientConfig) GetRootKey(proto, url string) string {
+	for _, repo := range ucc.Repos {
+		if repo.Proto == proto && repo.URL == url {
+			return repo.RootKey
+		}
+	}
+
+	return ""
+}
+
+// GetChannel gets the release channel of a repo url
+func (ucc *UpdateClientConfig) GetChannel(proto, url string) string {
+	for _, repo := range ucc.Repos {
+		if repo.Proto == proto && repo.URL == url {
+			return repo.Channel
+		}
+	}
+
+	return ""
+}
+
 // Remove removes a repo url from the config file
 func (ucc *UpdateClientConfig) Remove(proto, url string) error {
 	if url == "" {
@@ -19,6 +19,9 @@ func main() {
 		listCommand,
 		pushCommand,
 		pullCommand,
+		promoteCommand,
+		signCommand,
+		keygenCommand,
 	}
 
 	app.Run(os.Args)
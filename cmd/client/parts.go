@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// PutParts puts a file as parts of 'partSize' bytes by 'jobs' concurrent uploads.
+// Parts already uploaded are skipped, so an interrupted upload could be resumed.
+func (ucr *UpdateClientRepo) PutParts(name string, content []byte, partSize int64, jobs int, mediaType string, annotations map[string]string, channels []string) error {
+	if partSize <= 0 {
+		return fmt.Errorf("Invalid part size: %d", partSize)
+	}
+
+	var parts [][]byte
+	for offset := int64(0); offset < int64(len(content)); offset += partSize {
+		end := offset + partSize
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		parts = append(parts, content[offset:end])
+	}
+	if len(parts) == 0 {
+		parts = append(parts, content)
+	}
+
+	m := service.Manifest{MediaType: mediaType, Annotations: annotations, Channels: channels}
+	for _, part := range parts {
+		sha, err := utils.SHA512(part)
+		if err != nil {
+			return err
+		}
+		m.Parts = append(m.Parts, service.ManifestPart{SHA512: sha, Length: int64(len(part))})
+	}
+
+	err := runJobs(len(parts), jobs, func(i int) error {
+		exist, err := ucr.protoRepo.HasPart(m.Parts[i].SHA512, "")
+		if err != nil || exist {
+			return err
+		}
+		_, err = ucr.protoRepo.PutPart(m.Parts[i].SHA512, "", parts[i])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if ucr.transaction != "" {
+		_, err = ucr.protoRepo.StageManifest(ucr.transaction, name, "", data)
+	} else {
+		_, err = ucr.protoRepo.PutManifest(name, "", data)
+	}
+	return err
+}
+
+// GetParts gets the parts of a multi-part file by 'jobs' concurrent downloads,
+// verifies each of them and joins them to the cache. Parts verified before are
+// not downloaded again, so an interrupted download could be resumed.
+func (ucr *UpdateClientRepo) GetParts(item service.UpdateServiceItem, jobs int) (string, error) {
+	if !item.IsMultiPart() {
+		return "", fmt.Errorf("%s is not a multi-part file", item.FullName)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", ucr.host, ucr.protoPath(), ucr.namespace, ucr.repository, item.FullName)
+	partKey := func(i int) string {
+		return fmt.Sprintf("%s.parts/%d", key, i)
+	}
+
+	err := runJobs(len(item.SHAS), jobs, func(i int) error {
+		if content, err := ucr.store.Get(partKey(i)); err == nil && item.VerifyPart(i, content) == nil {
+			return nil
+		}
+
+		content, status, err := ucr.protoRepo.PullPart(item.FullName, i, "")
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("Fail to get part %d of %s: %s", i, item.FullName, string(content))
+		}
+		if err := item.VerifyPart(i, content); err != nil {
+			return err
+		}
+		_, err = ucr.store.Put(partKey(i), content)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for i := range item.SHAS {
+		content, err := ucr.store.Get(partKey(i))
+		if err != nil {
+			return "", err
+		}
+		buf.Write(content)
+	}
+	savedURL, err := ucr.store.Put(key, buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	for i := range item.SHAS {
+		ucr.store.Delete(partKey(i))
+	}
+
+	return savedURL, nil
+}
+
+// runJobs runs 'job' for 0 to count-1 by at most 'jobs' goroutines and
+// returns the first error
+func runJobs(count, jobs int, job func(int) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, jobs)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := job(i); err != nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return firstErr
+}
@@ -2,16 +2,24 @@ package api
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/liangchenye/update-service/utils"
 )
 
+// AppV1Repo is the client of a repository of any protocol, see NewRepo
 type AppV1Repo struct {
 	URI        string
+	Proto      string
+	Version    string
 	Namespace  string
 	Repository string
 
@@ -19,6 +27,11 @@ type AppV1Repo struct {
 }
 
 func NewAppV1Repo(uri, n, r string) (AppV1Repo, error) {
+	return NewRepo(uri, "app", "v1", n, r)
+}
+
+// NewRepo creates the client of a repository of a protocol, like 'vm' and 'v1'
+func NewRepo(uri, proto, version, n, r string) (AppV1Repo, error) {
 	if uri == "" {
 		return AppV1Repo{}, errors.New("URI should not be empty")
 	}
@@ -30,12 +43,19 @@ func NewAppV1Repo(uri, n, r string) (AppV1Repo, error) {
 
 	var o AppV1Repo
 	o.URI = uri
+	o.Proto = proto
+	o.Version = version
 	o.Namespace = n
 	o.Repository = r
 	o.host = u.Host
 	return o, nil
 }
 
+// repoURL is the url of the repository, like 'https://host/app/v1/namespace/repository'
+func (o *AppV1Repo) repoURL() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", o.URI, o.Proto, o.Version, o.Namespace, o.Repository)
+}
+
 func (o *AppV1Repo) pullData(rawurl, token string) ([]byte, int, error) {
 	header := map[string]string{
 		"Host":          o.host,
@@ -57,31 +77,140 @@ func (o *AppV1Repo) pullData(rawurl, token string) ([]byte, int, error) {
 }
 
 func (o *AppV1Repo) GetMeta(token string) ([]byte, int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/%s/meta", o.URI, o.Namespace, o.Repository)
+	rawurl := fmt.Sprintf("%s/meta", o.repoURL())
+
+	return o.pullData(rawurl, token)
+}
+
+// GetChannelMeta gets the meta data of a release channel
+func (o *AppV1Repo) GetChannelMeta(channel string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/channels/%s/meta", o.repoURL(), channel)
+
+	return o.pullData(rawurl, token)
+}
+
+// GetChannelMetaSign gets the meta signature data of a release channel
+func (o *AppV1Repo) GetChannelMetaSign(channel string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/channels/%s/metasign", o.repoURL(), channel)
 
 	return o.pullData(rawurl, token)
 }
 
 func (o *AppV1Repo) GetMetaSign(token string) ([]byte, int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/%s/metasign", o.URI, o.Namespace, o.Repository)
+	rawurl := fmt.Sprintf("%s/metasign", o.repoURL())
 
 	return o.pullData(rawurl, token)
 }
 
+// GetPublicKey gets the public key of the repository, it falls back to the
+// namespace public key of servers without repository public keys
 func (o *AppV1Repo) GetPublicKey(token string) ([]byte, int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/pubkey", o.URI, o.Namespace)
+	rawurl := fmt.Sprintf("%s/pubkey", o.repoURL())
+	data, status, err := o.pullData(rawurl, token)
+	if err != nil || status == http.StatusOK {
+		return data, status, err
+	}
+
+	rawurl = fmt.Sprintf("%s/%s/%s/%s/pubkey", o.URI, o.Proto, o.Version, o.Namespace)
+	return o.pullData(rawurl, token)
+}
+
+// GetRole gets the role of the repository, it falls back to the namespace
+// role of servers without repository roles
+func (o *AppV1Repo) GetRole(token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/role", o.repoURL())
+	data, status, err := o.pullData(rawurl, token)
+	if err != nil || status == http.StatusOK {
+		return data, status, err
+	}
+
+	rawurl = fmt.Sprintf("%s/%s/%s/%s/role", o.URI, o.Proto, o.Version, o.Namespace)
+	return o.pullData(rawurl, token)
+}
+
+// GetRoleSign gets the root signatures of the role of the repository, it falls
+// back to the namespace role signatures as GetRole does
+func (o *AppV1Repo) GetRoleSign(token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/rolesign", o.repoURL())
+	data, status, err := o.pullData(rawurl, token)
+	if err != nil || status == http.StatusOK {
+		return data, status, err
+	}
+
+	rawurl = fmt.Sprintf("%s/%s/%s/%s/rolesign", o.URI, o.Proto, o.Version, o.Namespace)
+	return o.pullData(rawurl, token)
+}
+
+// GetRevocations gets the signed revocation list of the repository, it falls
+// back to the namespace revocation list as GetRole does
+func (o *AppV1Repo) GetRevocations(token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/revocations", o.repoURL())
+	data, status, err := o.pullData(rawurl, token)
+	if err != nil || status == http.StatusOK {
+		return data, status, err
+	}
+
+	rawurl = fmt.Sprintf("%s/%s/%s/%s/revocations", o.URI, o.Proto, o.Version, o.Namespace)
+	return o.pullData(rawurl, token)
+}
+
+// GetDelegations gets the signed delegation list of the repository
+func (o *AppV1Repo) GetDelegations(token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/delegations", o.repoURL())
+
+	return o.pullData(rawurl, token)
+}
+
+// GetDelegatedTargets gets the items of the repository signed by a delegation
+func (o *AppV1Repo) GetDelegatedTargets(name string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/delegations/%s", o.repoURL(), name)
+
+	return o.pullData(rawurl, token)
+}
+
+// GetTreeHead gets the signed tree head of the transparency log of the repository
+func (o *AppV1Repo) GetTreeHead(token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/log/sth", o.repoURL())
+
+	return o.pullData(rawurl, token)
+}
+
+// GetInclusionProof gets the proof that a leaf hash is in the log of a size
+func (o *AppV1Repo) GetInclusionProof(leafHash []byte, treeSize int64, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/log/proof?hash=%s&tree_size=%d", o.repoURL(), hex.EncodeToString(leafHash), treeSize)
+
+	return o.pullData(rawurl, token)
+}
+
+// GetConsistencyProof gets the proof that the log of size 'first' is a prefix of the log of size 'second'
+func (o *AppV1Repo) GetConsistencyProof(first, second int64, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/log/consistency?first=%d&second=%d", o.repoURL(), first, second)
 
 	return o.pullData(rawurl, token)
 }
 
 func (o *AppV1Repo) Pull(name string, token string) ([]byte, int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/%s/blob/%s", o.URI, o.Namespace, o.Repository, name)
+	rawurl := fmt.Sprintf("%s/blob/%s", o.repoURL(), name)
 
 	return o.pullData(rawurl, token)
 }
 
 func (o *AppV1Repo) PutFile(name string, token, uuid string, fileBytes []byte) (int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/%s/%s", o.URI, o.Namespace, o.Repository, name)
+	return o.PutFileWithMeta(name, token, uuid, fileBytes, "", nil, nil)
+}
+
+// PutFileWithMeta puts a file with its media type, annotations and release
+// channels, which are kept in the meta data of the file
+func (o *AppV1Repo) PutFileWithMeta(name string, token, uuid string, fileBytes []byte, mediaType string, annotations map[string]string, channels []string) (int, error) {
+	rawurl := fmt.Sprintf("%s/%s", o.repoURL(), name)
+
+	return o.putFile(rawurl, token, uuid, fileBytes, mediaType, annotations, channels)
+}
+
+func (o *AppV1Repo) putFile(rawurl string, token, uuid string, fileBytes []byte, mediaType string, annotations map[string]string, channels []string) (int, error) {
+	if len(channels) > 0 {
+		rawurl += "?" + url.Values{"channel": channels}.Encode()
+	}
 
 	sha512Sum, err := utils.SHA512(fileBytes)
 	if err != nil {
@@ -95,15 +224,216 @@ func (o *AppV1Repo) PutFile(name string, token, uuid string, fileBytes []byte) (
 		"App-Upload-UUID": uuid,
 		"Digest":          digest,
 	}
-	resp, err := sendHttpRequest("PUT", rawurl, bytes.NewReader(fileBytes), header)
+	if mediaType != "" {
+		header["Content-Type"] = mediaType
+	}
+	body := fileBytes
+	// annotations could be multi-line, they are sent as json with the file
+	if len(annotations) > 0 {
+		if body, header["Content-Type"], err = fileForm(fileBytes, mediaType, annotations); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := sendHttpRequest("PUT", rawurl, bytes.NewReader(body), header)
+	if err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+// fileForm makes a 'multipart/form-data' upload of a file, with its media type
+// and annotations in the json 'meta' field, it returns the body and its type
+func fileForm(fileBytes []byte, mediaType string, annotations map[string]string) ([]byte, string, error) {
+	meta, err := json.Marshal(map[string]interface{}{"mediaType": mediaType, "annotations": annotations})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("meta", string(meta)); err != nil {
+		return nil, "", err
+	}
+	part, err := w.CreateFormFile("file", "file")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := part.Write(fileBytes); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// ListVersions gets the semantic versions of a name
+func (o *AppV1Repo) ListVersions(name string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/versions/%s", o.repoURL(), name)
+
+	return o.pullData(rawurl, token)
+}
+
+// Resolve gets the meta data of the version of a name resolved by a constraint,
+// among the versions of a release channel if 'channel' is not empty
+func (o *AppV1Repo) Resolve(name, constraint, channel string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/resolve/%s?constraint=%s", o.repoURL(), name, url.QueryEscape(constraint))
+	if channel != "" {
+		rawurl += "&channel=" + url.QueryEscape(channel)
+	}
+
+	return o.pullData(rawurl, token)
+}
+
+// ResolvePlatform gets the meta data of the version of a name resolved by a constraint
+// which runs best on an OS and an architecture
+func (o *AppV1Repo) ResolvePlatform(name, constraint, channel, os, arch string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/platform/%s?os=%s&arch=%s&constraint=%s", o.repoURL(), name,
+		url.QueryEscape(os), url.QueryEscape(arch), url.QueryEscape(constraint))
+	if channel != "" {
+		rawurl += "&channel=" + url.QueryEscape(channel)
+	}
+
+	return o.pullData(rawurl, token)
+}
+
+// PullPart gets a part of a multi-part file by its index
+func (o *AppV1Repo) PullPart(name string, index int, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/blob/%s/parts/%d", o.repoURL(), name, index)
+
+	return o.pullData(rawurl, token)
+}
+
+// PullDelta gets the binary delta of a file from a previous version
+func (o *AppV1Repo) PullDelta(name, from string, token string) ([]byte, int, error) {
+	rawurl := fmt.Sprintf("%s/deltas/%s/%s", o.repoURL(), name, from)
+
+	return o.pullData(rawurl, token)
+}
+
+// HasPart tells if a part of a multi-part file, by its sha512, is uploaded
+func (o *AppV1Repo) HasPart(sha512 string, token string) (bool, error) {
+	rawurl := fmt.Sprintf("%s/parts/%s", o.repoURL(), sha512)
+	header := map[string]string{
+		"Host":          o.host,
+		"Authorization": token,
+	}
+	resp, err := sendHttpRequest("HEAD", rawurl, nil, header)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("Fail to check the part <%s>: %s", sha512, resp.Status)
+}
+
+// PutPart uploads a part of a multi-part file by its sha512
+func (o *AppV1Repo) PutPart(sha512 string, token string, partBytes []byte) (int, error) {
+	rawurl := fmt.Sprintf("%s/parts/%s", o.repoURL(), sha512)
+
+	return o.sendData("PUT", rawurl, token, partBytes)
+}
+
+// PutManifest adds a multi-part file by the manifest of its uploaded parts
+func (o *AppV1Repo) PutManifest(name string, token string, manifestBytes []byte) (int, error) {
+	rawurl := fmt.Sprintf("%s/%s/manifest", o.repoURL(), name)
+
+	return o.sendData("PUT", rawurl, token, manifestBytes)
+}
+
+// Promote moves a file from a release channel to another one, it is only added
+// to 'to' if 'from' is empty
+func (o *AppV1Repo) Promote(name, from, to string, token string) (int, error) {
+	rawurl := fmt.Sprintf("%s/%s/promote?from=%s&to=%s", o.repoURL(), name, url.QueryEscape(from), url.QueryEscape(to))
+
+	return o.sendData("POST", rawurl, token, nil)
+}
+
+// BeginTransaction begins a transaction to publish files together and returns its id
+func (o *AppV1Repo) BeginTransaction(token string) (string, error) {
+	rawurl := fmt.Sprintf("%s/transactions", o.repoURL())
+	header := map[string]string{
+		"Host":          o.host,
+		"Authorization": token,
+	}
+	resp, err := sendHttpRequest("POST", rawurl, nil, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Fail to begin a transaction: %s", string(body))
+	}
+
+	var ret struct {
+		Content string
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return "", err
+	}
+	return ret.Content, nil
+}
+
+// StageFile stages a file with its media type, annotations and release channels in a transaction
+func (o *AppV1Repo) StageFile(id, name string, token string, fileBytes []byte, mediaType string, annotations map[string]string, channels []string) (int, error) {
+	rawurl := fmt.Sprintf("%s/transactions/%s/%s", o.repoURL(), id, name)
+
+	return o.putFile(rawurl, token, "", fileBytes, mediaType, annotations, channels)
+}
+
+// StageManifest stages a multi-part file in a transaction by the manifest of its uploaded parts
+func (o *AppV1Repo) StageManifest(id, name string, token string, manifestBytes []byte) (int, error) {
+	rawurl := fmt.Sprintf("%s/transactions/%s/%s/manifest", o.repoURL(), id, name)
+
+	return o.sendData("PUT", rawurl, token, manifestBytes)
+}
+
+// CommitTransaction publishes the files staged in a transaction
+func (o *AppV1Repo) CommitTransaction(id string, token string) (int, error) {
+	rawurl := fmt.Sprintf("%s/transactions/%s/commit", o.repoURL(), id)
+
+	return o.sendData("POST", rawurl, token, nil)
+}
+
+// AbortTransaction drops a transaction and the files staged in it
+func (o *AppV1Repo) AbortTransaction(id string, token string) (int, error) {
+	rawurl := fmt.Sprintf("%s/transactions/%s", o.repoURL(), id)
+
+	return o.sendData("DELETE", rawurl, token, nil)
+}
+
+func (o *AppV1Repo) sendData(method, rawurl, token string, data []byte) (int, error) {
+	header := map[string]string{
+		"Host":          o.host,
+		"Authorization": token,
+	}
+	resp, err := sendHttpRequest(method, rawurl, bytes.NewReader(data), header)
 	if err != nil {
 		return 0, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("Fail to %s %s: %s", strings.ToLower(method), rawurl, string(body))
+	}
 	return resp.StatusCode, nil
 }
 
 func (o *AppV1Repo) Delete(name string, token string) (int, error) {
-	rawurl := fmt.Sprintf("%s/app/v1/%s/%s/%s", o.URI, o.Namespace, o.Repository, name)
+	rawurl := fmt.Sprintf("%s/%s", o.repoURL(), name)
 	header := map[string]string{
 		"Host":          o.host,
 		"Authorization": token,
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+var blobCommand = cli.Command{
+	Name:  "blob",
+	Usage: "Handle the files of a repository",
+	Subcommands: []cli.Command{
+		{
+			Name:      "decrypt",
+			Usage:     "decrypt a file pushed by 'uc push --encrypt' through the key manager",
+			ArgsUsage: "name [output file]",
+			Flags:     repositoryFlags,
+			Action:    runBlobDecrypt,
+		},
+	},
+}
+
+func runBlobDecrypt(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	store, err := storage.DefaultUpdateServiceStorage()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", a.Proto, a.Version, a.Namespace, a.Repository, c.Args().Get(0))
+	data, err := store.Get(key)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	env, err := utils.ParseEncryptedEnvelope(data)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	plain, err := env.Decrypt(func(wrapped []byte) ([]byte, error) {
+		return km.Decrypt(a, wrapped)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Get(1) == "" {
+		_, err = os.Stdout.Write(plain)
+		return err
+	}
+	if err := ioutil.WriteFile(c.Args().Get(1), plain, 0600); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("Success in decrypting %s to %s.\n", c.Args().Get(0), c.Args().Get(1))
+	return nil
+}
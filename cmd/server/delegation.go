@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+var delegationNameFlag = cli.StringFlag{
+	Name:  "name",
+	Usage: "the name of the delegation",
+}
+
+var delegationCommand = cli.Command{
+	Name:  "delegation",
+	Usage: "Delegate the signing of sub-paths of a repository",
+	Description: "Trust other keys to sign the items matching path patterns: add a delegation, " +
+		"export-targets, sign them by 'uc sign' with the delegated keys and then import-targets.",
+	Subcommands: []cli.Command{
+		{
+			Name:      "add",
+			Usage:     "trust the keys to sign the items matching the path patterns, a delegation of the same name is replaced",
+			ArgsUsage: "public key files...",
+			Flags: append([]cli.Flag{
+				delegationNameFlag,
+				cli.StringSliceFlag{
+					Name:  "path",
+					Usage: "the path pattern of the full names, for example 'linux/amd64/*'",
+				},
+				cli.IntFlag{
+					Name:  "threshold",
+					Value: 1,
+					Usage: "how many signatures of the keys are required",
+				},
+			}, repositoryFlags...),
+			Action: runDelegationAdd,
+		},
+		{
+			Name:   "remove",
+			Usage:  "remove a delegation and its signed targets",
+			Flags:  append([]cli.Flag{delegationNameFlag}, repositoryFlags...),
+			Action: runDelegationRemove,
+		},
+		{
+			Name:   "list",
+			Usage:  "list the delegations",
+			Flags:  repositoryFlags,
+			Action: runDelegationList,
+		},
+		{
+			Name:      "export-targets",
+			Usage:     "export the items of a delegation to be signed by its keys",
+			ArgsUsage: "[output file]",
+			Flags:     append([]cli.Flag{delegationNameFlag}, repositoryFlags...),
+			Action:    runDelegationExportTargets,
+		},
+		{
+			Name:      "import-targets",
+			Usage:     "import the exported targets with the signatures made by 'uc sign'",
+			ArgsUsage: "targets file signature file",
+			Flags:     repositoryFlags,
+			Action:    runDelegationImportTargets,
+		},
+		{
+			Name:      "export",
+			Usage:     "export the delegation list to be signed by the other keys of the role",
+			ArgsUsage: "[output file]",
+			Flags:     repositoryFlags,
+			Action:    runDelegationExport,
+		},
+		{
+			Name:      "import-signatures",
+			Usage:     "import the delegation list signatures made by 'uc sign'",
+			ArgsUsage: "signature file",
+			Flags:     repositoryFlags,
+			Action:    runDelegationImportSignatures,
+		},
+	},
+}
+
+func runDelegationAdd(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var pubKeys [][]byte
+	for _, file := range c.Args() {
+		pubBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		pubKeys = append(pubKeys, pubBytes)
+	}
+
+	d, err := utils.NewDelegation(c.String("name"), c.StringSlice("path"), c.Int("threshold"), pubKeys...)
+	if err == nil {
+		err = us.Delegate(d)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Success in delegating %s to %d of %d keys.\n", strings.Join(d.Paths, ","), d.Role.Threshold, len(d.Role.Keys))
+	return nil
+}
+
+func runDelegationRemove(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := us.RemoveDelegation(c.String("name")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("Success in removing the delegation %s.\n", c.String("name"))
+	return nil
+}
+
+func runDelegationList(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	for _, d := range ds.Delegations {
+		fmt.Printf("%-10s %d/%d %s\n", d.Name, d.Role.Threshold, len(d.Role.Keys), strings.Join(d.Paths, ","))
+	}
+	return nil
+}
+
+func runDelegationExportTargets(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	data, err := us.ExportDelegatedTargets(c.String("name"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return writeOutput(c, data, "delegated targets")
+}
+
+func runDelegationImportTargets(c *cli.Context) error {
+	if len(c.Args()) != 2 {
+		err := errors.New("wrong syntax: import-targets 'targets file' 'signature file'")
+		fmt.Println(err)
+		return err
+	}
+
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	payload, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	sigs, err := ioutil.ReadFile(c.Args().Get(1))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := us.ImportDelegatedTargets(payload, sigs); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in importing the delegated targets.")
+	return nil
+}
+
+func runDelegationExport(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	data, err := us.ExportDelegations()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return writeOutput(c, data, "delegations")
+}
+
+func runDelegationImportSignatures(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	data, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := us.ImportDelegationsSignatures(data); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in importing the delegation signatures.")
+	return nil
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/protocol"
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const protocolDataKey = "protocol"
+
+// ProtocolHandler keeps the protocol of the routes it serves for the handlers,
+// the same handlers serve the repositories of every protocol
+func ProtocolHandler(p protocol.Protocol) macaron.Handler {
+	return func(ctx *macaron.Context) {
+		ctx.Data[protocolDataKey] = p
+	}
+}
+
+// Routes adds the routes of the namespaces and repositories, which are shared by
+// every protocol, to the group of a protocol
+func Routes(r *macaron.Router) {
+	r.Group("/:namespace", func() {
+		r.Get("/pubkey", GetPublicKeyHandler)
+		// Get the keys and threshold to verify meta signatures
+		r.Get("/role", GetRoleHandler)
+		// Get the offline root signatures of the role
+		r.Get("/rolesign", GetRoleSignHandler)
+		// Get the signed list of revoked keys
+		r.Get("/revocations", GetRevocationsHandler)
+	})
+	r.Group("/:namespace/:repository", func() {
+		// List files
+		r.Get("/", ListFileHandler)
+		// Get pub key of the whole repo
+		r.Get("/pubkey", GetPublicKeyHandler)
+		// Get the keys and threshold to verify meta signatures of the repo
+		r.Get("/role", GetRoleHandler)
+		// Get the offline root signatures of the role of the repo
+		r.Get("/rolesign", GetRoleSignHandler)
+		// Get the signed list of revoked keys of the repo
+		r.Get("/revocations", GetRevocationsHandler)
+		// Get meta data of the whole repo
+		r.Get("/meta", GetMetaHandler)
+		// Get meta signature data of the whole repo
+		r.Get("/metasign", GetMetaSignHandler)
+		// List the release channels of the repo
+		r.Get("/channels", ListChannelsHandler)
+		// Get meta data of a release channel of the repo
+		r.Get("/channels/:channel/meta", GetChannelMetaHandler)
+		// Get meta signature data of a release channel of the repo
+		r.Get("/channels/:channel/metasign", GetChannelMetaSignHandler)
+		// Get the signed delegations of sub-paths of the repo
+		r.Get("/delegations", GetDelegationsHandler)
+		// Get the items signed by a delegation
+		r.Get("/delegations/:delegation", GetDelegatedTargetsHandler)
+		// Get the signed tree head of the transparency log of the meta data
+		r.Get("/log/sth", GetTreeHeadHandler)
+		// Get the inclusion proof of a version of the meta data
+		r.Get("/log/proof", GetInclusionProofHandler)
+		// Get the consistency proof of two tree sizes
+		r.Get("/log/consistency", GetConsistencyProofHandler)
+		// Get a leaf of the transparency log
+		r.Get("/log/entries/:index", GetLogEntryHandler)
+		// List the semantic versions of a file
+		r.Get("/versions/:name", ListVersionsHandler)
+		// Resolve a version constraint of a file, like '^1.4', to its meta data
+		r.Get("/resolve/:name", ResolveHandler)
+		// Resolve a file to the file which runs best on a platform, like 'linux' and 'arm64'
+		r.Get("/platform/:name", ResolvePlatformHandler)
+		// Get file data of a file
+		r.Get("/blob/:name", GetFileHandler)
+		// Get a part of a multi-part file by its index
+		r.Get("/blob/:name/parts/:index", GetFilePartHandler)
+		// Get the binary delta of a file from a previous version
+		r.Get("/deltas/:name/:from", GetDeltaHandler)
+		// Check if a part of a multi-part file is uploaded
+		r.Head("/parts/:digest", HeadPartHandler)
+		// Upload a part of a multi-part file by its sha512
+		r.Put("/parts/:digest", PutPartHandler)
+		// Begin a transaction to publish files under one signature
+		r.Post("/transactions", BeginTransactionHandler)
+		// Get the files staged in a transaction
+		r.Get("/transactions/:transaction", GetTransactionHandler)
+		// Stage a file in a transaction
+		r.Put("/transactions/:transaction/:name", StageFileHandler)
+		// Stage a multi-part file in a transaction by the manifest of its parts
+		r.Put("/transactions/:transaction/:name/manifest", StageManifestHandler)
+		// Publish the staged files of a transaction
+		r.Post("/transactions/:transaction/commit", CommitTransactionHandler)
+		// Drop a transaction and its staged files
+		r.Delete("/transactions/:transaction", AbortTransactionHandler)
+		// Add a file to the repo
+		r.Put("/:name", PutFileHandler)
+		// Add a multi-part file to the repo by the manifest of its parts
+		r.Put("/:name/manifest", PutManifestHandler)
+		// Move a file from a release channel to another one
+		r.Post("/:name/promote", PromoteHandler)
+	})
+}
+
+// protocolOf gets the protocol of a request, 'app/v1' by default
+func protocolOf(ctx *macaron.Context) protocol.Protocol {
+	if p, ok := ctx.Data[protocolDataKey].(protocol.Protocol); ok {
+		return p
+	}
+	return &protocol.App{}
+}
+
+// appliance gets the namespace/repository of the protocol of a request
+func appliance(ctx *macaron.Context, namespace, repository string) utils.Appliance {
+	p := protocolOf(ctx)
+	return utils.Appliance{Proto: p.Proto(), Version: p.Version(), Namespace: namespace, Repository: repository}
+}
+
+// updateService loads the namespace/repository of the protocol of a request
+func updateService(ctx *macaron.Context, namespace, repository string) (service.UpdateService, error) {
+	p := protocolOf(ctx)
+	return service.DefaultUpdateService(p.Proto(), p.Version(), namespace, repository)
+}
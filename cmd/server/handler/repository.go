@@ -0,0 +1,699 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	// annotationHeaderPrefix is the prefix of the headers annotating an uploaded file
+	annotationHeaderPrefix = "App-Annotation-"
+	// maxFileMetaSize is the size of the largest meta data of an uploaded file
+	maxFileMetaSize = 1 << 20
+	// defaultUploadsDir keeps the uploaded blobs until the meta data is saved
+	defaultUploadsDir = "uploads"
+)
+
+type httpListRet struct {
+	Message string
+	Content interface{}
+}
+
+//TODO: better http return result
+func httpRet(head string, content interface{}, err error) (int, []byte) {
+	var ret httpListRet
+	var code int
+
+	if err != nil {
+		ret.Message = head + " fail"
+		ret.Content = err.Error()
+		code = http.StatusBadRequest
+	} else {
+		ret.Message = head
+		ret.Content = content
+		code = http.StatusOK
+	}
+
+	result, _ := json.Marshal(ret)
+	return code, result
+}
+
+// ListFileHandler lists  all the files in the namespace/repository,
+// or the files of a platform by the 'os' and 'arch' queries
+func ListFileHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	var apps []string
+	var err error
+	if ctx.Query("os") != "" || ctx.Query("arch") != "" {
+		apps, err = us.ListPlatform(ctx.Query("os"), ctx.Query("arch"))
+	} else {
+		apps, err = us.List()
+	}
+
+	return httpRet("List files", apps, err)
+}
+
+// GetPublicKeyHandler gets the public key of a namespace or a namespace/repository
+func GetPublicKeyHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	a := appliance(ctx, namespace, repository)
+	km, _ := keymanager.DefaultKeyManager()
+	data, err := km.GetPublicKey(a)
+	if err == nil {
+		return http.StatusOK, data
+	}
+
+	return httpRet("Get Public Key", nil, err)
+}
+
+// GetRoleHandler gets the keys trusted to sign the meta data of a namespace or
+// a namespace/repository and the threshold of them
+func GetRoleHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	a := appliance(ctx, namespace, repository)
+	km, _ := keymanager.DefaultKeyManager()
+	if km == nil {
+		return httpRet("Get Role", nil, keymanager.ErrorsKMNotSupported)
+	}
+
+	role, err := km.GetRole(a)
+	if err != nil {
+		return httpRet("Get Role", nil, err)
+	}
+
+	// root signatures are made over the canonical json of the role
+	data, err := utils.CanonicalJSON(role)
+	if err != nil {
+		return httpRet("Get Role", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetRoleSignHandler gets the signatures of the role of a namespace or a
+// namespace/repository made by an offline root key
+func GetRoleSignHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	a := appliance(ctx, namespace, repository)
+	km, _ := keymanager.DefaultKeyManager()
+	if km == nil {
+		return httpRet("Get Role Sign", nil, keymanager.ErrorsKMNotSupported)
+	}
+
+	data, err := km.GetRoleSign(a)
+	if err != nil {
+		return httpRet("Get Role Sign", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetRevocationsHandler gets the signed list of the revoked keys of a namespace
+// or a namespace/repository
+func GetRevocationsHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	a := appliance(ctx, namespace, repository)
+	km, _ := keymanager.DefaultKeyManager()
+	if km == nil {
+		return httpRet("Get Revocations", nil, keymanager.ErrorsKMNotSupported)
+	}
+
+	data, err := km.GetRevocations(a)
+	if err != nil {
+		return httpRet("Get Revocations", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetMetaHandler gets the meta data of all the namespace/repository
+func GetMetaHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetMeta()
+	if err == nil {
+		return http.StatusOK, data
+	}
+
+	return httpRet("Get Meta", nil, err)
+}
+
+// GetMetaSignHandler gets the meta signature data of all the namespace/repository
+func GetMetaSignHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetMetaSign()
+	if err != nil {
+		return httpRet("Get Meta Sign", data, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// ListChannelsHandler lists the release channels of a namespace/repository
+func ListChannelsHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	channels, err := us.ListChannels()
+	if err != nil {
+		return httpRet("List Channels", nil, err)
+	}
+
+	data, _ := json.Marshal(channels)
+	return http.StatusOK, data
+}
+
+// GetChannelMetaHandler gets the meta data of a release channel
+func GetChannelMetaHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetChannelMeta(ctx.Params(":channel"))
+	if err != nil {
+		return httpRet("Get Channel Meta", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetChannelMetaSignHandler gets the meta signature data of a release channel
+func GetChannelMetaSignHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetChannelMetaSign(ctx.Params(":channel"))
+	if err != nil {
+		return httpRet("Get Channel Meta Sign", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// PromoteHandler moves a file from the 'from' release channel to the 'to' one
+func PromoteHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.Promote(ctx.Params(":name"), ctx.Query("from"), ctx.Query("to"))
+
+	return httpRet("Promote", nil, err)
+}
+
+// GetDelegationsHandler gets the delegation list of a namespace/repository
+// signed by the key of the repository
+func GetDelegationsHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetDelegations()
+	if err != nil {
+		return httpRet("Get Delegations", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetDelegatedTargetsHandler gets the items of a namespace/repository signed by a delegation
+func GetDelegatedTargetsHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	delegation := ctx.Params(":delegation")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetDelegatedTargets(delegation)
+	if err != nil {
+		return httpRet("Get Delegated Targets", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetTreeHeadHandler gets the signed tree head of the transparency log of a namespace/repository
+func GetTreeHeadHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetTreeHead()
+	if err != nil {
+		return httpRet("Get Tree Head", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetInclusionProofHandler proves a leaf, by the hex encoded 'hash' query, is in
+// the transparency log of the 'tree_size' query
+func GetInclusionProofHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	leafHash, err := hex.DecodeString(ctx.Query("hash"))
+	if err != nil {
+		return httpRet("Get Inclusion Proof", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	proof, err := us.GetInclusionProof(leafHash, ctx.QueryInt64("tree_size"))
+	if err != nil {
+		return httpRet("Get Inclusion Proof", nil, err)
+	}
+
+	data, _ := json.Marshal(proof)
+	return http.StatusOK, data
+}
+
+// GetConsistencyProofHandler proves the transparency log of the 'first' size is
+// a prefix of the log of the 'second' size
+func GetConsistencyProofHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	proof, err := us.GetConsistencyProof(ctx.QueryInt64("first"), ctx.QueryInt64("second"))
+	if err != nil {
+		return httpRet("Get Consistency Proof", nil, err)
+	}
+
+	data, _ := json.Marshal(proof)
+	return http.StatusOK, data
+}
+
+// GetLogEntryHandler gets a leaf of the transparency log for auditors
+func GetLogEntryHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	index, err := strconv.ParseInt(ctx.Params(":index"), 10, 64)
+	if err != nil {
+		return httpRet("Get Log Entry", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetLogEntry(index)
+	if err != nil {
+		return httpRet("Get Log Entry", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetFileHandler gets the content of a file
+func GetFileHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	name := ctx.Params(":name")
+
+	a := appliance(ctx, namespace, repository)
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", a.Proto, a.Version, namespace, repository, name)
+	store, _ := storage.DefaultUpdateServiceStorage()
+	data, err := store.Get(key)
+	if err == storage.ErrorsNotFound {
+		// a multi-part file has no blob, its parts are joined
+		us, _ := updateService(ctx, namespace, repository)
+		if item, itemErr := us.GetItem(name); itemErr == nil && item.IsMultiPart() {
+			data, err = us.GetItemContent(name)
+		}
+	}
+	if err != nil {
+		return httpRet("Get File", data, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// ListVersionsHandler lists the semantic versions of a file in ascending order
+func ListVersionsHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, _ := json.Marshal(us.ListVersions(ctx.Params(":name")))
+
+	return http.StatusOK, data
+}
+
+// ResolveHandler gets the meta data of the version of a file resolved by the
+// 'constraint' query, for example '^1.4', the latest version by default.
+// Only the versions in the 'channel' query are resolved if it is given.
+func ResolveHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	item, err := us.Resolve(ctx.Params(":name"), ctx.Query("constraint"), ctx.Query("channel"))
+	if err != nil {
+		return httpRet("Resolve Version", nil, err)
+	}
+
+	data, _ := json.Marshal(item)
+	return http.StatusOK, data
+}
+
+// ResolvePlatformHandler gets the meta data of the version of a file which runs best
+// on the platform of the 'os' and 'arch' queries, for example 'linux-386-name' on 'linux'
+// and 'amd64' if there is no 'linux-amd64-name'. 'constraint' and 'channel' work as
+// ResolveHandler.
+func ResolvePlatformHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	item, err := us.ResolvePlatform(ctx.Params(":name"), ctx.Query("constraint"), ctx.Query("channel"), ctx.Query("os"), ctx.Query("arch"))
+	if err != nil {
+		return httpRet("Resolve Platform", nil, err)
+	}
+
+	data, _ := json.Marshal(item)
+	return http.StatusOK, data
+}
+
+// GetDeltaHandler gets the binary delta to build a file from a previous version,
+// the deltas of a file are listed in its meta data
+func GetDeltaHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetDelta(ctx.Params(":name"), ctx.Params(":from"))
+	if err != nil {
+		return httpRet("Get Delta", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// GetFilePartHandler gets a part of a multi-part file by its index
+func GetFilePartHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	name := ctx.Params(":name")
+
+	index, err := strconv.Atoi(ctx.Params(":index"))
+	if err != nil {
+		return httpRet("Get File Part", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	data, err := us.GetItemPart(name, index)
+	if err != nil {
+		return httpRet("Get File Part", nil, err)
+	}
+
+	return http.StatusOK, data
+}
+
+// HeadPartHandler tells if a part, by its sha512, is uploaded
+func HeadPartHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	if _, err := us.GetPart(ctx.Params(":digest")); err == storage.ErrorsNotFound {
+		return http.StatusNotFound, nil
+	} else if err != nil {
+		return http.StatusBadRequest, nil
+	}
+
+	return http.StatusOK, nil
+}
+
+// PutPartHandler uploads a part of a multi-part file by its sha512
+func PutPartHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	data, _ := ctx.Req.Body().Bytes()
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.PutPart(ctx.Params(":digest"), data)
+
+	return httpRet("Put Part", nil, err)
+}
+
+// PutManifestHandler adds a multi-part file by the manifest of its uploaded parts
+func PutManifestHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	name := ctx.Params(":name")
+
+	data, _ := ctx.Req.Body().Bytes()
+	var m service.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return httpRet("Put Manifest", nil, err)
+	}
+	if _, err := protocolOf(ctx).ParseFullName(name); err != nil {
+		return httpRet("Put Manifest", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.PutManifest(name, m)
+
+	return httpRet("Put Manifest", nil, err)
+}
+
+// PutFileHandler posts the content of a file
+func PutFileHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+	name := ctx.Params(":name")
+
+	// the name is a part of the storage key, it is checked before anything is stored
+	if _, err := protocolOf(ctx).ParseFullName(name); err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	data, meta, err := fileFromRequest(ctx)
+	if err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	a := appliance(ctx, namespace, repository)
+	// the blob is staged and moved into place after the meta data is saved, a
+	// failure never overwrites or removes the blob of the published meta data
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	stagedKey := fmt.Sprintf("%s/%s/%s/%s/%s/%x", a.Proto, a.Version, namespace, repository, defaultUploadsDir, b)
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", a.Proto, a.Version, namespace, repository, name)
+	store, _ := storage.DefaultUpdateServiceStorage()
+	_, err = store.Put(stagedKey, data)
+	if err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	defer store.Delete(stagedKey)
+
+	us, _ := updateService(ctx, namespace, repository)
+	item, err := itemFromRequest(ctx, name, data, meta)
+	if err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	us.Debug()
+	if clearChannels(ctx) {
+		err = us.Replace(item)
+	} else {
+		err = us.Put(item)
+	}
+	if err != nil {
+		return httpRet("Put data", nil, err)
+	}
+	if _, err = store.Put(key, data); err != nil {
+		return httpRet("Put data", nil, err)
+	}
+
+	return httpRet("Put File", nil, nil)
+}
+
+// itemFromRequest gets the meta data of an uploaded file by its protocol, with its
+// media type, annotations and release channels
+func itemFromRequest(ctx *macaron.Context, name string, data []byte, meta service.Manifest) (service.UpdateServiceItem, error) {
+	item, err := protocolOf(ctx).NewItem(name, data, meta.MediaType, meta.Annotations)
+	for _, c := range append(meta.Channels, ctx.QueryStrings("channel")...) {
+		if err == nil && c != "" {
+			err = item.AddChannel(c)
+		}
+	}
+
+	return item, err
+}
+
+// clearChannels tells if an empty 'channel' is set, the file uploaded again
+// leaves the release channels not set, which it stays in by default
+func clearChannels(ctx *macaron.Context) bool {
+	for _, c := range ctx.QueryStrings("channel") {
+		if c == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fileFromRequest gets the content of an uploaded file and its meta data.
+// A 'multipart/form-data' upload has the file in the 'file' field and a json
+// service.Manifest without parts in the 'meta' field. Other uploads are the
+// file itself, of the 'Content-Type' and annotated by the 'App-Annotation-<Key>'
+// headers, keys are in lower case.
+func fileFromRequest(ctx *macaron.Context) ([]byte, service.Manifest, error) {
+	mediaType, params, err := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		data, err := ctx.Req.Body().Bytes()
+		meta := service.Manifest{MediaType: ctx.Req.Header.Get("Content-Type"), Annotations: annotations(ctx.Req.Header)}
+		return data, meta, err
+	}
+
+	var data []byte
+	var meta service.Manifest
+	found := false
+	r := multipart.NewReader(ctx.Req.Body().ReadCloser(), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, meta, fmt.Errorf("Fail to read the upload: %v", err)
+		}
+
+		switch part.FormName() {
+		case "meta":
+			err = json.NewDecoder(io.LimitReader(part, maxFileMetaSize)).Decode(&meta)
+			if err == nil && len(meta.Parts) > 0 {
+				err = errors.New("the meta data of a file should have no parts")
+			}
+		case "file":
+			data, err = ioutil.ReadAll(part)
+			found = true
+		}
+		if err != nil {
+			return nil, meta, fmt.Errorf("Fail to read the %s of the upload: %v", part.FormName(), err)
+		}
+	}
+	if !found {
+		return nil, meta, errors.New("Fail to find the file of the upload")
+	}
+
+	return data, meta, nil
+}
+
+// annotations gets the annotations of a file from the 'App-Annotation-<Key>'
+// headers of old clients, keys are in lower case
+func annotations(header http.Header) map[string]string {
+	values := make(map[string]string)
+	for k := range header {
+		if strings.HasPrefix(k, annotationHeaderPrefix) && len(k) > len(annotationHeaderPrefix) {
+			values[strings.ToLower(k[len(annotationHeaderPrefix):])] = header.Get(k)
+		}
+	}
+
+	return values
+}
+
+// BeginTransactionHandler begins a transaction to publish files together
+func BeginTransactionHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	id, err := us.Begin()
+
+	return httpRet("Begin Transaction", id, err)
+}
+
+// GetTransactionHandler gets a transaction and the files staged in it
+func GetTransactionHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	tx, err := us.GetTransaction(ctx.Params(":transaction"))
+
+	return httpRet("Get Transaction", tx, err)
+}
+
+// StageFileHandler stages the content of a file in a transaction
+func StageFileHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	data, meta, err := fileFromRequest(ctx)
+	if err != nil {
+		return httpRet("Stage File", nil, err)
+	}
+	item, err := itemFromRequest(ctx, ctx.Params(":name"), data, meta)
+	if err != nil {
+		return httpRet("Stage File", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	err = us.Stage(ctx.Params(":transaction"), item, data)
+
+	return httpRet("Stage File", nil, err)
+}
+
+// StageManifestHandler stages a multi-part file in a transaction by the manifest of its parts
+func StageManifestHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	data, _ := ctx.Req.Body().Bytes()
+	var m service.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return httpRet("Stage Manifest", nil, err)
+	}
+	if _, err := protocolOf(ctx).ParseFullName(ctx.Params(":name")); err != nil {
+		return httpRet("Stage Manifest", nil, err)
+	}
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.StageManifest(ctx.Params(":transaction"), ctx.Params(":name"), m)
+
+	return httpRet("Stage Manifest", nil, err)
+}
+
+// CommitTransactionHandler publishes the files of a transaction under one signature
+func CommitTransactionHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.Commit(ctx.Params(":transaction"))
+
+	return httpRet("Commit Transaction", nil, err)
+}
+
+// AbortTransactionHandler drops a transaction and its staged files
+func AbortTransactionHandler(ctx *macaron.Context) (int, []byte) {
+	namespace := ctx.Params(":namespace")
+	repository := ctx.Params(":repository")
+
+	us, _ := updateService(ctx, namespace, repository)
+	err := us.Abort(ctx.Params(":transaction"))
+
+	return httpRet("Abort Transaction", nil, err)
+}
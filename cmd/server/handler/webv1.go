@@ -5,6 +5,9 @@ import (
 	"net/http"
 
 	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/protocol"
+	"github.com/liangchenye/update-service/service"
 )
 
 // IndexMetaV1Handler now only helps to know if the server is alive.
@@ -12,3 +15,25 @@ func IndexMetaV1Handler(ctx *macaron.Context) (int, []byte) {
 	result, _ := json.Marshal(map[string]string{"message": "Update Server Backend REST API Service"})
 	return http.StatusOK, result
 }
+
+// HealthV1Handler reports the repositories whose meta data does not verify
+// against the current public key and the signing failures kept by the lenient sign policy.
+func HealthV1Handler(ctx *macaron.Context) (int, []byte) {
+	health := service.Health{Status: service.HealthStatusOK}
+	for _, p := range protocol.ListProtocols() {
+		h, err := service.DefaultHealth(p.Proto(), p.Version())
+		if err != nil {
+			return httpRet("Health", nil, err)
+		}
+		if h.Status != service.HealthStatusOK {
+			health.Status = h.Status
+		}
+		// sign warnings are kept for all the protocols
+		health.Warnings = h.Warnings
+		health.Unverified = append(health.Unverified, h.Unverified...)
+		health.Expired = append(health.Expired, h.Expired...)
+	}
+
+	result, _ := json.Marshal(health)
+	return http.StatusOK, result
+}
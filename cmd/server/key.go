@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+var keymanagerModesCommand = cli.Command{
+	Name:  "keymanager-modes",
+	Usage: "list the key manager modes",
+	Action: func(c *cli.Context) error {
+		for _, km := range keymanager.ListKeyManagers() {
+			fmt.Printf("%-10s %s\n", km.ModeName(), km.Description())
+		}
+		return nil
+	},
+}
+
+var keyCommand = cli.Command{
+	Name:  "key",
+	Usage: "Handle the keys of a namespace",
+	Description: "Import or back up the online key of a namespace, or sign the role offline by a root key: " +
+		"export-role, sign it by 'uc sign' and then import-role-signatures.",
+	Subcommands: []cli.Command{
+		{
+			Name:      "import",
+			Usage:     "replace the key pair by a private key in PEM PKCS#1, PEM PKCS#8 or JWK",
+			ArgsUsage: "private key file",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "keytype",
+					Usage: "the key type, 'rsa' or 'rsa-pss' of a rsa key, detected by default",
+				},
+			}, repositoryFlags...),
+			Action: runKeyImport,
+		},
+		{
+			Name:      "export",
+			Usage:     "export the private key, or the public key",
+			ArgsUsage: "[output file]",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: utils.KeyFormatPEM,
+					Usage: "the key format: pem, pkcs1, pkcs8 or jwk",
+				},
+				cli.BoolFlag{
+					Name:  "public",
+					Usage: "export the public key only",
+				},
+			}, repositoryFlags...),
+			Action: runKeyExport,
+		},
+		{
+			Name:      "export-role",
+			Usage:     "export the role in canonical json to be signed by the offline root key",
+			ArgsUsage: "[output file]",
+			Flags:     repositoryFlags,
+			Action:    runKeyExportRole,
+		},
+		{
+			Name:      "import-role-signatures",
+			Usage:     "import the role signatures made by 'uc sign' with the offline root key",
+			ArgsUsage: "signature file",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "the public key file of the root key",
+				},
+			}, repositoryFlags...),
+			Action: runKeyImportRoleSignatures,
+		},
+		{
+			Name:  "revoke",
+			Usage: "tell clients to stop trusting a key, the current key is replaced if it is revoked",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "keyid",
+					Usage: "the id of the key to revoke, the current key by default",
+				},
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "why the key is revoked",
+				},
+			}, repositoryFlags...),
+			Action: runKeyRevoke,
+		},
+		{
+			Name:      "export-revocations",
+			Usage:     "export the revocation list in canonical json to be signed by the root key or the keys of the role",
+			ArgsUsage: "[output file]",
+			Flags:     repositoryFlags,
+			Action:    runKeyExportRevocations,
+		},
+		{
+			Name:      "import-revocation-signatures",
+			Usage:     "import the revocation list signatures made by 'uc sign'",
+			ArgsUsage: "signature file",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "the public key file of the root key, if it signs the list",
+				},
+			}, repositoryFlags...),
+			Action: runKeyImportRevocationSignatures,
+		},
+	},
+}
+
+func defaultKeyManager(c *cli.Context) (keymanager.KeyManager, utils.Appliance, error) {
+	if err := setServiceSettings(c); err != nil {
+		return nil, utils.Appliance{}, err
+	}
+
+	km, err := keymanager.DefaultKeyManager()
+	if err == nil && km == nil {
+		err = keymanager.ErrorsKMNotSupported
+	}
+	if err != nil {
+		return nil, utils.Appliance{}, err
+	}
+	a, err := repositoryAppliance(c)
+
+	return km, a, err
+}
+
+// writeOutput writes data to the file of the first argument, or stdout
+func writeOutput(c *cli.Context, data []byte, what string) error {
+	if c.Args().Get(0) == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.Args().Get(0), data, 0600); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("Success in exporting the %s to %s.\n", what, c.Args().Get(0))
+	return nil
+}
+
+func runKeyImport(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	data, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	privBytes, err := utils.ImportPrivateKey(data, c.String("keytype"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := km.ImportKey(a, privBytes); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	keyType, _ := utils.GetKeyType(privBytes)
+	fmt.Printf("Success in importing the %s key.\n", keyType)
+	return nil
+}
+
+func runKeyExport(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var data []byte
+	if c.Bool("public") {
+		if data, err = km.GetPublicKey(a); err == nil {
+			data, err = utils.ExportPublicKey(data, c.String("format"))
+		}
+	} else {
+		if data, err = km.ExportKey(a); err == nil {
+			data, err = utils.ExportPrivateKey(data, c.String("format"))
+		} else if err == storage.ErrorsNotFound {
+			err = errors.New("there is no key to export, import one or use the namespace first")
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return writeOutput(c, data, "key")
+}
+
+func runKeyExportRole(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	role, err := km.GetRole(a)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	data, err := utils.CanonicalJSON(role)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return writeOutput(c, data, "role")
+}
+
+func runKeyImportRoleSignatures(c *cli.Context) error {
+	if c.String("root") == "" {
+		err := errors.New("the public key of the root key should not be empty")
+		fmt.Println(err)
+		return err
+	}
+
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	rootPub, err := ioutil.ReadFile(c.String("root"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	data, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := keymanager.ImportRoleSignatures(km, a, data, rootPub); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in importing the role signatures.")
+	return nil
+}
+
+func runKeyRevoke(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	keyID, err := keymanager.Revoke(km, a, c.String("keyid"), c.String("reason"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Success in revoking key %s.\n", keyID)
+	fmt.Println("Run 'meta resign' for the repositories signed by the key.")
+	fmt.Println("If the role has more than the current key, update it and sign the list by 'key export-revocations'.")
+	return nil
+}
+
+func runKeyExportRevocations(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	rl, err := keymanager.GetRevocationList(km, a)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	data, err := utils.CanonicalJSON(rl)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return writeOutput(c, data, "revocation list")
+}
+
+func runKeyImportRevocationSignatures(c *cli.Context) error {
+	km, a, err := defaultKeyManager(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var rootPub []byte
+	if c.String("root") != "" {
+		if rootPub, err = ioutil.ReadFile(c.String("root")); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+	data, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := keymanager.ImportRevocationSignatures(km, a, data, rootPub); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in importing the revocation list signatures.")
+	return nil
+}
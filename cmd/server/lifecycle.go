@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/protocol"
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+var lifecycleCommand = cli.Command{
+	Name:  "lifecycle",
+	Usage: "Handle the lifecycle policies of the expired files",
+	Description: "An expired file is reported by /health ('warn'), hidden from listings ('hide') " +
+		"or deleted ('delete'), the last versions of every name could be kept.",
+	Subcommands: []cli.Command{
+		{
+			Name:  "set",
+			Usage: "set the lifecycle policy of a repository",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "action",
+					Value: service.LifecycleWarn,
+					Usage: "the action on the expired files: 'warn', 'hide' or 'delete'",
+				},
+				cli.IntFlag{
+					Name:  "keep-last",
+					Usage: "keep the last n versions of every name even if they are expired",
+				},
+			}, repositoryFlags...),
+			Action: runLifecycleSet,
+		},
+		{
+			Name:   "get",
+			Usage:  "get the lifecycle policy of a repository",
+			Flags:  repositoryFlags,
+			Action: runLifecycleGet,
+		},
+		{
+			Name:   "sweep",
+			Usage:  "apply the lifecycle policies of all the repositories now",
+			Flags:  serviceFlags,
+			Action: runLifecycleSweep,
+		},
+	},
+}
+
+func runLifecycleSet(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	p := service.LifecyclePolicy{Action: c.String("action"), KeepLast: c.Int("keep-last")}
+	if err := us.SetLifecyclePolicy(p); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("Success in setting the lifecycle policy to '%s', keep the last %d versions.\n", p.Action, p.KeepLast)
+	return nil
+}
+
+func runLifecycleGet(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	p, err := us.GetLifecyclePolicy()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("action: %s\nkeep last: %d\n", p.Action, p.KeepLast)
+	return nil
+}
+
+func runLifecycleSweep(c *cli.Context) error {
+	for _, item := range []string{"keymanager-mode", "keymanager-uri", "storage-uri"} {
+		utils.SetSetting(item, c.String(item))
+	}
+	if err := setPassphraseSetting(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	err := sweep(time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Success in applying the lifecycle policies.")
+	return nil
+}
+
+// sweep applies the lifecycle policies of the repositories of every protocol and prints what is done
+func sweep(now time.Time) error {
+	var results []service.SweepResult
+	var err error
+	for _, p := range protocol.ListProtocols() {
+		rs, sweepErr := service.DefaultSweep(p.Proto(), p.Version(), now)
+		results = append(results, rs...)
+		if sweepErr != nil && err == nil {
+			err = sweepErr
+		}
+	}
+	for _, r := range results {
+		for _, list := range []struct {
+			what  string
+			names []string
+		}{{"expired", r.Expired}, {"hidden", r.Hidden}, {"deleted", r.Deleted}, {"aborted transactions", r.Aborted}} {
+			if len(list.names) > 0 {
+				fmt.Printf("%s %s: %s\n", r.Repository, list.what, strings.Join(list.names, ","))
+			}
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	return err
+}
+
+// runSweeper applies the lifecycle policies every 'interval' in the background
+func runSweeper(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			sweep(now)
+		}
+	}()
+}
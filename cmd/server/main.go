@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/urfave/cli"
 	"gopkg.in/macaron.v1"
 
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/service"
 	"github.com/liangchenye/update-service/utils"
 )
 
@@ -16,7 +20,7 @@ var webCommand = cli.Command{
 	Usage:       "Update Server",
 	Description: "Update Server stores the signatured meta data.",
 	Action:      runUpdateServer,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "address",
 			Value: "0.0.0.0",
@@ -47,15 +51,80 @@ var webCommand = cli.Command{
 			Value: "/tmp/updater-server-keymanager",
 			Usage: "the key manager url",
 		},
-	},
+		cli.StringFlag{
+			Name:  "keymanager-keytype",
+			Value: utils.DefaultKeyType,
+			Usage: "the default type of new keys: rsa, rsa-pss, ecdsa-p256 or ed25519",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-namespace-keytype",
+			Value: "",
+			Usage: "the type of new keys per namespace, for example 'ns1=ed25519,ns2=rsa-pss'",
+		},
+		cli.StringFlag{
+			Name:  "meta-sign-format",
+			Value: "envelope",
+			Usage: "the format of meta.sign: 'envelope' or 'legacy' raw signature for old clients",
+		},
+		cli.StringFlag{
+			Name:  "sign-policy",
+			Value: service.SignPolicyStrict,
+			Usage: "'strict' fails an upload which could not be signed, 'lenient' keeps it and reports it by /health",
+		},
+		cli.DurationFlag{
+			Name:  "lifecycle-interval",
+			Value: time.Hour,
+			Usage: "how often the lifecycle policies of the expired files are applied, 0 disables it",
+		},
+		cli.IntFlag{
+			Name:  "delta-versions",
+			Value: service.DefaultDeltaVersions,
+			Usage: "make binary deltas of a new version of a file from this count of the previous versions, 0 disables it",
+		},
+		cli.Int64Flag{
+			Name:  "delta-max-length",
+			Value: service.DefaultDeltaMaxLength,
+			Usage: "make no binary deltas of the files larger than this size in bytes",
+		},
+		cli.DurationFlag{
+			Name:  "transaction-ttl",
+			Value: service.DefaultTransactionTTL,
+			Usage: "how long a transaction could be committed, the expired ones are dropped by the lifecycle sweeps",
+		},
+	}, passphraseFlags...),
 }
 
 func runUpdateServer(c *cli.Context) error {
 	m := macaron.New()
 
-	for _, item := range []string{"keymanager-mode", "keymanager-uri", "storage-uri"} {
+	for _, item := range []string{"keymanager-mode", "keymanager-uri", "keymanager-keytype", "meta-sign-format", "sign-policy", "storage-uri"} {
 		utils.SetSetting(item, c.String(item))
 	}
+	utils.SetSetting("delta-versions", strconv.Itoa(c.Int("delta-versions")))
+	utils.SetSetting("delta-max-length", strconv.FormatInt(c.Int64("delta-max-length"), 10))
+	utils.SetSetting("transaction-ttl", c.Duration("transaction-ttl").String())
+	if !utils.IsKeyTypeSupported(c.String("keymanager-keytype")) {
+		err := fmt.Errorf("%v: %s", utils.ErrorsKeyTypeNotSupported, c.String("keymanager-keytype"))
+		fmt.Println(err)
+		return err
+	}
+	if !service.IsSignPolicySupported(c.String("sign-policy")) {
+		err := fmt.Errorf("Unsupported sign policy: %s", c.String("sign-policy"))
+		fmt.Println(err)
+		return err
+	}
+	if err := keymanager.SetNamespaceKeyTypes(c.String("keymanager-namespace-keytype")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := setPassphraseSetting(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Duration("lifecycle-interval") > 0 {
+		runSweeper(c.Duration("lifecycle-interval"))
+	}
 
 	SetRouters(m)
 
@@ -84,6 +153,12 @@ func main() {
 
 	app.Commands = []cli.Command{
 		webCommand,
+		metaCommand,
+		keyCommand,
+		blobCommand,
+		delegationCommand,
+		lifecycleCommand,
+		keymanagerModesCommand,
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/protocol"
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+var serviceFlags = append([]cli.Flag{
+	cli.StringFlag{
+		Name:  "storage-uri",
+		Value: "/tmp/updater-server-storage",
+		Usage: "the storage database",
+	},
+	cli.StringFlag{
+		Name:  "keymanager-mode",
+		Value: "peruser",
+		Usage: "the key manager mode",
+	},
+	cli.StringFlag{
+		Name:  "keymanager-uri",
+		Value: "/tmp/updater-server-keymanager",
+		Usage: "the key manager url",
+	},
+	cli.StringFlag{
+		Name:  "namespace",
+		Usage: "the namespace of the repository",
+	},
+}, passphraseFlags...)
+
+var repositoryFlags = append([]cli.Flag{
+	cli.StringFlag{
+		Name:  "repository",
+		Usage: "the repository",
+	},
+	cli.StringFlag{
+		Name:  "proto",
+		Value: "appv1",
+		Usage: "the protocol of the repository: appv1, vmv1 or imagev1",
+	},
+}, serviceFlags...)
+
+var metaChannelFlag = cli.StringFlag{
+	Name:  "channel",
+	Usage: "the release channel whose meta data is signed, the whole repository by default",
+}
+
+var metaCommand = cli.Command{
+	Name:        "meta",
+	Usage:       "Handle the meta data of a repository",
+	Description: "Co-sign the meta data offline: export-unsigned, sign it by 'uc sign' and then import-signatures.",
+	Subcommands: []cli.Command{
+		{
+			Name:      "export-unsigned",
+			Usage:     "export the meta data to be signed",
+			ArgsUsage: "[output file]",
+			Flags:     append([]cli.Flag{metaChannelFlag}, repositoryFlags...),
+			Action:    runMetaExportUnsigned,
+		},
+		{
+			Name:      "import-signatures",
+			Usage:     "import the signatures made by 'uc sign'",
+			ArgsUsage: "signature file",
+			Flags:     append([]cli.Flag{metaChannelFlag}, repositoryFlags...),
+			Action:    runMetaImportSignatures,
+		},
+		{
+			Name:      "set-threshold",
+			Usage:     "require m-of-n signatures on the meta data of a namespace, or a repository in 'perrepo' mode",
+			ArgsUsage: "public key files...",
+			Flags: append([]cli.Flag{
+				cli.IntFlag{
+					Name:  "threshold",
+					Value: 1,
+					Usage: "how many signatures are required",
+				},
+				cli.BoolFlag{
+					Name:  "online",
+					Usage: "trust the online key of the key manager as one of the keys",
+				},
+				cli.DurationFlag{
+					Name:  "expires",
+					Value: 365 * 24 * time.Hour,
+					Usage: "how long the role is trusted by clients, 0 for ever",
+				},
+			}, repositoryFlags...),
+			Action: runMetaSetThreshold,
+		},
+		{
+			Name:   "resign",
+			Usage:  "sign the meta data again by the current online key, for example after the key is revoked",
+			Flags:  repositoryFlags,
+			Action: runMetaResign,
+		},
+	},
+}
+
+func setServiceSettings(c *cli.Context) error {
+	for _, item := range []string{"keymanager-mode", "keymanager-uri", "storage-uri"} {
+		utils.SetSetting(item, c.String(item))
+	}
+
+	if c.String("namespace") == "" {
+		return errors.New("namespace should not be empty")
+	}
+
+	return setPassphraseSetting(c)
+}
+
+func defaultUpdateService(c *cli.Context) (service.UpdateService, error) {
+	if err := setServiceSettings(c); err != nil {
+		return service.UpdateService{}, err
+	}
+	a, err := repositoryAppliance(c)
+	if err != nil {
+		return service.UpdateService{}, err
+	}
+
+	return service.DefaultUpdateService(a.Proto, a.Version, a.Namespace, a.Repository)
+}
+
+// repositoryAppliance gets the repository of the '--proto', '--namespace' and '--repository' flags
+func repositoryAppliance(c *cli.Context) (utils.Appliance, error) {
+	p, err := protocol.GetProtocol(c.String("proto"))
+	if err != nil {
+		return utils.Appliance{}, err
+	}
+
+	return utils.Appliance{Proto: p.Proto(), Version: p.Version(), Namespace: c.String("namespace"), Repository: c.String("repository")}, nil
+}
+
+func runMetaExportUnsigned(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var data []byte
+	if c.String("channel") != "" {
+		data, err = us.GetChannelMeta(c.String("channel"))
+	} else {
+		data, err = us.GetMeta()
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Get(0) == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.Args().Get(0), data, 0644); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("Success in exporting the meta data to %s.\n", c.Args().Get(0))
+	return nil
+}
+
+func runMetaImportSignatures(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	data, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.String("channel") != "" {
+		err = us.ImportChannelSignatures(c.String("channel"), data)
+	} else {
+		err = us.ImportSignatures(data)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in importing the signatures.")
+	return nil
+}
+
+func runMetaSetThreshold(c *cli.Context) error {
+	if err := setServiceSettings(c); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	km, err := keymanager.DefaultKeyManager()
+	if err == nil && km == nil {
+		err = keymanager.ErrorsKMNotSupported
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	a, err := repositoryAppliance(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var pubKeys [][]byte
+	if c.Bool("online") {
+		pubBytes, err := km.GetPublicKey(a)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		pubKeys = append(pubKeys, pubBytes)
+	}
+	for _, file := range c.Args() {
+		pubBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		pubKeys = append(pubKeys, pubBytes)
+	}
+
+	role, err := utils.NewRole(c.Int("threshold"), pubKeys...)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := keymanager.UpdateRole(km, a, role, c.Duration("expires")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Success in requiring %d of %d signatures.\n", role.Threshold, len(role.Keys))
+	fmt.Println("Sign the new role by the root key again if clients pin it.")
+	return nil
+}
+
+func runMetaResign(c *cli.Context) error {
+	us, err := defaultUpdateService(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := us.Resign(); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Println("Success in signing the meta data.")
+	return nil
+}
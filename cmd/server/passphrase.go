@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+var passphraseFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "keymanager-passphrase-file",
+		Value: "",
+		Usage: "the file containing the passphrase to encrypt private keys",
+	},
+	cli.StringFlag{
+		Name:  "keymanager-passphrase-env",
+		Value: "US_KEYMANAGER_PASSPHRASE",
+		Usage: "the environment variable containing the passphrase to encrypt private keys",
+	},
+	cli.BoolFlag{
+		Name:  "keymanager-passphrase-prompt",
+		Usage: "prompt for the passphrase to encrypt private keys",
+	},
+}
+
+// setPassphraseSetting loads the passphrase of private keys from a file, an
+// environment variable or an interactive prompt, in that order.
+// Private keys are kept unencrypted if there is no passphrase.
+func setPassphraseSetting(c *cli.Context) error {
+	var passphrase string
+
+	if file := c.String("keymanager-passphrase-file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	} else if env := c.String("keymanager-passphrase-env"); env != "" && os.Getenv(env) != "" {
+		passphrase = os.Getenv(env)
+	} else if c.Bool("keymanager-passphrase-prompt") {
+		var err error
+		passphrase, err = promptPassphrase("Key manager passphrase: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	return utils.SetSetting("keymanager-passphrase", passphrase)
+}
+
+// promptPassphrase reads a line from the terminal without echo
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Println()
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/utils"
+)
+
+var webCommand = cli.Command{
+	Name:        "web",
+	Usage:       "Update Service KMS",
+	Description: "Update Service KMS keeps the private keys and signs for the update server over mTLS.",
+	Action:      runKMS,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "address",
+			Value: "0.0.0.0",
+			Usage: "web service listen ip, default is 0.0.0.0",
+		},
+		cli.IntFlag{
+			Name:  "port",
+			Value: 8443,
+			Usage: "web service listen at port 8443",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "the server certificate file",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "the server private key file",
+		},
+		cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "the ca file to verify the client certificates of update servers",
+		},
+		cli.StringFlag{
+			Name:  "tls-decrypt-ca",
+			Usage: "the ca file to verify the client certificates only allowed to decrypt, for example of update clients",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-mode",
+			Value: "peruser",
+			Usage: "the key manager mode keeping the keys",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-uri",
+			Value: "/tmp/updater-service-kms",
+			Usage: "the key manager url",
+		},
+		cli.StringFlag{
+			Name:  "keymanager-keytype",
+			Value: utils.DefaultKeyType,
+			Usage: "the default type of new keys: rsa, rsa-pss, ecdsa-p256 or ed25519",
+		},
+	},
+}
+
+func runKMS(c *cli.Context) error {
+	if c.String("tls-cert") == "" || c.String("tls-key") == "" || c.String("tls-client-ca") == "" {
+		err := errors.New("'tls-cert', 'tls-key' and 'tls-client-ca' are required")
+		fmt.Println(err)
+		return err
+	}
+	if c.String("keymanager-mode") == "remote" {
+		err := errors.New("KMS could not run in 'remote' key manager mode")
+		fmt.Println(err)
+		return err
+	}
+
+	utils.SetSetting("keymanager-keytype", c.String("keymanager-keytype"))
+	km, err := keymanager.NewKeyManager(c.String("keymanager-mode"), c.String("keymanager-uri"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	cas, err := loadCerts(c.String("tls-client-ca"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	var decryptCAs []*x509.Certificate
+	if c.String("tls-decrypt-ca") != "" {
+		if decryptCAs, err = loadCerts(c.String("tls-decrypt-ca")); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range append(cas, decryptCAs...) {
+		pool.AddCert(ca)
+	}
+
+	listenaddr := fmt.Sprintf("%s:%d", c.String("address"), c.Int("port"))
+	server := &http.Server{
+		Addr:    listenaddr,
+		Handler: keymanager.NewRemoteHandler(km, decryptCAs),
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+		},
+	}
+
+	fmt.Printf("Start listen to :%s\n", listenaddr)
+	if err := server.ListenAndServeTLS(c.String("tls-cert"), c.String("tls-key")); err != nil {
+		fmt.Printf("Start Update Service KMS error: %v\n", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// loadCerts loads the PEM encoded certificates of a ca file
+func loadCerts(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("Fail to load the ca %s", file)
+	}
+
+	return certs, nil
+}
+
+func main() {
+	app := cli.NewApp()
+
+	app.Name = "uskms"
+	app.Usage = "Update Service KMS"
+	app.Version = "0.0.1"
+
+	app.Commands = []cli.Command{
+		webCommand,
+	}
+
+	app.Run(os.Args)
+}
@@ -0,0 +1,42 @@
+package keymanager_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/keymanager/keymanagertest"
+)
+
+func TestConformance(t *testing.T) {
+	for _, f := range []keymanager.KeyManager{
+		&keymanager.KeyManagerPeruser{},
+		&keymanager.KeyManagerPerrepo{},
+		&keymanager.KeyManagerGlobal{},
+	} {
+		tmpPath, err := ioutil.TempDir("", "km-conformance-")
+		assert.Nil(t, err, "Fail to create a temp dir")
+		defer os.RemoveAll(tmpPath)
+
+		t.Run(f.ModeName(), func(t *testing.T) {
+			keymanagertest.Run(t, f, tmpPath)
+		})
+	}
+
+	// the remote key manager by a signing service of a local key manager
+	tmpPath, err := ioutil.TempDir("", "km-conformance-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+	local, err := (&keymanager.KeyManagerPeruser{}).New(filepath.Join(tmpPath, "kms"))
+	assert.Nil(t, err, "Fail to setup the key manager of the signing service")
+	server, uri := keymanagertest.NewRemoteServer(t, local, tmpPath)
+	defer server.Close()
+
+	t.Run("remote", func(t *testing.T) {
+		keymanagertest.Run(t, &keymanager.KeyManagerRemote{}, uri)
+	})
+}
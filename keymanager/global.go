@@ -0,0 +1,56 @@
+package keymanager
+
+import (
+	"fmt"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	globalName        = "global"
+	globalDescription = "the whole server shares one private/public key pair"
+
+	globalKeyDir = "global"
+)
+
+// KeyManagerGlobal is the global implementation of a key manager,
+// all the namespaces and repositories share one server wide key pair.
+type KeyManagerGlobal struct {
+	localKeyManager
+}
+
+func init() {
+	RegisterKeyManager(globalName, &KeyManagerGlobal{})
+}
+
+func (g *KeyManagerGlobal) ModeName() string {
+	return globalName
+}
+
+func (g *KeyManagerGlobal) Description() string {
+	return globalDescription
+}
+
+// New returns a new keymanager by a uri, the registered instance is never changed
+func (g *KeyManagerGlobal) New(uri string) (KeyManager, error) {
+	l, err := newLocalKeyManager(uri, func(a utils.Appliance) (string, error) {
+		return globalKeyDir, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// namespaces could not choose the type of the shared key
+	l.keyType = func(a utils.Appliance) string {
+		return KeyTypeOf(utils.Appliance{})
+	}
+	// but every namespace has its own role and revocations
+	l.metaDir = func(a utils.Appliance) (string, error) {
+		dir, err := peruserKeyDir(a)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/%s", globalKeyDir, dir), nil
+	}
+
+	return &KeyManagerGlobal{localKeyManager: l}, nil
+}
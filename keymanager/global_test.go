@@ -0,0 +1,54 @@
+package keymanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestGlobalGetPublicKey(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	RegisterKeyManager(globalName, &KeyManagerGlobal{})
+	l, err := NewKeyManager(globalName, tmpPath)
+	assert.Nil(t, err, "Fail to setup a global key manager")
+
+	utils.SetSetting("keymanager-keytype/containerops", utils.KeyTypeEd25519)
+	defer utils.SetSetting("keymanager-keytype/containerops", "")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops", Repository: "official"}
+	b := utils.Appliance{Proto: "vm", Version: "v1", Namespace: "other", Repository: "incubator"}
+
+	aPub, err := l.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get public key")
+	bPub, err := l.GetPublicKey(b)
+	assert.Nil(t, err, "Fail to get public key")
+	assert.Equal(t, aPub, bPub, "All the appliances should share the global key")
+
+	keyType, _ := utils.GetKeyType(aPub)
+	assert.Equal(t, utils.DefaultKeyType, keyType, "Namespace should not choose the type of the global key")
+
+	// roles and revocations are not shared
+	_, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	role, _ := utils.NewRole(2, aPub, otherPub)
+	assert.Nil(t, l.SetRole(a, role), "Fail to set a role")
+	assert.Nil(t, l.SetRoleSign(a, []byte("root signatures")))
+	assert.Nil(t, l.SetRevocations(a, []byte("revocations")))
+	bRole, _ := l.GetRole(b)
+	assert.Equal(t, 1, bRole.Threshold, "Namespaces should not share roles")
+	_, err = l.GetRoleSign(b)
+	assert.NotNil(t, err, "Namespaces should not share role signatures")
+	_, err = l.GetRevocations(b)
+	assert.NotNil(t, err, "Namespaces should not share revocations")
+	aRole, _ := l.GetRole(a)
+	assert.Equal(t, role, aRole)
+
+	_, err = l.GetRole(utils.Appliance{Proto: "app", Version: "v1", Namespace: ".."})
+	assert.NotNil(t, err, "Should not escape the key directory")
+}
@@ -3,6 +3,8 @@ package keymanager
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/liangchenye/update-service/utils"
@@ -16,7 +18,26 @@ type KeyManager interface {
 	GenerateKey(a utils.Appliance) error
 	GetPublicKey(a utils.Appliance) ([]byte, error)
 	Sign(a utils.Appliance, data []byte) ([]byte, error)
+	// Decrypt unwraps data wrapped to the public key of an appliance, for
+	// example the data key of a utils.EncryptedEnvelope.
 	Decrypt(a utils.Appliance, data []byte) ([]byte, error)
+	// GetRole gets the keys trusted to sign the meta data of a namespace and
+	// how many of them are required, by default only the key of GetPublicKey.
+	GetRole(a utils.Appliance) (utils.Role, error)
+	SetRole(a utils.Appliance, role utils.Role) error
+	// ImportKey replaces the key pair of an appliance by a private key in
+	// the stored format, see utils.ImportPrivateKey.
+	ImportKey(a utils.Appliance, privBytes []byte) error
+	// ExportKey gets the private key of an appliance in the stored format.
+	ExportKey(a utils.Appliance) ([]byte, error)
+	// GetRoleSign gets the signatures of the role made by an offline root key,
+	// the signatures are dropped by SetRole.
+	GetRoleSign(a utils.Appliance) ([]byte, error)
+	SetRoleSign(a utils.Appliance, data []byte) error
+	// GetRevocations gets the signed revocation list of an appliance,
+	// storage.ErrorsNotFound if no key is revoked, see Revoke.
+	GetRevocations(a utils.Appliance) ([]byte, error)
+	SetRevocations(a utils.Appliance, data []byte) error
 	Debug()
 }
 
@@ -54,6 +75,22 @@ func RegisterKeyManager(modeName string, f KeyManager) error {
 	return nil
 }
 
+// ListKeyManagers lists the registered key managers sorted by their mode names
+func ListKeyManagers() []KeyManager {
+	kmsLock.Lock()
+	defer kmsLock.Unlock()
+
+	var ret []KeyManager
+	for _, f := range kms {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ModeName() < ret[j].ModeName()
+	})
+
+	return ret
+}
+
 // NewKeyManager create a key manager by its name and a storage url
 func NewKeyManager(modeName, url string) (KeyManager, error) {
 	for _, f := range kms {
@@ -78,3 +115,38 @@ func DefaultKeyManager() (KeyManager, error) {
 	}
 	return NewKeyManager(mode, uri)
 }
+
+// KeyTypeOf gets the key type configured for the namespace of an appliance.
+// 'keymanager-keytype/namespace' overrides the server wide 'keymanager-keytype',
+// utils.DefaultKeyType is used if neither is set.
+func KeyTypeOf(a utils.Appliance) string {
+	if keyType, err := utils.GetSetting("keymanager-keytype/" + a.Namespace); err == nil && keyType != "" {
+		return keyType
+	}
+	if keyType, err := utils.GetSetting("keymanager-keytype"); err == nil && keyType != "" {
+		return keyType
+	}
+	return utils.DefaultKeyType
+}
+
+// SetNamespaceKeyTypes parses 'namespace=keytype' pairs separated by ',' and
+// saves them to setting
+func SetNamespaceKeyTypes(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		strs := strings.SplitN(pair, "=", 2)
+		if len(strs) != 2 || strs[0] == "" {
+			return fmt.Errorf("Invalid namespace key type '%s', should be 'namespace=keytype'", pair)
+		}
+		if !utils.IsKeyTypeSupported(strs[1]) {
+			return fmt.Errorf("%v: %s", utils.ErrorsKeyTypeNotSupported, strs[1])
+		}
+		utils.SetSetting("keymanager-keytype/"+strs[0], strs[1])
+	}
+
+	return nil
+}
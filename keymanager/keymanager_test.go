@@ -10,8 +10,8 @@ import (
 
 // expunge all the registed implementaions
 func preTest() {
-	for _, f := range kms {
-		delete(kms, f.ModeName())
+	for n := range kms {
+		delete(kms, n)
 	}
 }
 
@@ -66,3 +66,40 @@ func TestDefaultKeyManager(t *testing.T) {
 		assert.Equal(t, c.expected, err == nil, "Error in creating default key manager")
 	}
 }
+
+func TestKeyTypeOf(t *testing.T) {
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "kt"}
+
+	utils.SetSetting("keymanager-keytype", "")
+	assert.Equal(t, utils.DefaultKeyType, KeyTypeOf(a))
+
+	utils.SetSetting("keymanager-keytype", utils.KeyTypeECDSAP256)
+	assert.Equal(t, utils.KeyTypeECDSAP256, KeyTypeOf(a))
+
+	err := SetNamespaceKeyTypes("kt=ed25519, other=rsa-pss")
+	assert.Nil(t, err, "Fail to set namespace key types")
+	assert.Equal(t, utils.KeyTypeEd25519, KeyTypeOf(a))
+
+	err = SetNamespaceKeyTypes("kt=unknown")
+	assert.NotNil(t, err, "Should not set unknown key type")
+	err = SetNamespaceKeyTypes("kt")
+	assert.NotNil(t, err, "Should not set invalid pair")
+
+	utils.SetSetting("keymanager-keytype", "")
+	utils.SetSetting("keymanager-keytype/kt", "")
+	utils.SetSetting("keymanager-keytype/other", "")
+}
+
+func TestListKeyManagers(t *testing.T) {
+	preTest()
+	RegisterKeyManager("peruser", &KeyManagerPeruser{})
+	RegisterKeyManager("global", &KeyManagerGlobal{})
+	RegisterKeyManager("perrepo", &KeyManagerPerrepo{})
+
+	var names []string
+	for _, km := range ListKeyManagers() {
+		names = append(names, km.ModeName())
+		assert.NotEqual(t, "", km.Description(), "Key manager should have a description")
+	}
+	assert.Equal(t, []string{"global", "perrepo", "peruser"}, names, "Fail to list sorted key managers")
+}
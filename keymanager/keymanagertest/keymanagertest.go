@@ -0,0 +1,227 @@
+// Package keymanagertest provides a conformance suite for the implementations
+// of keymanager.KeyManager and a configurable mock key manager for tests.
+package keymanagertest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// concurrency is the count of the concurrent callers of the concurrency tests
+const concurrency = 16
+
+// Run runs the conformance suite against a key manager, 'f' is the instance
+// registered by keymanager.RegisterKeyManager and every test gets new instances
+// by f.New(uri), so 'uri' should point to an empty storage.
+// Tests of import/export are skipped for key managers refusing them by
+// keymanager.ErrorsRemoteKeyNotPortable.
+func Run(t *testing.T, f keymanager.KeyManager, uri string) {
+	newKM := func(t *testing.T) keymanager.KeyManager {
+		km, err := f.New(uri)
+		if err != nil {
+			t.Fatalf("Fail to create a '%s' key manager by %s: %v", f.ModeName(), uri, err)
+		}
+		return km
+	}
+
+	t.Run("Mode", func(t *testing.T) {
+		km := newKM(t)
+		assert.Equal(t, f.ModeName(), km.ModeName(), "New should keep the mode name")
+		assert.NotEqual(t, "", km.Description(), "Key manager should have a description")
+	})
+	t.Run("KeyIdempotency", func(t *testing.T) { testKeyIdempotency(t, newKM(t), newKM(t)) })
+	t.Run("SignVerify", func(t *testing.T) { testSignVerify(t, newKM(t)) })
+	t.Run("Decrypt", func(t *testing.T) { testDecrypt(t, newKM(t)) })
+	t.Run("UnknownAppliance", func(t *testing.T) { testUnknownAppliance(t, newKM(t)) })
+	t.Run("Role", func(t *testing.T) { testRole(t, newKM(t)) })
+	t.Run("Revocations", func(t *testing.T) { testRevocations(t, newKM(t)) })
+	t.Run("ImportExport", func(t *testing.T) { testImportExport(t, newKM(t)) })
+	t.Run("Concurrency", func(t *testing.T) {
+		var kms []keymanager.KeyManager
+		for i := 0; i < concurrency; i++ {
+			kms = append(kms, newKM(t))
+		}
+		testConcurrency(t, kms)
+	})
+}
+
+// appliance returns a full appliance, so every mode could key it
+func appliance(name string) utils.Appliance {
+	return utils.Appliance{Proto: "app", Version: "v1", Namespace: "kmtest-" + name, Repository: "repo"}
+}
+
+func testKeyIdempotency(t *testing.T, km, other keymanager.KeyManager) {
+	a := appliance("idempotency")
+
+	pubBytes, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key")
+	again, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key again")
+	assert.Equal(t, pubBytes, again, "The public key should not change by getting it")
+	again, err = other.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key by another instance")
+	assert.Equal(t, pubBytes, again, "Instances of the same uri should share the keys")
+
+	assert.Nil(t, km.GenerateKey(a), "Fail to generate a key")
+	generated, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the generated public key")
+	assert.NotEqual(t, pubBytes, generated, "GenerateKey should replace the key pair")
+	again, _ = km.GetPublicKey(a)
+	assert.Equal(t, generated, again, "The generated public key should not change by getting it")
+}
+
+func testSignVerify(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("sign")
+	data := []byte("conformance payload")
+
+	sig, err := km.Sign(a, data)
+	assert.Nil(t, err, "Fail to sign")
+	pubBytes, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, data, sig), "Fail to verify the signature by the public key")
+	assert.NotNil(t, utils.SHA256Verify(pubBytes, []byte("other payload"), sig), "Signature should not verify other data")
+
+	role, err := km.GetRole(a)
+	assert.Nil(t, err, "Fail to get the default role")
+	env := utils.NewSignatureEnvelope(utils.DefaultPayloadType)
+	sig, err = km.Sign(a, env.PAE(data))
+	assert.Nil(t, err, "Fail to sign the envelope")
+	assert.Nil(t, env.AddSignature(pubBytes, sig))
+	assert.Nil(t, role.VerifyThreshold(utils.DefaultPayloadType, data, env), "Default role should trust the signing key")
+}
+
+func testDecrypt(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("decrypt")
+	pubBytes, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key")
+
+	data, err := utils.Encrypt(pubBytes, []byte("conformance secret"))
+	if err != nil {
+		keyType, _ := utils.GetKeyType(pubBytes)
+		t.Skipf("Key type %s could not encrypt: %v", keyType, err)
+	}
+	env, err := utils.ParseEncryptedEnvelope(data)
+	assert.Nil(t, err)
+	plain, err := env.Decrypt(func(wrapped []byte) ([]byte, error) {
+		return km.Decrypt(a, wrapped)
+	})
+	assert.Nil(t, err, "Fail to decrypt")
+	assert.Equal(t, []byte("conformance secret"), plain)
+}
+
+// testUnknownAppliance checks an appliance never used before, which appliances
+// are valid depends on the mode, for example 'global' accepts any.
+func testUnknownAppliance(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("unknown")
+
+	_, err := km.GetRoleSign(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "Unsigned role should be storage.ErrorsNotFound")
+	_, err = km.GetRevocations(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "Empty revocations should be storage.ErrorsNotFound")
+
+	// keys are created on first use
+	pubBytes, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to create the key of an unknown appliance")
+	_, err = utils.KeyID(pubBytes)
+	assert.Nil(t, err, "Created public key should be valid")
+}
+
+func testRole(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("role")
+	pubBytes, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key")
+	_, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+
+	role, err := utils.NewRole(2, pubBytes, otherPub)
+	assert.Nil(t, err)
+	assert.Nil(t, km.SetRoleSign(a, []byte("root signatures")), "Fail to set the role signatures")
+	assert.Nil(t, km.SetRole(a, role), "Fail to set the role")
+	got, err := km.GetRole(a)
+	assert.Nil(t, err, "Fail to get the role")
+	assert.Equal(t, role, got, "Fail to get the role set")
+	_, err = km.GetRoleSign(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "SetRole should drop the role signatures")
+
+	assert.Nil(t, km.SetRoleSign(a, []byte("root signatures")), "Fail to set the role signatures")
+	data, err := km.GetRoleSign(a)
+	assert.Nil(t, err, "Fail to get the role signatures")
+	assert.Equal(t, []byte("root signatures"), data)
+
+	assert.NotNil(t, km.SetRole(a, utils.Role{Threshold: 2}), "Should not set an invalid role")
+}
+
+func testRevocations(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("revocations")
+
+	assert.Nil(t, km.SetRevocations(a, []byte("revocations")), "Fail to set the revocations")
+	data, err := km.GetRevocations(a)
+	assert.Nil(t, err, "Fail to get the revocations")
+	assert.Equal(t, []byte("revocations"), data)
+}
+
+func testImportExport(t *testing.T, km keymanager.KeyManager) {
+	a := appliance("import")
+	privBytes, pubBytes, _ := utils.GenerateKeyPair(utils.KeyTypeECDSAP256)
+
+	err := km.ImportKey(a, privBytes)
+	if err == keymanager.ErrorsRemoteKeyNotPortable {
+		t.Skip(err)
+	}
+	assert.Nil(t, err, "Fail to import a key")
+	got, err := km.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the imported public key")
+	assert.Equal(t, pubBytes, got, "Public key should be derived from the imported key")
+
+	exported, err := km.ExportKey(a)
+	assert.Nil(t, err, "Fail to export the key")
+	assert.Equal(t, privBytes, exported, "Fail to export the imported key")
+
+	assert.NotNil(t, km.ImportKey(a, []byte("invalid key")), "Should not import an invalid key")
+	got, _ = km.GetPublicKey(a)
+	assert.Equal(t, pubBytes, got, "Invalid import should not change the key pair")
+}
+
+// testConcurrency makes sure concurrent first users of a key, by different
+// instances, all get and sign by one key pair
+func testConcurrency(t *testing.T, kms []keymanager.KeyManager) {
+	a := appliance("concurrency")
+	data := []byte("concurrent payload")
+
+	var wg sync.WaitGroup
+	pubs := make([][]byte, len(kms))
+	sigs := make([][]byte, len(kms))
+	errs := make([]error, len(kms))
+	for i := range kms {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				sigs[i], errs[i] = kms[i].Sign(a, data)
+			} else {
+				pubs[i], errs[i] = kms[i].GetPublicKey(a)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	pubBytes, err := kms[0].GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get the public key")
+	for i := range kms {
+		if !assert.Nil(t, errs[i], fmt.Sprintf("Caller %d failed", i)) {
+			continue
+		}
+		if pubs[i] != nil {
+			assert.Equal(t, pubBytes, pubs[i], "Concurrent callers should get one public key")
+		}
+		if sigs[i] != nil {
+			assert.Nil(t, utils.SHA256Verify(pubBytes, data, sigs[i]), "Concurrent signatures should be made by one key")
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package keymanagertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// Mock is an in-memory key manager for tests, keys are shared by namespace as
+// the 'peruser' mode does. New returns the mock itself, so a test could register
+// it by keymanager.RegisterKeyManager, inject failures and count the calls of
+// the key managers created by keymanager.NewKeyManager.
+type Mock struct {
+	modeName string
+
+	lock        sync.Mutex
+	privKeys    map[string][]byte
+	roles       map[string]utils.Role
+	roleSigns   map[string][]byte
+	revocations map[string][]byte
+	failures    map[string]error
+	passes      map[string]int
+	calls       map[string]int
+}
+
+// NewMock creates a mock key manager of a mode name
+func NewMock(modeName string) *Mock {
+	return &Mock{
+		modeName:    modeName,
+		privKeys:    make(map[string][]byte),
+		roles:       make(map[string]utils.Role),
+		roleSigns:   make(map[string][]byte),
+		revocations: make(map[string][]byte),
+		failures:    make(map[string]error),
+		passes:      make(map[string]int),
+		calls:       make(map[string]int),
+	}
+}
+
+// Fail makes a method, for example "Sign", return 'err' until Fail is called
+// again with a nil error
+func (m *Mock) Fail(method string, err error) {
+	m.FailAfter(method, 0, err)
+}
+
+// FailAfter makes a method return 'err' after it succeeds 'n' more times, until
+// Fail is called again with a nil error
+func (m *Mock) FailAfter(method string, n int, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.passes[method] = n
+	if err == nil {
+		delete(m.failures, method)
+	} else {
+		m.failures[method] = err
+	}
+}
+
+// Calls returns how many times a method is called
+func (m *Mock) Calls(method string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.calls[method]
+}
+
+// call counts a method call and returns the key of the appliance,
+// or the injected failure
+func (m *Mock) call(method string, a utils.Appliance) (string, error) {
+	m.calls[method]++
+	if err, ok := m.failures[method]; ok {
+		if m.passes[method] <= 0 {
+			return "", err
+		}
+		m.passes[method]--
+	}
+	if a.Proto == "" || a.Version == "" || a.Namespace == "" {
+		return "", errors.New("Proto/Version/Namespace should not be empty")
+	}
+
+	return fmt.Sprintf("%s/%s/%s", a.Proto, a.Version, a.Namespace), nil
+}
+
+// getPrivateKey gets the private key of a key, it is created on first use
+func (m *Mock) getPrivateKey(key string, a utils.Appliance) ([]byte, error) {
+	if privBytes, ok := m.privKeys[key]; ok {
+		return privBytes, nil
+	}
+
+	privBytes, _, err := utils.GenerateKeyPair(keymanager.KeyTypeOf(a))
+	if err != nil {
+		return nil, err
+	}
+	m.privKeys[key] = privBytes
+
+	return privBytes, nil
+}
+
+func (m *Mock) ModeName() string {
+	return m.modeName
+}
+
+func (m *Mock) Description() string {
+	return "in-memory key manager for tests"
+}
+
+// New returns the mock itself
+func (m *Mock) New(url string) (keymanager.KeyManager, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.calls["New"]++
+	if err, ok := m.failures["New"]; ok {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GenerateKey replaces the key pair of an appliance
+func (m *Mock) GenerateKey(a utils.Appliance) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("GenerateKey", a)
+	if err != nil {
+		return err
+	}
+	privBytes, _, err := utils.GenerateKeyPair(keymanager.KeyTypeOf(a))
+	if err != nil {
+		return err
+	}
+	m.privKeys[key] = privBytes
+
+	return nil
+}
+
+// GetPublicKey gets the public key of an appliance
+func (m *Mock) GetPublicKey(a utils.Appliance) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("GetPublicKey", a)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, err := m.getPrivateKey(key, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.GetPublicKeyFromPrivate(privBytes)
+}
+
+// Sign signs the data by the key of an appliance
+func (m *Mock) Sign(a utils.Appliance, data []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("Sign", a)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, err := m.getPrivateKey(key, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.SHA256Sign(privBytes, data)
+}
+
+// Decrypt unwraps the data wrapped to the public key of an appliance
+func (m *Mock) Decrypt(a utils.Appliance, data []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("Decrypt", a)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, ok := m.privKeys[key]
+	if !ok {
+		return nil, errors.New("Fail to load private key, cannot decrypt")
+	}
+
+	return utils.UnwrapKey(privBytes, data)
+}
+
+// GetRole gets the role of an appliance, its own key with threshold 1 by default
+func (m *Mock) GetRole(a utils.Appliance) (utils.Role, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("GetRole", a)
+	if err != nil {
+		return utils.Role{}, err
+	}
+	if role, ok := m.roles[key]; ok {
+		// the role is copied, so the caller could not change it
+		var copied utils.Role
+		data, _ := json.Marshal(role)
+		err := json.Unmarshal(data, &copied)
+		return copied, err
+	}
+
+	privBytes, err := m.getPrivateKey(key, a)
+	if err != nil {
+		return utils.Role{}, err
+	}
+	pubBytes, err := utils.GetPublicKeyFromPrivate(privBytes)
+	if err != nil {
+		return utils.Role{}, err
+	}
+
+	return utils.NewRole(1, pubBytes)
+}
+
+// SetRole sets the role of an appliance and drops its role signatures
+func (m *Mock) SetRole(a utils.Appliance, role utils.Role) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("SetRole", a)
+	if err != nil {
+		return err
+	}
+	if err := role.IsValid(); err != nil {
+		return err
+	}
+	m.roles[key] = role
+	delete(m.roleSigns, key)
+
+	return nil
+}
+
+// ImportKey replaces the key pair of an appliance by a private key
+func (m *Mock) ImportKey(a utils.Appliance, privBytes []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("ImportKey", a)
+	if err != nil {
+		return err
+	}
+	if _, err := utils.GetPublicKeyFromPrivate(privBytes); err != nil {
+		return err
+	}
+	m.privKeys[key] = privBytes
+
+	return nil
+}
+
+// ExportKey gets the private key of an appliance
+func (m *Mock) ExportKey(a utils.Appliance) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call("ExportKey", a)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, ok := m.privKeys[key]
+	if !ok {
+		return nil, storage.ErrorsNotFound
+	}
+
+	return privBytes, nil
+}
+
+// GetRoleSign gets the role signatures of an appliance
+func (m *Mock) GetRoleSign(a utils.Appliance) ([]byte, error) {
+	return m.getData("GetRoleSign", m.roleSigns, a)
+}
+
+// SetRoleSign sets the role signatures of an appliance
+func (m *Mock) SetRoleSign(a utils.Appliance, data []byte) error {
+	return m.setData("SetRoleSign", m.roleSigns, a, data)
+}
+
+// GetRevocations gets the revocation list of an appliance
+func (m *Mock) GetRevocations(a utils.Appliance) ([]byte, error) {
+	return m.getData("GetRevocations", m.revocations, a)
+}
+
+// SetRevocations sets the revocation list of an appliance
+func (m *Mock) SetRevocations(a utils.Appliance, data []byte) error {
+	return m.setData("SetRevocations", m.revocations, a, data)
+}
+
+func (m *Mock) getData(method string, values map[string][]byte, a utils.Appliance) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call(method, a)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := values[key]
+	if !ok {
+		return nil, storage.ErrorsNotFound
+	}
+
+	return data, nil
+}
+
+func (m *Mock) setData(method string, values map[string][]byte, a utils.Appliance, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key, err := m.call(method, a)
+	if err != nil {
+		return err
+	}
+	values[key] = data
+
+	return nil
+}
+
+func (m *Mock) Debug() {
+}
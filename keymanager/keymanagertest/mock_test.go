@@ -0,0 +1,37 @@
+package keymanagertest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestMockConformance(t *testing.T) {
+	Run(t, NewMock("mock"), "")
+}
+
+func TestMockFail(t *testing.T) {
+	m := NewMock("mock-fail")
+	assert.Nil(t, keymanager.RegisterKeyManager("mock-fail", m), "Fail to register the mock")
+	km, err := keymanager.NewKeyManager("mock-fail", "any")
+	assert.Nil(t, err, "Fail to create the mock by its mode name")
+	assert.Equal(t, m, km, "New should return the mock itself")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "ns"}
+	injected := errors.New("injected")
+	m.Fail("Sign", injected)
+	_, err = km.Sign(a, []byte("data"))
+	assert.Equal(t, injected, err, "Fail to inject a failure")
+	_, err = km.GetPublicKey(a)
+	assert.Nil(t, err, "Other methods should not fail")
+
+	m.Fail("Sign", nil)
+	_, err = km.Sign(a, []byte("data"))
+	assert.Nil(t, err, "Fail to clear a failure")
+	assert.Equal(t, 2, m.Calls("Sign"), "Fail to count the calls")
+	assert.Equal(t, 1, m.Calls("New"), "Fail to count the calls")
+}
@@ -0,0 +1,458 @@
+package keymanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultPublicKey  = "pub_key.pem"
+	defaultPrivateKey = "priv_key.pem"
+	defaultRole       = "role.json"
+	defaultRoleSign   = "role.sign"
+	defaultRevocation = "revocations.json"
+)
+
+var (
+	// decrypted private keys are only cached in memory
+	privKeysLock sync.Mutex
+	privKeys     = make(map[string][]byte)
+
+	// keyLocks serializes the key creation of a key directory of a storage,
+	// instances of the same uri share them.
+	keyLocksLock sync.Mutex
+	keyLocks     = make(map[string]*sync.Mutex)
+)
+
+// localKeyManager keeps the keys in a storage, it is shared by the key manager
+// modes which only differ in which appliances share a key pair.
+type localKeyManager struct {
+	uri   string
+	store storage.UpdateServiceStorage
+	// keyDir returns the storage directory of the keys of an appliance
+	keyDir func(a utils.Appliance) (string, error)
+	// metaDir returns the storage directory of the role, the role signatures
+	// and the revocations of an appliance, it is keyDir by default
+	metaDir func(a utils.Appliance) (string, error)
+	// keyType returns the type of new keys of an appliance
+	keyType func(a utils.Appliance) string
+}
+
+func newLocalKeyManager(uri string, keyDir func(a utils.Appliance) (string, error)) (localKeyManager, error) {
+	store, err := storage.NewUpdateServiceStorage(uri)
+	if err != nil {
+		return localKeyManager{}, err
+	}
+
+	return localKeyManager{uri: uri, store: store, keyDir: keyDir, metaDir: keyDir, keyType: KeyTypeOf}, nil
+}
+
+// isValidPathName makes sure a name of an appliance could not escape its
+// directory in a storage
+func isValidPathName(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\")
+}
+
+func (l *localKeyManager) keyPath(a utils.Appliance, name string) (string, error) {
+	dir, err := l.keyDir(a)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", dir, name), nil
+}
+
+func (l *localKeyManager) metaPath(a utils.Appliance, name string) (string, error) {
+	dir, err := l.metaDir(a)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", dir, name), nil
+}
+
+// lockKeys locks the key directory of an appliance, it returns the unlock function
+func (l *localKeyManager) lockKeys(a utils.Appliance) (func(), error) {
+	dir, err := l.keyDir(a)
+	if err != nil {
+		return nil, err
+	}
+
+	keyLocksLock.Lock()
+	lock, ok := keyLocks[l.uri+"/"+dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		keyLocks[l.uri+"/"+dir] = lock
+	}
+	keyLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock, nil
+}
+
+// GetPublicKey gets the public key data of an appliance
+func (l *localKeyManager) GetPublicKey(a utils.Appliance) ([]byte, error) {
+	key, err := l.keyPath(a, defaultPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := l.store.Get(key)
+	if err == storage.ErrorsNotFound {
+		err = l.createKeyIfAbsent(a)
+		if err == nil {
+			content, err = l.store.Get(key)
+		}
+	}
+
+	return content, err
+}
+
+// createKeyIfAbsent lazily creates the key pair of an appliance.
+// Only one private key is ever created even by concurrent callers of
+// different processes, the public key is always derived from it.
+func (l *localKeyManager) createKeyIfAbsent(a utils.Appliance) error {
+	unlock, err := l.lockKeys(a)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	privKey, err := l.keyPath(a, defaultPrivateKey)
+	if err != nil {
+		return err
+	}
+	pubKey, err := l.keyPath(a, defaultPublicKey)
+	if err != nil {
+		return err
+	}
+	if _, err := l.store.Get(pubKey); err == nil {
+		return nil
+	}
+
+	// under the key lock, the key is encrypted by the callers of getPrivateKey
+	privBytes, _, err := l.loadPrivateKey(a)
+	if err == storage.ErrorsNotFound {
+		privBytes, _, err = utils.GenerateKeyPair(l.keyType(a))
+		if err != nil {
+			return err
+		}
+		stored, err := encryptAtRest(privBytes)
+		if err != nil {
+			return err
+		}
+		// lost to another process, use its key
+		if _, err = l.store.PutIfAbsent(privKey, stored); err == storage.ErrorsAlreadyExist {
+			privBytes, _, err = l.loadPrivateKey(a)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	pubBytes, err := utils.GetPublicKeyFromPrivate(privBytes)
+	if err != nil {
+		return err
+	}
+	_, err = l.store.Put(pubKey, pubBytes)
+	return err
+}
+
+// GenerateKey generates private key and public key of the namespace key type and stores them,
+// an existing key pair is replaced
+func (l *localKeyManager) GenerateKey(a utils.Appliance) error {
+	privBytes, pubBytes, err := utils.GenerateKeyPair(l.keyType(a))
+	if err != nil {
+		return err
+	}
+
+	return l.putKeyPair(a, privBytes, pubBytes)
+}
+
+// ImportKey replaces the key pair of an appliance by a private key in the stored format
+func (l *localKeyManager) ImportKey(a utils.Appliance, privBytes []byte) error {
+	pubBytes, err := utils.GetPublicKeyFromPrivate(privBytes)
+	if err != nil {
+		return err
+	}
+
+	return l.putKeyPair(a, privBytes, pubBytes)
+}
+
+// ExportKey gets the private key of an appliance in the stored format, decrypted
+func (l *localKeyManager) ExportKey(a utils.Appliance) ([]byte, error) {
+	return l.getPrivateKey(a)
+}
+
+func (l *localKeyManager) putKeyPair(a utils.Appliance, privBytes, pubBytes []byte) error {
+	unlock, err := l.lockKeys(a)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	privKey, err := l.keyPath(a, defaultPrivateKey)
+	if err != nil {
+		return err
+	}
+	pubKey, err := l.keyPath(a, defaultPublicKey)
+	if err != nil {
+		return err
+	}
+
+	stored, err := encryptAtRest(privBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.Put(privKey, stored)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.Put(pubKey, pubBytes)
+	if err != nil {
+		// make sure priv/pub key exist in pairs.
+		l.store.Delete(privKey)
+	}
+	l.forgetPrivateKey(privKey)
+
+	return err
+}
+
+// encryptAtRest encrypts a private key if the 'keymanager-passphrase' setting is set
+func encryptAtRest(privBytes []byte) ([]byte, error) {
+	if passphrase, _ := utils.GetSetting("keymanager-passphrase"); passphrase != "" {
+		return utils.EncryptPrivateKey(privBytes, []byte(passphrase))
+	}
+
+	return privBytes, nil
+}
+
+// Sign signs the data of an appliance
+func (l *localKeyManager) Sign(a utils.Appliance, data []byte) ([]byte, error) {
+	content, err := l.getPrivateKey(a)
+	if err == storage.ErrorsNotFound {
+		err = l.createKeyIfAbsent(a)
+		if err == nil {
+			content, err = l.getPrivateKey(a)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.SHA256Sign(content, data)
+}
+
+// Decrypt decrypts the data of an appliance, for example the data key of an
+// encrypted envelope, see utils.UnwrapKey
+func (l *localKeyManager) Decrypt(a utils.Appliance, data []byte) ([]byte, error) {
+	content, err := l.getPrivateKey(a)
+	if err == storage.ErrorsNotFound {
+		return nil, errors.New("Fail to load private key, cannot decrypt")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return utils.UnwrapKey(content, data)
+}
+
+// forgetPrivateKey drops a cached private key
+func (l *localKeyManager) forgetPrivateKey(key string) {
+	privKeysLock.Lock()
+	defer privKeysLock.Unlock()
+	delete(privKeys, l.uri+"/"+key)
+}
+
+// getPrivateKey loads the private key of an appliance, see loadPrivateKey. A
+// plain key is encrypted at rest on its first load once the
+// 'keymanager-passphrase' setting is set.
+func (l *localKeyManager) getPrivateKey(a utils.Appliance) ([]byte, error) {
+	content, plain, err := l.loadPrivateKey(a)
+	if err != nil || !plain {
+		return content, err
+	}
+	if passphrase, _ := utils.GetSetting("keymanager-passphrase"); passphrase == "" {
+		return content, nil
+	}
+
+	if err := l.encryptPlainKey(a, content); err != nil {
+		return nil, fmt.Errorf("Fail to encrypt the plain private key by the passphrase: %v", err)
+	}
+	return content, nil
+}
+
+// loadPrivateKey loads the private key of an appliance and tells if it is kept
+// plain, an encrypted key is decrypted by the 'keymanager-passphrase' setting
+// and cached in memory. It never takes the key lock.
+func (l *localKeyManager) loadPrivateKey(a utils.Appliance) ([]byte, bool, error) {
+	key, err := l.keyPath(a, defaultPrivateKey)
+	if err != nil {
+		return nil, false, err
+	}
+	cacheKey := l.uri + "/" + key
+
+	privKeysLock.Lock()
+	defer privKeysLock.Unlock()
+
+	if content, ok := privKeys[cacheKey]; ok {
+		return content, false, nil
+	}
+
+	content, err := l.store.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !utils.IsEncryptedPrivateKey(content) {
+		return content, true, nil
+	}
+
+	passphrase, _ := utils.GetSetting("keymanager-passphrase")
+	content, err = utils.DecryptPrivateKey(content, []byte(passphrase))
+	if err != nil {
+		return nil, false, err
+	}
+	privKeys[cacheKey] = content
+
+	return content, false, nil
+}
+
+// encryptPlainKey encrypts a plain private key at rest under the key lock,
+// unless it is replaced meanwhile
+func (l *localKeyManager) encryptPlainKey(a utils.Appliance, privBytes []byte) error {
+	unlock, err := l.lockKeys(a)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key, err := l.keyPath(a, defaultPrivateKey)
+	if err != nil {
+		return err
+	}
+	content, err := l.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(content, privBytes) {
+		return nil
+	}
+
+	stored, err := encryptAtRest(privBytes)
+	if err != nil {
+		return err
+	}
+	_, err = l.store.Put(key, stored)
+	return err
+}
+
+// GetRole gets the role of an appliance, its own key with threshold 1 is
+// the default role
+func (l *localKeyManager) GetRole(a utils.Appliance) (utils.Role, error) {
+	key, err := l.metaPath(a, defaultRole)
+	if err != nil {
+		return utils.Role{}, err
+	}
+
+	content, err := l.store.Get(key)
+	if err == storage.ErrorsNotFound {
+		pubBytes, err := l.GetPublicKey(a)
+		if err != nil {
+			return utils.Role{}, err
+		}
+		return utils.NewRole(1, pubBytes)
+	} else if err != nil {
+		return utils.Role{}, err
+	}
+
+	var role utils.Role
+	if err := json.Unmarshal(content, &role); err != nil {
+		return utils.Role{}, err
+	}
+
+	return role, nil
+}
+
+// SetRole sets the role of an appliance
+func (l *localKeyManager) SetRole(a utils.Appliance, role utils.Role) error {
+	key, err := l.metaPath(a, defaultRole)
+	if err != nil {
+		return err
+	}
+	if err := role.IsValid(); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+
+	if _, err = l.store.Put(key, content); err != nil {
+		return err
+	}
+
+	// the root signatures are over the old role
+	signKey, err := l.metaPath(a, defaultRoleSign)
+	if err != nil {
+		return err
+	}
+	l.store.Delete(signKey)
+
+	return nil
+}
+
+// GetRoleSign gets the root signatures of the role of an appliance,
+// storage.ErrorsNotFound if the role is not signed by a root key
+func (l *localKeyManager) GetRoleSign(a utils.Appliance) ([]byte, error) {
+	key, err := l.metaPath(a, defaultRoleSign)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.store.Get(key)
+}
+
+// SetRoleSign sets the root signatures of the role of an appliance
+func (l *localKeyManager) SetRoleSign(a utils.Appliance, data []byte) error {
+	key, err := l.metaPath(a, defaultRoleSign)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.Put(key, data)
+	return err
+}
+
+// GetRevocations gets the signed revocation list of an appliance
+func (l *localKeyManager) GetRevocations(a utils.Appliance) ([]byte, error) {
+	key, err := l.metaPath(a, defaultRevocation)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.store.Get(key)
+}
+
+// SetRevocations sets the signed revocation list of an appliance
+func (l *localKeyManager) SetRevocations(a utils.Appliance, data []byte) error {
+	key, err := l.metaPath(a, defaultRevocation)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.Put(key, data)
+	return err
+}
+
+func (l *localKeyManager) Debug() {
+}
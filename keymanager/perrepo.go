@@ -0,0 +1,52 @@
+package keymanager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	perrepoName        = "perrepo"
+	perrepoDescription = "each repository has its own private/public key pair"
+)
+
+// KeyManagerPerrepo is the perrepo implementation of a key manager,
+// every repository has an independent key pair.
+type KeyManagerPerrepo struct {
+	localKeyManager
+}
+
+func init() {
+	RegisterKeyManager(perrepoName, &KeyManagerPerrepo{})
+}
+
+func (pr *KeyManagerPerrepo) ModeName() string {
+	return perrepoName
+}
+
+func (pr *KeyManagerPerrepo) Description() string {
+	return perrepoDescription
+}
+
+// New returns a new keymanager by a uri, the registered instance is never changed
+func (pr *KeyManagerPerrepo) New(uri string) (KeyManager, error) {
+	l, err := newLocalKeyManager(uri, perrepoKeyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyManagerPerrepo{localKeyManager: l}, nil
+}
+
+// perrepoKeyDir keys everything by 'Proto/Version/Namespace/Repository'
+func perrepoKeyDir(a utils.Appliance) (string, error) {
+	for _, name := range []string{a.Proto, a.Version, a.Namespace, a.Repository} {
+		if !isValidPathName(name) {
+			return "", errors.New("Proto/Version/Namespace/Repository should not be empty or escape the key directory")
+		}
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%s", a.Proto, a.Version, a.Namespace, a.Repository), nil
+}
@@ -0,0 +1,46 @@
+package keymanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestPerrepoGetPublicKey(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	RegisterKeyManager(perrepoName, &KeyManagerPerrepo{})
+	l, err := NewKeyManager(perrepoName, tmpPath)
+	assert.Nil(t, err, "Fail to setup a perrepo key manager")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops", Repository: "official"}
+	b := a
+	b.Repository = "incubator"
+
+	aPub, err := l.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get public key")
+	bPub, err := l.GetPublicKey(b)
+	assert.Nil(t, err, "Fail to get public key")
+	assert.NotEqual(t, aPub, bPub, "Repositories should not share keys")
+
+	testBytes := []byte("This is the content to be signed")
+	sig, err := l.Sign(b, testBytes)
+	assert.Nil(t, err, "Fail to sign")
+	assert.Nil(t, utils.SHA256Verify(bPub, testBytes, sig), "Fail to verify by the repository key")
+	assert.NotNil(t, utils.SHA256Verify(aPub, testBytes, sig), "Should not verify by another repository key")
+
+	b.Repository = ""
+	_, err = l.GetPublicKey(b)
+	assert.NotNil(t, err, "Should not get public key without repository")
+	for _, repo := range []string{"..", "a/b", `a\b`} {
+		b.Repository = repo
+		_, err = l.GetPublicKey(b)
+		assert.NotNil(t, err, "Should not escape the key directory")
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/liangchenye/update-service/storage"
 	"github.com/liangchenye/update-service/utils"
 )
 
@@ -22,6 +24,49 @@ func TestPeruserNew(t *testing.T) {
 
 	_, err = keymanager.New(invalidURI)
 	assert.NotNil(t, err, "Should not setup an invalid key manager")
+
+	a, _ := keymanager.New("/tmp/containerops_km_a")
+	b, _ := keymanager.New("/tmp/containerops_km_b")
+	assert.Equal(t, "/tmp/containerops_km_a", a.(*KeyManagerPeruser).uri, "Every New should get a new instance")
+	assert.Equal(t, "/tmp/containerops_km_b", b.(*KeyManagerPeruser).uri, "Every New should get a new instance")
+	assert.Nil(t, keymanager.store, "New should not change the registered instance")
+}
+
+func TestPeruserConcurrentKeyCreation(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	testBytes := []byte("This is the content to be signed")
+
+	// the first requests of a namespace come at the same time, by different instances
+	var wg sync.WaitGroup
+	sigs := make([][]byte, 20)
+	pubs := make([][]byte, 20)
+	for i := range sigs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l, _ := NewKeyManager("peruser", tmpPath)
+			if i%2 == 0 {
+				sigs[i], _ = l.Sign(a, testBytes)
+			} else {
+				pubs[i], _ = l.GetPublicKey(a)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	pubBytes, _ := l.GetPublicKey(a)
+	for i := range sigs {
+		if i%2 == 0 {
+			assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, sigs[i]), "Every signature should be made by the published key")
+		} else {
+			assert.Equal(t, pubBytes, pubs[i], "Only one key pair should be generated")
+		}
+	}
 }
 
 func TestPeruserGetPublicKey(t *testing.T) {
@@ -84,3 +129,161 @@ func TestPeruserDecrypt(t *testing.T) {
 	assert.Nil(t, err, "Fail to decrypt")
 	assert.Equal(t, expectedByte, data, "Fail to decrypt correctly")
 }
+
+func TestPeruserKeyType(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	utils.SetSetting("keymanager-keytype/edns", utils.KeyTypeEd25519)
+	defer utils.SetSetting("keymanager-keytype/edns", "")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "edns"}
+	testBytes := []byte("This is the content to be signed")
+
+	data, err := l.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign")
+	pubBytes, err := l.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get public key")
+
+	keyType, _ := utils.GetKeyType(pubBytes)
+	assert.Equal(t, utils.KeyTypeEd25519, keyType, "Fail to generate key of the namespace key type")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, data), "Fail to verify signed data")
+}
+
+func TestPeruserRole(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+
+	role, err := l.GetRole(a)
+	assert.Nil(t, err, "Fail to get default role")
+	assert.Equal(t, 1, role.Threshold, "Default role should require one signature")
+	pubBytes, _ := l.GetPublicKey(a)
+	keyID, _ := utils.KeyID(pubBytes)
+	assert.Equal(t, string(pubBytes), role.Keys[keyID], "Default role should trust the namespace key")
+
+	_, offlinePub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	newRole, _ := utils.NewRole(2, pubBytes, offlinePub)
+	err = l.SetRole(a, newRole)
+	assert.Nil(t, err, "Fail to set role")
+	role, _ = l.GetRole(a)
+	assert.Equal(t, newRole, role, "Fail to get the saved role")
+
+	err = l.SetRole(a, utils.Role{Threshold: 3, Keys: newRole.Keys})
+	assert.NotNil(t, err, "Should not set an invalid role")
+
+	_, err = l.GetRoleSign(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "Role should not be signed by default")
+	assert.Nil(t, l.SetRoleSign(a, []byte("root signatures")), "Fail to set role signatures")
+	data, _ := l.GetRoleSign(a)
+	assert.Equal(t, []byte("root signatures"), data, "Fail to get the saved role signatures")
+	l.SetRole(a, newRole)
+	_, err = l.GetRoleSign(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "Role signatures should be dropped with the old role")
+}
+
+func TestPeruserImportExport(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	testBytes := []byte("This is the content to be signed")
+
+	_, err = l.ExportKey(a)
+	assert.NotNil(t, err, "Should not export a key before it is generated")
+
+	// the imported key replaces the generated one, even a cached one
+	_, err = l.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign by the generated key")
+	privBytes, pubBytes, _ := utils.GenerateKeyPair(utils.KeyTypeECDSAP256)
+	err = l.ImportKey(a, privBytes)
+	assert.Nil(t, err, "Fail to import a key")
+
+	data, err := l.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign by the imported key")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, data), "Fail to sign by the imported key")
+	newPub, _ := l.GetPublicKey(a)
+	assert.Equal(t, pubBytes, newPub, "Fail to replace the public key")
+
+	exported, err := l.ExportKey(a)
+	assert.Nil(t, err, "Fail to export the key")
+	assert.Equal(t, privBytes, exported, "Fail to export the imported key")
+
+	assert.NotNil(t, l.ImportKey(a, []byte("invalid key")), "Should not import an invalid key")
+}
+
+func TestPeruserPassphrase(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	utils.SetSetting("keymanager-passphrase", "test passphrase")
+	defer utils.SetSetting("keymanager-passphrase", "")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	testBytes := []byte("This is the content to be signed")
+
+	data, err := l.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign by an encrypted key")
+	pubBytes, _ := l.GetPublicKey(a)
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, data), "Fail to verify signed data")
+
+	privBytes, _ := ioutil.ReadFile(filepath.Join(tmpPath, "app", "v1", "containerops", defaultPrivateKey))
+	assert.True(t, utils.IsEncryptedPrivateKey(privBytes), "Private key should be encrypted at rest")
+
+	// a new key manager could not load the key without the passphrase
+	privKeysLock.Lock()
+	privKeys = make(map[string][]byte)
+	privKeysLock.Unlock()
+	utils.SetSetting("keymanager-passphrase", "")
+	_, err = l.Sign(a, testBytes)
+	assert.Equal(t, utils.ErrorsPassphraseRequired, err)
+
+	// a plain key is encrypted at its first load once the passphrase is set
+	plain := utils.Appliance{Proto: "app", Version: "v1", Namespace: "plain"}
+	pubBytes, _ = l.GetPublicKey(plain)
+	privPath := filepath.Join(tmpPath, "app", "v1", "plain", defaultPrivateKey)
+	privBytes, _ = ioutil.ReadFile(privPath)
+	assert.False(t, utils.IsEncryptedPrivateKey(privBytes), "Private key should be plain without a passphrase")
+	utils.SetSetting("keymanager-passphrase", "test passphrase")
+	data, err = l.Sign(plain, testBytes)
+	assert.Nil(t, err, "Fail to sign by a plain key")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, data), "Fail to verify signed data")
+	privBytes, _ = ioutil.ReadFile(privPath)
+	assert.True(t, utils.IsEncryptedPrivateKey(privBytes), "Plain private key should be encrypted at rest")
+	data, err = l.Sign(plain, testBytes)
+	assert.Nil(t, err, "Fail to sign by the encrypted key")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, data), "Fail to verify signed data")
+}
+
+func TestPeruserDecryptEnvelope(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	utils.SetSetting("keymanager-keytype/ecns", utils.KeyTypeECDSAP256)
+	defer utils.SetSetting("keymanager-keytype/ecns", "")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "ecns"}
+	testBytes := []byte("This is the confidential content")
+
+	pubBytes, _ := l.GetPublicKey(a)
+	data, err := utils.Encrypt(pubBytes, testBytes)
+	assert.Nil(t, err, "Fail to encrypt to the namespace key")
+
+	env, _ := utils.ParseEncryptedEnvelope(data)
+	plain, err := env.Decrypt(func(wrapped []byte) ([]byte, error) {
+		return l.Decrypt(a, wrapped)
+	})
+	assert.Nil(t, err, "Fail to decrypt through the key manager")
+	assert.Equal(t, testBytes, plain, "Fail to decrypt correctly")
+}
@@ -0,0 +1,240 @@
+package keymanager
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	remoteName        = "remote"
+	remoteDescription = "keys are kept by a remote signing service, only public keys and signatures pass through the update server"
+
+	remoteTimeout = 30 * time.Second
+	// RemoteMaxBodySize is the size of the largest request or response body of
+	// the remote key manager protocol, it is base64 encoded data of a meta file
+	RemoteMaxBodySize = 64 << 20
+)
+
+// The remote key manager protocol: every call is a POST of a json RemoteRequest
+// to '/v1/<call>', the result is a json RemoteResponse with status 200, or a
+// RemoteResponse with the 'error' field and a status other than 200.
+const (
+	RemoteGenerateKeyPath    = "/v1/generatekey"
+	RemoteGetPublicKeyPath   = "/v1/publickey"
+	RemoteSignPath           = "/v1/sign"
+	RemoteDecryptPath        = "/v1/decrypt"
+	RemoteGetRolePath        = "/v1/role"
+	RemoteSetRolePath        = "/v1/setrole"
+	RemoteGetRoleSignPath    = "/v1/rolesign"
+	RemoteSetRoleSignPath    = "/v1/setrolesign"
+	RemoteGetRevocationsPath = "/v1/revocations"
+	RemoteSetRevocationsPath = "/v1/setrevocations"
+)
+
+// ErrorsRemoteKeyNotPortable occurs when importing or exporting a key by the
+// update server, it should be done on the signing service.
+var ErrorsRemoteKeyNotPortable = errors.New("keys of the remote key manager could only be imported or exported by the signing service")
+
+// RemoteRequest is the request of a remote key manager call
+type RemoteRequest struct {
+	Appliance utils.Appliance `json:"appliance"`
+	// Data is the data to sign or decrypt, or the role signatures or revocations to set
+	Data []byte      `json:"data,omitempty"`
+	Role *utils.Role `json:"role,omitempty"`
+}
+
+// RemoteResponse is the response of a remote key manager call
+type RemoteResponse struct {
+	// Data is the public key, the signature, the decrypted data, the role signatures or the revocations
+	Data  []byte      `json:"data,omitempty"`
+	Role  *utils.Role `json:"role,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// KeyManagerRemote is the remote implementation of a key manager,
+// it delegates all the key operations to a signing service over mTLS.
+type KeyManagerRemote struct {
+	uri    string
+	client *http.Client
+}
+
+func init() {
+	RegisterKeyManager(remoteName, &KeyManagerRemote{})
+}
+
+func (r *KeyManagerRemote) ModeName() string {
+	return remoteName
+}
+
+func (r *KeyManagerRemote) Description() string {
+	return remoteDescription
+}
+
+// New returns a remote keymanager by a uri like
+// 'https://kms:8443?ca=/etc/us/ca.pem&cert=/etc/us/client.pem&key=/etc/us/client-key.pem',
+// 'ca' verifies the signing service, 'cert' and 'key' are the client certificate.
+func (r *KeyManagerRemote) New(uri string) (KeyManager, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid uri set in KeyManagerRemote.New, should be https: %s", uri)
+	}
+
+	query := u.Query()
+	if query.Get("cert") == "" || query.Get("key") == "" {
+		return nil, errors.New("client 'cert' and 'key' are required by the remote key manager")
+	}
+
+	cert, err := tls.LoadX509KeyPair(query.Get("cert"), query.Get("key"))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if query.Get("ca") != "" {
+		caBytes, err := ioutil.ReadFile(query.Get("ca"))
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("Fail to load the ca of the remote key manager")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	u.RawQuery = ""
+	return &KeyManagerRemote{
+		uri: u.String(),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   remoteTimeout,
+		},
+	}, nil
+}
+
+func (r *KeyManagerRemote) call(path string, req RemoteRequest) (RemoteResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return RemoteResponse{}, err
+	}
+
+	resp, err := r.client.Post(r.uri+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return RemoteResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, RemoteMaxBodySize))
+	if err != nil {
+		return RemoteResponse{}, fmt.Errorf("Fail to read the response of the remote key manager: %v", err)
+	}
+
+	var ret RemoteResponse
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return RemoteResponse{}, fmt.Errorf("Invalid response of the remote key manager: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if ret.Error == "" {
+			ret.Error = resp.Status
+		} else if ret.Error == storage.ErrorsNotFound.Error() {
+			return RemoteResponse{}, storage.ErrorsNotFound
+		}
+		return RemoteResponse{}, fmt.Errorf("remote key manager: %s", ret.Error)
+	}
+
+	return ret, nil
+}
+
+// GenerateKey asks the signing service to generate the keys of a namespace
+func (r *KeyManagerRemote) GenerateKey(a utils.Appliance) error {
+	_, err := r.call(RemoteGenerateKeyPath, RemoteRequest{Appliance: a})
+	return err
+}
+
+// GetPublicKey gets the public key data of a namespace from the signing service
+func (r *KeyManagerRemote) GetPublicKey(a utils.Appliance) ([]byte, error) {
+	ret, err := r.call(RemoteGetPublicKeyPath, RemoteRequest{Appliance: a})
+	return ret.Data, err
+}
+
+// Sign asks the signing service to sign the data of a namespace
+func (r *KeyManagerRemote) Sign(a utils.Appliance, data []byte) ([]byte, error) {
+	ret, err := r.call(RemoteSignPath, RemoteRequest{Appliance: a, Data: data})
+	return ret.Data, err
+}
+
+// Decrypt asks the signing service to decrypt the data of a namespace
+func (r *KeyManagerRemote) Decrypt(a utils.Appliance, data []byte) ([]byte, error) {
+	ret, err := r.call(RemoteDecryptPath, RemoteRequest{Appliance: a, Data: data})
+	return ret.Data, err
+}
+
+// GetRole gets the role of a namespace from the signing service
+func (r *KeyManagerRemote) GetRole(a utils.Appliance) (utils.Role, error) {
+	ret, err := r.call(RemoteGetRolePath, RemoteRequest{Appliance: a})
+	if err != nil {
+		return utils.Role{}, err
+	}
+	if ret.Role == nil {
+		return utils.Role{}, errors.New("remote key manager returns an empty role")
+	}
+
+	return *ret.Role, nil
+}
+
+// SetRole sets the role of a namespace to the signing service
+func (r *KeyManagerRemote) SetRole(a utils.Appliance, role utils.Role) error {
+	_, err := r.call(RemoteSetRolePath, RemoteRequest{Appliance: a, Role: &role})
+	return err
+}
+
+// ImportKey is not supported, keys never pass through the update server
+func (r *KeyManagerRemote) ImportKey(a utils.Appliance, privBytes []byte) error {
+	return ErrorsRemoteKeyNotPortable
+}
+
+// ExportKey is not supported, keys never pass through the update server
+func (r *KeyManagerRemote) ExportKey(a utils.Appliance) ([]byte, error) {
+	return nil, ErrorsRemoteKeyNotPortable
+}
+
+// GetRoleSign gets the root signatures of the role of a namespace from the signing service
+func (r *KeyManagerRemote) GetRoleSign(a utils.Appliance) ([]byte, error) {
+	ret, err := r.call(RemoteGetRoleSignPath, RemoteRequest{Appliance: a})
+	return ret.Data, err
+}
+
+// SetRoleSign sets the root signatures of the role of a namespace to the signing service
+func (r *KeyManagerRemote) SetRoleSign(a utils.Appliance, data []byte) error {
+	_, err := r.call(RemoteSetRoleSignPath, RemoteRequest{Appliance: a, Data: data})
+	return err
+}
+
+// GetRevocations gets the signed revocation list of a namespace from the signing service
+func (r *KeyManagerRemote) GetRevocations(a utils.Appliance) ([]byte, error) {
+	ret, err := r.call(RemoteGetRevocationsPath, RemoteRequest{Appliance: a})
+	return ret.Data, err
+}
+
+// SetRevocations sets the signed revocation list of a namespace to the signing service
+func (r *KeyManagerRemote) SetRevocations(a utils.Appliance, data []byte) error {
+	_, err := r.call(RemoteSetRevocationsPath, RemoteRequest{Appliance: a, Data: data})
+	return err
+}
+
+func (r *KeyManagerRemote) Debug() {
+}
@@ -0,0 +1,207 @@
+package keymanager
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// createCert creates a certificate signed by 'parent', or a self signed ca if 'parent' is nil
+func createCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err, "Fail to create certificate")
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestRemoteKeyManager(t *testing.T) {
+	// other tests may expunge the registered implementations
+	RegisterKeyManager("peruser", &KeyManagerPeruser{})
+	RegisterKeyManager("remote", &KeyManagerRemote{})
+
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	ca, caKey, caPEM, _ := createCert(t, "test ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := createCert(t, "uskms", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := createCert(t, "upserver", ca, caKey)
+	files := map[string][]byte{"ca.pem": caPEM, "client.pem": clientPEM, "client-key.pem": clientKeyPEM}
+	for name, data := range files {
+		ioutil.WriteFile(filepath.Join(tmpPath, name), data, 0600)
+	}
+
+	local, _ := NewKeyManager("peruser", filepath.Join(tmpPath, "kms"))
+	server := httptest.NewUnstartedServer(NewRemoteHandler(local, nil))
+	serverCert, _ := tls.X509KeyPair(serverPEM, serverKeyPEM)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	uri := fmt.Sprintf("%s?ca=%s&cert=%s&key=%s", server.URL,
+		filepath.Join(tmpPath, "ca.pem"), filepath.Join(tmpPath, "client.pem"), filepath.Join(tmpPath, "client-key.pem"))
+	remote, err := NewKeyManager("remote", uri)
+	assert.Nil(t, err, "Fail to setup a remote key manager")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	testBytes := []byte("This is the content to be signed")
+
+	// sign remotely and verify by the public key
+	sig, err := remote.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign remotely")
+	pubBytes, err := remote.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get public key remotely")
+	localPub, _ := local.GetPublicKey(a)
+	assert.Equal(t, localPub, pubBytes, "Fail to get the public key of the signing service")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, testBytes, sig), "Fail to verify remote signature")
+
+	// decrypt remotely
+	encrypted, _ := utils.RSAEncrypt(pubBytes, testBytes)
+	decrypted, err := remote.Decrypt(a, encrypted)
+	assert.Nil(t, err, "Fail to decrypt remotely")
+	assert.Equal(t, testBytes, decrypted, "Fail to decrypt correctly")
+
+	// role
+	role, err := remote.GetRole(a)
+	assert.Nil(t, err, "Fail to get role remotely")
+	assert.Equal(t, 1, role.Threshold, "Fail to get the default role")
+	_, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	newRole, _ := utils.NewRole(2, pubBytes, otherPub)
+	assert.Nil(t, remote.SetRole(a, newRole), "Fail to set role remotely")
+	role, _ = remote.GetRole(a)
+	assert.Equal(t, newRole, role, "Fail to get the role set remotely")
+
+	// role signatures, unsigned roles are not found as the local key managers
+	_, err = remote.GetRoleSign(a)
+	assert.Equal(t, storage.ErrorsNotFound, err, "Role should not be signed by default")
+	assert.Nil(t, remote.SetRoleSign(a, []byte("root signatures")), "Fail to set role signatures remotely")
+	data, _ := remote.GetRoleSign(a)
+	assert.Equal(t, []byte("root signatures"), data, "Fail to get the role signatures set remotely")
+
+	// keys never pass through the update server
+	assert.Equal(t, ErrorsRemoteKeyNotPortable, remote.ImportKey(a, nil))
+	_, err = remote.ExportKey(a)
+	assert.Equal(t, ErrorsRemoteKeyNotPortable, err)
+
+	// errors of the signing service are returned
+	_, err = remote.Sign(utils.Appliance{Proto: "app", Version: "v1", Namespace: "../escape"}, testBytes)
+	assert.NotNil(t, err, "Should not sign for an invalid namespace")
+	_, err = remote.Sign(utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops", Repository: ".."}, testBytes)
+	assert.NotNil(t, err, "Should not sign for an invalid repository")
+
+	// large requests are refused
+	rec := httptest.NewRecorder()
+	large := bytes.NewReader(append([]byte(`{"data":"`), bytes.Repeat([]byte("A"), RemoteMaxBodySize)...))
+	NewRemoteHandler(local, nil).ServeHTTP(rec, httptest.NewRequest("POST", RemoteSignPath, large))
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "Should not read a request larger than the limit")
+
+	// clients without a certificate are refused
+	noCert, _ := NewKeyManager("remote", server.URL+"?ca="+filepath.Join(tmpPath, "ca.pem"))
+	assert.Nil(t, noCert, "Should not setup a remote key manager without client certificate")
+	_, err = NewKeyManager("remote", "http://localhost?cert=a&key=b")
+	assert.NotNil(t, err, "Should not setup a remote key manager without https")
+}
+
+func TestRemoteDecryptOnly(t *testing.T) {
+	RegisterKeyManager("peruser", &KeyManagerPeruser{})
+	RegisterKeyManager("remote", &KeyManagerRemote{})
+
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	ca, caKey, caPEM, _ := createCert(t, "test ca", nil, nil)
+	decryptCA, decryptCAKey, _, _ := createCert(t, "test decrypt ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := createCert(t, "uskms", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := createCert(t, "upserver", ca, caKey)
+	_, _, decryptPEM, decryptKeyPEM := createCert(t, "uc", decryptCA, decryptCAKey)
+	files := map[string][]byte{"ca.pem": caPEM, "client.pem": clientPEM, "client-key.pem": clientKeyPEM,
+		"decrypt.pem": decryptPEM, "decrypt-key.pem": decryptKeyPEM}
+	for name, data := range files {
+		ioutil.WriteFile(filepath.Join(tmpPath, name), data, 0600)
+	}
+
+	local, _ := NewKeyManager("peruser", filepath.Join(tmpPath, "kms"))
+	server := httptest.NewUnstartedServer(NewRemoteHandler(local, []*x509.Certificate{decryptCA}))
+	serverCert, _ := tls.X509KeyPair(serverPEM, serverKeyPEM)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	pool.AddCert(decryptCA)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	uri := func(cert string) string {
+		return fmt.Sprintf("%s?ca=%s&cert=%s&key=%s", server.URL, filepath.Join(tmpPath, "ca.pem"),
+			filepath.Join(tmpPath, cert+".pem"), filepath.Join(tmpPath, cert+"-key.pem"))
+	}
+	remote, _ := NewKeyManager("remote", uri("client"))
+	decrypter, _ := NewKeyManager("remote", uri("decrypt"))
+	assert.NotNil(t, decrypter, "Fail to setup a decrypt-only remote key manager")
+
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	testBytes := []byte("This is the content to be encrypted")
+	pubBytes, err := remote.GetPublicKey(a)
+	assert.Nil(t, err, "Fail to get public key remotely")
+	encrypted, _ := utils.RSAEncrypt(pubBytes, testBytes)
+	decrypted, err := decrypter.Decrypt(a, encrypted)
+	assert.Nil(t, err, "Fail to decrypt by a decrypt-only certificate")
+	assert.Equal(t, testBytes, decrypted)
+
+	_, err = decrypter.Sign(a, testBytes)
+	assert.NotNil(t, err, "Should not sign by a decrypt-only certificate")
+	role, _ := remote.GetRole(a)
+	assert.NotNil(t, decrypter.SetRole(a, role), "Should not set the role by a decrypt-only certificate")
+	_, err = remote.Sign(a, testBytes)
+	assert.Nil(t, err, "Fail to sign by the update server certificate")
+}
@@ -0,0 +1,129 @@
+package keymanager
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// NewRemoteHandler serves the remote key manager protocol by a local key
+// manager, it is the handler of the reference signing service 'uskms'.
+// mTLS is up to the http server running the handler. The clients verified by
+// 'decryptCAs' only, for example the update clients decrypting files, could
+// only decrypt, the others could call every endpoint.
+func NewRemoteHandler(km KeyManager, decryptCAs []*x509.Certificate) http.Handler {
+	mux := http.NewServeMux()
+
+	handle := func(path string, f func(req RemoteRequest) (RemoteResponse, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			var ret RemoteResponse
+			code := http.StatusOK
+
+			var req RemoteRequest
+			err := errors.New("method not allowed")
+			if path != RemoteDecryptPath && isDecryptOnly(r, decryptCAs) {
+				err = errors.New("the client certificate is only allowed to decrypt")
+				code = http.StatusForbidden
+			} else if r.Method == "POST" {
+				err = json.NewDecoder(http.MaxBytesReader(w, r.Body, RemoteMaxBodySize)).Decode(&req)
+			}
+			if err == nil {
+				err = isValidRemoteRequest(req)
+			}
+			if err == nil {
+				ret, err = f(req)
+			}
+			if err != nil {
+				ret = RemoteResponse{Error: err.Error()}
+				if code == http.StatusOK {
+					code = http.StatusBadRequest
+				}
+			}
+
+			result, _ := json.Marshal(ret)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			w.Write(result)
+		})
+	}
+
+	handle(RemoteGenerateKeyPath, func(req RemoteRequest) (RemoteResponse, error) {
+		return RemoteResponse{}, km.GenerateKey(req.Appliance)
+	})
+	handle(RemoteGetPublicKeyPath, func(req RemoteRequest) (RemoteResponse, error) {
+		data, err := km.GetPublicKey(req.Appliance)
+		return RemoteResponse{Data: data}, err
+	})
+	handle(RemoteSignPath, func(req RemoteRequest) (RemoteResponse, error) {
+		data, err := km.Sign(req.Appliance, req.Data)
+		return RemoteResponse{Data: data}, err
+	})
+	handle(RemoteDecryptPath, func(req RemoteRequest) (RemoteResponse, error) {
+		data, err := km.Decrypt(req.Appliance, req.Data)
+		return RemoteResponse{Data: data}, err
+	})
+	handle(RemoteGetRolePath, func(req RemoteRequest) (RemoteResponse, error) {
+		role, err := km.GetRole(req.Appliance)
+		return RemoteResponse{Role: &role}, err
+	})
+	handle(RemoteSetRolePath, func(req RemoteRequest) (RemoteResponse, error) {
+		if req.Role == nil {
+			return RemoteResponse{}, errors.New("role should not be empty")
+		}
+		return RemoteResponse{}, km.SetRole(req.Appliance, *req.Role)
+	})
+	handle(RemoteGetRoleSignPath, func(req RemoteRequest) (RemoteResponse, error) {
+		data, err := km.GetRoleSign(req.Appliance)
+		return RemoteResponse{Data: data}, err
+	})
+	handle(RemoteSetRoleSignPath, func(req RemoteRequest) (RemoteResponse, error) {
+		return RemoteResponse{}, km.SetRoleSign(req.Appliance, req.Data)
+	})
+	handle(RemoteGetRevocationsPath, func(req RemoteRequest) (RemoteResponse, error) {
+		data, err := km.GetRevocations(req.Appliance)
+		return RemoteResponse{Data: data}, err
+	})
+	handle(RemoteSetRevocationsPath, func(req RemoteRequest) (RemoteResponse, error) {
+		return RemoteResponse{}, km.SetRevocations(req.Appliance, req.Data)
+	})
+
+	return mux
+}
+
+// isDecryptOnly tells if all the verified chains of the client certificate end
+// at the decrypt-only cas
+func isDecryptOnly(r *http.Request, decryptCAs []*x509.Certificate) bool {
+	if r.TLS == nil || len(decryptCAs) == 0 {
+		return false
+	}
+	for _, chain := range r.TLS.VerifiedChains {
+		decryptOnly := false
+		for _, ca := range decryptCAs {
+			if len(chain) > 0 && chain[len(chain)-1].Equal(ca) {
+				decryptOnly = true
+			}
+		}
+		if !decryptOnly {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValidRemoteRequest makes sure a request could not escape its namespace
+// in the storage of the local key manager
+func isValidRemoteRequest(req RemoteRequest) error {
+	for _, s := range []string{req.Appliance.Proto, req.Appliance.Version, req.Appliance.Namespace} {
+		if !isValidPathName(s) {
+			return errors.New("invalid Proto/Version/Namespace of the appliance")
+		}
+	}
+	// the repository is empty for the keys of a namespace
+	if req.Appliance.Repository != "" && !isValidPathName(req.Appliance.Repository) {
+		return errors.New("invalid Repository of the appliance")
+	}
+
+	return nil
+}
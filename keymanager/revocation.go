@@ -0,0 +1,144 @@
+package keymanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// GetRevocationList gets the revocation list of an appliance, it is empty
+// if no key is revoked.
+func GetRevocationList(km KeyManager, a utils.Appliance) (utils.RevocationList, error) {
+	data, err := km.GetRevocations(a)
+	if err == storage.ErrorsNotFound {
+		return utils.RevocationList{}, nil
+	} else if err != nil {
+		return utils.RevocationList{}, err
+	}
+
+	srl, err := utils.ParseSignedRevocationList(data)
+	if err != nil {
+		return utils.RevocationList{}, err
+	}
+
+	return srl.Signed, nil
+}
+
+// Revoke adds a key id to the revocation list of an appliance, the current
+// key is revoked if 'keyID' is empty.
+// A revoked current key is replaced by a new key pair, which signs the list.
+func Revoke(km KeyManager, a utils.Appliance, keyID string, reason string) (string, error) {
+	rl, err := GetRevocationList(km, a)
+	if err != nil {
+		return "", err
+	}
+
+	pubBytes, err := km.GetPublicKey(a)
+	if err != nil {
+		return "", err
+	}
+	currentID, err := utils.KeyID(pubBytes)
+	if err != nil {
+		return "", err
+	}
+	if keyID == "" {
+		keyID = currentID
+	}
+
+	if err := rl.Revoke(keyID, reason); err != nil {
+		return "", err
+	}
+
+	if keyID == currentID {
+		if err := km.GenerateKey(a); err != nil {
+			return "", err
+		}
+		if pubBytes, err = km.GetPublicKey(a); err != nil {
+			return "", err
+		}
+	}
+
+	payload, err := utils.CanonicalJSON(rl)
+	if err != nil {
+		return "", err
+	}
+	srl := utils.SignedRevocationList{Signed: rl, Signatures: utils.NewSignatureEnvelope(utils.RevocationPayloadType)}
+	sig, err := km.Sign(a, srl.Signatures.PAE(payload))
+	if err != nil {
+		return "", err
+	}
+	if err := srl.Signatures.AddSignature(pubBytes, sig); err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(srl)
+	if err != nil {
+		return "", err
+	}
+
+	return keyID, km.SetRevocations(a, content)
+}
+
+// ImportRevocationSignatures adds the signatures of an envelope made offline over the
+// canonical json of the revocation list of an appliance, so clients requiring the
+// threshold of the role or the root key could verify it. Every signature should be
+// made by the root key of 'rootPub', if it is not empty, or a key of the role which
+// is not revoked.
+func ImportRevocationSignatures(km KeyManager, a utils.Appliance, data []byte, rootPub []byte) error {
+	imported, err := utils.ParseSignatureEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	content, err := km.GetRevocations(a)
+	if err == storage.ErrorsNotFound {
+		return errors.New("No key is revoked, there is no revocation list to sign")
+	} else if err != nil {
+		return err
+	}
+	srl, err := utils.ParseSignedRevocationList(content)
+	if err != nil {
+		return err
+	}
+	payload, err := utils.CanonicalJSON(srl.Signed)
+	if err != nil {
+		return err
+	}
+
+	role, err := km.GetRole(a)
+	if err != nil {
+		return err
+	}
+	trusted := [][]byte{}
+	if len(rootPub) > 0 {
+		trusted = append(trusted, rootPub)
+	}
+	for keyID, pubKey := range role.Keys {
+		if !srl.Signed.IsRevoked(keyID) {
+			trusted = append(trusted, []byte(pubKey))
+		}
+	}
+	for _, sig := range imported.Signatures {
+		verified := false
+		for _, pubBytes := range trusted {
+			env := utils.SignatureEnvelope{PayloadType: imported.PayloadType, Signatures: []utils.Signature{sig}}
+			if env.Verify(pubBytes, utils.RevocationPayloadType, payload) == nil {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return fmt.Errorf("Fail to verify the revocation signature of key %s", sig.KeyID)
+		}
+	}
+
+	srl.Signatures.Merge(imported)
+	content, err = json.Marshal(srl)
+	if err != nil {
+		return err
+	}
+
+	return km.SetRevocations(a, content)
+}
@@ -0,0 +1,94 @@
+package keymanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestRevoke(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+
+	rl, err := GetRevocationList(l, a)
+	assert.Nil(t, err, "Fail to get the default revocation list")
+	assert.Equal(t, 0, len(rl.Revocations), "No key should be revoked by default")
+
+	// revoking another key keeps the current one
+	_, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	otherID, _ := utils.KeyID(otherPub)
+	oldPub, _ := l.GetPublicKey(a)
+	keyID, err := Revoke(l, a, otherID, "leaked")
+	assert.Nil(t, err, "Fail to revoke a key")
+	assert.Equal(t, otherID, keyID)
+	pubBytes, _ := l.GetPublicKey(a)
+	assert.Equal(t, oldPub, pubBytes, "Revoking another key should not replace the current one")
+
+	// revoking the current key replaces it, the new key signs the list
+	oldID, _ := utils.KeyID(oldPub)
+	keyID, err = Revoke(l, a, "", "leaked")
+	assert.Nil(t, err, "Fail to revoke the current key")
+	assert.Equal(t, oldID, keyID, "The current key should be revoked by default")
+	pubBytes, _ = l.GetPublicKey(a)
+	assert.NotEqual(t, oldPub, pubBytes, "Fail to replace the revoked key")
+
+	data, err := l.GetRevocations(a)
+	assert.Nil(t, err, "Fail to get the signed revocation list")
+	srl, _ := utils.ParseSignedRevocationList(data)
+	assert.Nil(t, srl.Verify(pubBytes), "Fail to verify the list by the new key")
+	assert.NotNil(t, srl.Verify(oldPub), "The revoked key should not verify the list")
+	assert.Equal(t, 2, srl.Signed.Version)
+	assert.True(t, srl.Signed.IsRevoked(otherID) && srl.Signed.IsRevoked(oldID), "Fail to keep all the revocations")
+
+	_, err = Revoke(l, a, otherID, "")
+	assert.NotNil(t, err, "Should not revoke a key twice")
+}
+
+func TestImportRevocationSignatures(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	rootPriv, rootPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	otherPriv, _, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	sign := func(priv []byte, payload []byte) []byte {
+		pub, _ := utils.GetPublicKeyFromPrivate(priv)
+		env := utils.NewSignatureEnvelope(utils.RevocationPayloadType)
+		sig, _ := utils.SHA256Sign(priv, env.PAE(payload))
+		env.AddSignature(pub, sig)
+		data, _ := json.Marshal(env)
+		return data
+	}
+
+	err = ImportRevocationSignatures(l, a, sign(rootPriv, []byte("payload")), rootPub)
+	assert.NotNil(t, err, "Should not sign a list before any key is revoked")
+
+	_, err = Revoke(l, a, "", "leaked")
+	assert.Nil(t, err, "Fail to revoke the current key")
+	rl, _ := GetRevocationList(l, a)
+	payload, _ := utils.CanonicalJSON(rl)
+
+	err = ImportRevocationSignatures(l, a, sign(otherPriv, payload), rootPub)
+	assert.NotNil(t, err, "Should not import signatures of another key")
+	err = ImportRevocationSignatures(l, a, sign(rootPriv, []byte("other payload")), rootPub)
+	assert.NotNil(t, err, "Should not import signatures over another payload")
+
+	err = ImportRevocationSignatures(l, a, sign(rootPriv, payload), rootPub)
+	assert.Nil(t, err, "Fail to import the root signature")
+	data, _ := l.GetRevocations(a)
+	srl, _ := utils.ParseSignedRevocationList(data)
+	assert.Nil(t, srl.Signatures.Verify(rootPub, utils.RevocationPayloadType, payload), "Fail to verify the list by the root key")
+	pubBytes, _ := l.GetPublicKey(a)
+	assert.Nil(t, srl.Verify(pubBytes), "Fail to keep the signature of the current key")
+}
@@ -0,0 +1,68 @@
+package keymanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// ImportRoleSignatures adds the signatures of an envelope made offline by a
+// root key over the canonical json of the current role of an appliance.
+// The root key never touches the server, only its public key is used to verify.
+func ImportRoleSignatures(km KeyManager, a utils.Appliance, data []byte, rootPub []byte) error {
+	imported, err := utils.ParseSignatureEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	role, err := km.GetRole(a)
+	if err != nil {
+		return err
+	}
+	payload, err := utils.CanonicalJSON(role)
+	if err != nil {
+		return err
+	}
+	if err := imported.Verify(rootPub, utils.RolePayloadType, payload); err != nil {
+		return fmt.Errorf("Fail to verify the role signature of the root key: %v", err)
+	}
+
+	env := utils.NewSignatureEnvelope(utils.RolePayloadType)
+	if old, err := km.GetRoleSign(a); err == nil {
+		if env, err = utils.ParseSignatureEnvelope(old); err != nil {
+			return err
+		}
+	} else if err != storage.ErrorsNotFound {
+		return err
+	}
+	env.Merge(imported)
+
+	content, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return km.SetRoleSign(a, content)
+}
+
+// UpdateRole replaces the role of an appliance, its version is bumped over the
+// current one so clients refuse the older roles, and it expires after 'ttl' if
+// 'ttl' is not 0. The new role should be signed by the root key again.
+func UpdateRole(km KeyManager, a utils.Appliance, role utils.Role, ttl time.Duration) error {
+	current, err := km.GetRole(a)
+	if err != nil {
+		return err
+	}
+
+	role.Version = current.Version + 1
+	role.Expires = nil
+	if ttl > 0 {
+		expires := time.Now().UTC().Add(ttl).Truncate(time.Second)
+		role.Expires = &expires
+	}
+
+	return km.SetRole(a, role)
+}
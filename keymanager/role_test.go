@@ -0,0 +1,70 @@
+package keymanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestImportRoleSignatures(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	rootPriv, rootPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	otherPriv, _, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+
+	role, _ := l.GetRole(a)
+	payload, _ := utils.CanonicalJSON(role)
+	sign := func(priv []byte, payloadType string, payload []byte) []byte {
+		pub, _ := utils.GetPublicKeyFromPrivate(priv)
+		env := utils.NewSignatureEnvelope(payloadType)
+		sig, _ := utils.SHA256Sign(priv, env.PAE(payload))
+		env.AddSignature(pub, sig)
+		data, _ := json.Marshal(env)
+		return data
+	}
+
+	err = ImportRoleSignatures(l, a, sign(otherPriv, utils.RolePayloadType, payload), rootPub)
+	assert.NotNil(t, err, "Should not import signatures of another key")
+	err = ImportRoleSignatures(l, a, sign(rootPriv, utils.RolePayloadType, []byte("other payload")), rootPub)
+	assert.NotNil(t, err, "Should not import signatures over another payload")
+	err = ImportRoleSignatures(l, a, sign(rootPriv, utils.DefaultPayloadType, payload), rootPub)
+	assert.NotNil(t, err, "Should not import signatures of another payload type")
+
+	err = ImportRoleSignatures(l, a, sign(rootPriv, utils.RolePayloadType, payload), rootPub)
+	assert.Nil(t, err, "Fail to import the root signature")
+	data, err := l.GetRoleSign(a)
+	assert.Nil(t, err, "Fail to get the role signatures")
+	env, _ := utils.ParseSignatureEnvelope(data)
+	assert.Nil(t, env.Verify(rootPub, utils.RolePayloadType, payload), "Fail to verify the role by the root key")
+}
+
+func TestUpdateRole(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	l, _ := NewKeyManager("peruser", tmpPath)
+	a := utils.Appliance{Proto: "app", Version: "v1", Namespace: "containerops"}
+	role, _ := l.GetRole(a)
+	assert.Equal(t, 0, role.Version, "The default role has no version")
+
+	assert.Nil(t, UpdateRole(l, a, role, time.Hour))
+	role, _ = l.GetRole(a)
+	assert.Equal(t, 1, role.Version)
+	assert.True(t, role.Expires != nil && !role.IsExpired(time.Now()))
+
+	assert.Nil(t, UpdateRole(l, a, role, 0))
+	role, _ = l.GetRole(a)
+	assert.Equal(t, 2, role.Version, "The version should grow with every change")
+	assert.Nil(t, role.Expires)
+}
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// App is the 'app/v1' protocol of application files
+type App struct{}
+
+func init() {
+	RegisterProtocol(&App{})
+}
+
+// Proto is 'app'
+func (app *App) Proto() string {
+	return "app"
+}
+
+// Version is 'v1'
+func (app *App) Version() string {
+	return "v1"
+}
+
+// ParseFullName parses an 'os-arch-name[:tag]' full name, the OS and the architecture should be known
+func (app *App) ParseFullName(fullname string) (utils.Appliance, error) {
+	return parseFullName(app, fullname)
+}
+
+// NewItem creates the meta data of an application file
+func (app *App) NewItem(fullname string, content []byte, mediaType string, annotations map[string]string) (service.UpdateServiceItem, error) {
+	if _, err := app.ParseFullName(fullname); err != nil {
+		return service.UpdateServiceItem{}, err
+	}
+
+	return service.NewUpdateServiceItemFromContent(fullname, content, mediaType, annotations)
+}
+
+// Routes adds no routes, application files are served by the shared routes
+func (app *App) Routes(r *macaron.Router) {
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	// AnnotationImageFormat is the layout of an image archive: 'oci' or 'docker'
+	AnnotationImageFormat = "image-format"
+)
+
+// ErrorsNotImageArchive occurs when an uploaded image is not an OCI layout or 'docker save' archive
+var ErrorsNotImageArchive = errors.New("not an OCI layout or 'docker save' archive")
+
+// Image is the 'image/v1' protocol of container image archives
+type Image struct{}
+
+func init() {
+	RegisterProtocol(&Image{})
+}
+
+// Proto is 'image'
+func (image *Image) Proto() string {
+	return "image"
+}
+
+// Version is 'v1'
+func (image *Image) Version() string {
+	return "v1"
+}
+
+// ParseFullName parses a 'name[:tag]' full name
+func (image *Image) ParseFullName(fullname string) (utils.Appliance, error) {
+	return parseFullName(image, fullname)
+}
+
+// NewItem creates the meta data of an image archive, which could be gzipped
+func (image *Image) NewItem(fullname string, content []byte, mediaType string, annotations map[string]string) (service.UpdateServiceItem, error) {
+	if _, err := image.ParseFullName(fullname); err != nil {
+		return service.UpdateServiceItem{}, err
+	}
+
+	format, err := ImageFormat(content)
+	if err != nil {
+		return service.UpdateServiceItem{}, err
+	}
+	values := make(map[string]string)
+	for k, v := range annotations {
+		values[k] = v
+	}
+	values[AnnotationImageFormat] = format
+
+	return service.NewUpdateServiceItemFromContent(fullname, content, mediaType, values)
+}
+
+// Routes adds no routes, image archive files are served by the shared routes
+func (image *Image) Routes(r *macaron.Router) {
+}
+
+// ImageFormat tells the layout of an image archive by its 'oci-layout' or 'manifest.json' file
+func ImageFormat(content []byte) (string, error) {
+	var r io.Reader = bytes.NewReader(content)
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	format := ""
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", ErrorsNotImageArchive
+		}
+		switch path.Clean(hdr.Name) {
+		case "oci-layout":
+			return "oci", nil
+		case "manifest.json":
+			format = "docker"
+		}
+	}
+	if format == "" {
+		return "", ErrorsNotImageArchive
+	}
+
+	return format, nil
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// Protocol defines the naming rules and the meta data of the files of a kind of
+// appliance, its repositories are served and stored under '<proto>/<version>'
+type Protocol interface {
+	// Proto and Version are the first parts of the routes and storage keys, like 'app' and 'v1'
+	Proto() string
+	Version() string
+	// ParseFullName parses the full name of a file to an appliance, invalid names fail it
+	ParseFullName(fullname string) (utils.Appliance, error)
+	// NewItem creates the meta data of an uploaded file, the meta data of the
+	// protocol, for example the format of a disk image, is kept in annotations
+	NewItem(fullname string, content []byte, mediaType string, annotations map[string]string) (service.UpdateServiceItem, error)
+	// Routes adds the routes only served for the protocol to its group '/<proto>/<version>',
+	// after the routes of the namespaces and repositories shared by every protocol
+	Routes(r *macaron.Router)
+}
+
+var (
+	protocolsLock sync.Mutex
+	protocols     = make(map[string]Protocol)
+
+	// ErrorsProtocolNotSupported occurs when a protocol is not registered
+	ErrorsProtocolNotSupported = errors.New("protocol is not supported")
+)
+
+// Name gets the registered name of a protocol, like 'appv1'
+func Name(p Protocol) string {
+	return p.Proto() + p.Version()
+}
+
+// RegisterProtocol provides a way to dynamically register an implementation of a
+// protocol, it is registered by its Name.
+func RegisterProtocol(p Protocol) error {
+	if p == nil {
+		return errors.New("Could not register a nil Protocol")
+	}
+	if p.Proto() == "" || p.Version() == "" {
+		return errors.New("Could not register a Protocol with an empty proto or version")
+	}
+
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+
+	if _, alreadyExists := protocols[Name(p)]; alreadyExists {
+		return fmt.Errorf("Protocol '%s' is already registered", Name(p))
+	}
+	protocols[Name(p)] = p
+
+	return nil
+}
+
+// ListProtocols lists the registered protocols sorted by their names
+func ListProtocols() []Protocol {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+
+	var ret []Protocol
+	for _, p := range protocols {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return Name(ret[i]) < Name(ret[j])
+	})
+
+	return ret
+}
+
+// GetProtocol gets a protocol by its name, both 'appv1' and 'app/v1' are accepted
+func GetProtocol(name string) (Protocol, error) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+
+	if p, ok := protocols[strings.Replace(name, "/", "", 1)]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%v: %s", ErrorsProtocolNotSupported, name)
+}
+
+// parseFullName parses a full name by utils.ParseAppliance
+func parseFullName(p Protocol, fullname string) (utils.Appliance, error) {
+	a, err := utils.ParseAppliance(p.Proto(), fullname)
+	if err != nil {
+		return utils.Appliance{}, err
+	}
+	a.Version = p.Version()
+
+	return a, nil
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/macaron.v1"
+)
+
+func TestRegisterProtocol(t *testing.T) {
+	assert.NotNil(t, RegisterProtocol(nil), "Should not register a nil protocol")
+	assert.NotNil(t, RegisterProtocol(&App{}), "Should not register a protocol twice")
+
+	var names []string
+	for _, p := range ListProtocols() {
+		names = append(names, Name(p))
+	}
+	assert.Equal(t, []string{"appv1", "imagev1", "vmv1"}, names)
+
+	for _, name := range []string{"vmv1", "vm/v1"} {
+		p, err := GetProtocol(name)
+		assert.Nil(t, err, "Fail to get a protocol")
+		assert.Equal(t, "vm", p.Proto())
+	}
+	_, err := GetProtocol("docker/v2")
+	assert.NotNil(t, err, "Should not get an unregistered protocol")
+}
+
+// chart is a protocol of its own route
+type chart struct {
+	App
+}
+
+func (c *chart) Proto() string {
+	return "chart"
+}
+
+func (c *chart) Routes(r *macaron.Router) {
+	r.Get("/:namespace/:repository/index.yaml", func() string { return "index" })
+}
+
+func TestRoutes(t *testing.T) {
+	m := macaron.New()
+	for _, p := range []Protocol{&App{}, &chart{}} {
+		m.Group("/"+p.Proto()+"/"+p.Version(), func() {
+			p.Routes(m.Router)
+		})
+	}
+
+	for path, code := range map[string]int{"/chart/v1/ns/repo/index.yaml": http.StatusOK, "/app/v1/ns/repo/index.yaml": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, code, w.Code, "Routes should be only served for the protocol")
+	}
+}
+
+func TestParseFullName(t *testing.T) {
+	a, err := (&App{}).ParseFullName("linux-arm64-name:1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", a.Version)
+	assert.Equal(t, "arm64", a.Arch)
+	_, err = (&App{}).ParseFullName("name:1.0.0")
+	assert.NotNil(t, err, "An app should be named with its platform")
+
+	a, err = (&VM{}).ParseFullName("name:1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "vm", a.Proto)
+	assert.Equal(t, "name", a.Name)
+}
+
+func TestVMItem(t *testing.T) {
+	qcow2 := make([]byte, 64)
+	copy(qcow2, "QFI\xfb")
+	binary.BigEndian.PutUint64(qcow2[24:32], 10<<30)
+	format, size := DiskInfo(qcow2)
+	assert.Equal(t, "qcow2", format)
+	assert.Equal(t, int64(10<<30), size)
+
+	vm := &VM{}
+	item, err := vm.NewItem("disk:1.0", qcow2, "", map[string]string{AnnotationDiskFormat: "vmdk", "os": "linux"})
+	assert.Nil(t, err)
+	assert.Equal(t, "qcow2", item.GetAnnotation(AnnotationDiskFormat), "The detected format should override the uploaded one")
+	assert.Equal(t, "10737418240", item.GetAnnotation(AnnotationDiskSize))
+	assert.Equal(t, "linux", item.GetAnnotation("os"))
+
+	format, size = DiskInfo([]byte("raw disk"))
+	assert.Equal(t, "raw", format)
+	assert.Equal(t, int64(8), size)
+}
+
+func TestImageItem(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	tw.WriteHeader(&tar.Header{Name: "oci-layout", Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+
+	image := &Image{}
+	item, err := image.NewItem("busybox:1.36", buf.Bytes(), "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "oci", item.GetAnnotation(AnnotationImageFormat))
+
+	_, err = image.NewItem("busybox:1.36", []byte("not a tar"), "", nil)
+	assert.NotNil(t, err, "Should not accept a file which is not an image archive")
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/liangchenye/update-service/service"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	// AnnotationDiskFormat is the format of a disk image: qcow2, vmdk, vhdx, vhd, vdi, iso or raw
+	AnnotationDiskFormat = "disk-format"
+	// AnnotationDiskSize is the virtual size of a disk image in bytes, if it is known
+	AnnotationDiskSize = "disk-size"
+
+	sectorSize = 512
+)
+
+// VM is the 'vm/v1' protocol of virtual machine disk images
+type VM struct{}
+
+func init() {
+	RegisterProtocol(&VM{})
+}
+
+// Proto is 'vm'
+func (vm *VM) Proto() string {
+	return "vm"
+}
+
+// Version is 'v1'
+func (vm *VM) Version() string {
+	return "v1"
+}
+
+// ParseFullName parses a 'name[:tag]' full name
+func (vm *VM) ParseFullName(fullname string) (utils.Appliance, error) {
+	return parseFullName(vm, fullname)
+}
+
+// NewItem creates the meta data of a disk image, its format and virtual size
+// are read from the content and override the uploaded annotations
+func (vm *VM) NewItem(fullname string, content []byte, mediaType string, annotations map[string]string) (service.UpdateServiceItem, error) {
+	if _, err := vm.ParseFullName(fullname); err != nil {
+		return service.UpdateServiceItem{}, err
+	}
+
+	values := make(map[string]string)
+	for k, v := range annotations {
+		values[k] = v
+	}
+	format, size := DiskInfo(content)
+	values[AnnotationDiskFormat] = format
+	if size > 0 {
+		values[AnnotationDiskSize] = strconv.FormatInt(size, 10)
+	} else {
+		delete(values, AnnotationDiskSize)
+	}
+
+	return service.NewUpdateServiceItemFromContent(fullname, content, mediaType, values)
+}
+
+// Routes adds no routes, disk image files are served by the shared routes
+func (vm *VM) Routes(r *macaron.Router) {
+}
+
+// DiskInfo detects the format of a disk image by its magic and reads its virtual
+// size, the size is 0 if the format does not tell it in a fixed place
+func DiskInfo(content []byte) (string, int64) {
+	switch {
+	case bytes.HasPrefix(content, []byte("QFI\xfb")):
+		if len(content) >= 32 {
+			return "qcow2", int64(binary.BigEndian.Uint64(content[24:32]))
+		}
+		return "qcow2", 0
+	case bytes.HasPrefix(content, []byte("KDMV")):
+		if len(content) >= 20 {
+			return "vmdk", int64(binary.LittleEndian.Uint64(content[12:20])) * sectorSize
+		}
+		return "vmdk", 0
+	case bytes.HasPrefix(content, []byte("vhdxfile")):
+		return "vhdx", 0
+	case len(content) >= 0x48 && binary.LittleEndian.Uint32(content[0x40:0x44]) == 0xbeda107f:
+		return "vdi", 0
+	case len(content) >= sectorSize && bytes.HasPrefix(content[len(content)-sectorSize:], []byte("conectix")):
+		// the footer of a fixed vhd is the last sector
+		footer := content[len(content)-sectorSize:]
+		return "vhd", int64(binary.BigEndian.Uint64(footer[48:56]))
+	case len(content) >= 0x8006 && string(content[0x8001:0x8006]) == "CD001":
+		return "iso", int64(len(content))
+	}
+
+	return "raw", int64(len(content))
+}
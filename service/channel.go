@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultChannelsDir = "channels"
+)
+
+var channelRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// IsValidChannel tells if a release channel name is valid, for example "stable"
+func IsValidChannel(channel string) bool {
+	return channelRegexp.MatchString(channel)
+}
+
+// ListChannels lists the release channels of a repository, a channel is kept
+// after its last file leaves it, so its clients get an empty meta data.
+func (us *UpdateService) ListChannels() ([]string, error) {
+	channels := make(map[string]bool)
+	for _, item := range us.Items {
+		for _, c := range item.Channels {
+			channels[c] = true
+		}
+	}
+
+	names, err := us.GetStorage().List(us.channelKey(""))
+	if err != nil && err != storage.ErrorsNotFound {
+		return nil, err
+	}
+	for _, c := range names {
+		if IsValidChannel(c) {
+			channels[c] = true
+		}
+	}
+
+	list := []string{}
+	for c := range channels {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// GetChannelMeta provides the meta bytes of a release channel, which only lists
+// the files in the channel
+func (us *UpdateService) GetChannelMeta(channel string) ([]byte, error) {
+	if !IsValidChannel(channel) {
+		return nil, fmt.Errorf("Invalid channel name: '%s'", channel)
+	}
+
+	return us.GetStorage().Get(us.channelKey(channel + "/" + defaultMetaFileName))
+}
+
+// GetChannelMetaSign provides the meta sign bytes of a release channel in the
+// format of the 'meta-sign-format' setting
+func (us *UpdateService) GetChannelMetaSign(channel string) ([]byte, error) {
+	if !IsValidChannel(channel) {
+		return nil, fmt.Errorf("Invalid channel name: '%s'", channel)
+	}
+
+	data, err := us.GetStorage().Get(us.channelKey(channel + "/" + defaultMetaSignFileName))
+	if err != nil {
+		return nil, err
+	}
+	env, err := utils.ParseSignatureEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := us.GetChannelMeta(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return us.formatMetaSign(env, payload)
+}
+
+// Promote moves a file from a release channel to another one without uploading
+// it again, it is only added to 'to' if 'from' is empty.
+func (us *UpdateService) Promote(fullname, from, to string) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	item, err := us.GetItem(fullname)
+	if err != nil {
+		return err
+	}
+	if from != "" {
+		if !item.InChannel(from) {
+			return fmt.Errorf("%s is not in channel '%s'", fullname, from)
+		}
+		item.RemoveChannel(from)
+	}
+	if err := item.AddChannel(to); err != nil {
+		return err
+	}
+
+	return us.put(item, false)
+}
+
+// ImportChannelSignatures adds the signatures of an envelope made offline to the
+// meta sign of a release channel. Every signature should be made over the current
+// meta data of the channel by a key of the role.
+func (us *UpdateService) ImportChannelSignatures(channel string, data []byte) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	payload, err := us.GetChannelMeta(channel)
+	if err != nil {
+		return err
+	}
+	key := us.channelKey(channel + "/" + defaultMetaSignFileName)
+	signBytes, err := us.GetStorage().Get(key)
+	if err != nil {
+		return err
+	}
+	env, err := utils.ParseSignatureEnvelope(signBytes)
+	if err != nil {
+		return err
+	}
+
+	return us.importSignaturesTo(key, payload, env, data)
+}
+
+// saveChannels saves and signs the meta data of every release channel
+func (us *UpdateService) saveChannels() error {
+	channels, err := us.ListChannels()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range channels {
+		view := UpdateService{Proto: us.Proto, Version: us.Version, Namespace: us.Namespace, Repository: us.Repository,
+			Items: us.channelItems(c), Updated: us.Updated, Channel: c}
+
+		content, err := utils.CanonicalJSON(view)
+		if err != nil {
+			return err
+		}
+		if _, err := us.GetStorage().Put(us.channelKey(c+"/"+defaultMetaFileName), content); err != nil {
+			return err
+		}
+		if err := us.saveSignTo(us.channelKey(c+"/"+defaultMetaSignFileName), utils.ChannelMetaPayloadType, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// channelItems gets the items of a release channel, hidden files are not in it
+func (us *UpdateService) channelItems(channel string) []UpdateServiceItem {
+	items := []UpdateServiceItem{}
+	for _, item := range us.Items {
+		if item.InChannel(channel) && !item.Hidden {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (us *UpdateService) channelKey(name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultChannelsDir, name)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestChannels(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	pubBytes, _ := us.getPublicKey()
+
+	item, _ := NewUpdateServiceItem("app:1.0.0", []string{"sha0"})
+	assert.Nil(t, item.AddChannel("stable"))
+	assert.NotNil(t, item.AddChannel("Bad Channel"), "Should not add an invalid channel")
+	assert.Nil(t, us.Put(item), "Fail to put an item")
+	item, _ = NewUpdateServiceItem("app:2.0.0", []string{"sha1"})
+	item.AddChannel("beta")
+	assert.Nil(t, us.Put(item), "Fail to put an item")
+
+	channels, err := us.ListChannels()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"beta", "stable"}, channels)
+
+	loadChannel := func(channel string) UpdateService {
+		meta, err := us.GetChannelMeta(channel)
+		assert.Nil(t, err, "Fail to get the channel meta")
+		metaSign, err := us.GetChannelMetaSign(channel)
+		assert.Nil(t, err, "Fail to get the channel meta sign")
+		env, _ := utils.ParseSignatureEnvelope(metaSign)
+		assert.Nil(t, env.Verify(pubBytes, utils.ChannelMetaPayloadType, meta), "Channel meta should be signed by the repository key")
+
+		var view UpdateService
+		assert.Nil(t, json.Unmarshal(meta, &view))
+		assert.Equal(t, channel, view.Channel)
+		return view
+	}
+	stable := loadChannel("stable")
+	assert.Equal(t, 1, len(stable.Items), "Channel meta should only list its items")
+	assert.Equal(t, "app:1.0.0", stable.Items[0].FullName)
+
+	resolved, err := us.Resolve("app", "latest", "stable")
+	assert.Nil(t, err)
+	assert.Equal(t, "app:1.0.0", resolved.FullName, "Fail to resolve in a channel")
+
+	assert.NotNil(t, us.Promote("app:2.0.0", "nightly", "stable"), "Should not promote from a channel without the item")
+	assert.Nil(t, us.Promote("app:2.0.0", "beta", "stable"), "Fail to promote")
+	assert.Equal(t, 2, len(loadChannel("stable").Items), "Promoted item should be in the target channel")
+	assert.Equal(t, 0, len(loadChannel("beta").Items), "Promoted item should leave the source channel")
+
+	resolved, _ = us.Resolve("app", "latest", "stable")
+	assert.Equal(t, "app:2.0.0", resolved.FullName, "Fail to resolve the promoted item")
+
+	// uploading a file again keeps its channels unless they are replaced
+	again, _ := NewUpdateServiceItem("app:2.0.0", []string{"sha1"})
+	assert.Nil(t, us.Put(again))
+	assert.Equal(t, 2, len(loadChannel("stable").Items), "Uploading again should keep the channels")
+	assert.Nil(t, us.Replace(again))
+	assert.Equal(t, 1, len(loadChannel("stable").Items), "Fail to clear the channels")
+
+	// hidden files are not in the channels
+	hidden, _ := us.GetItem("app:1.0.0")
+	hidden.Hidden = true
+	assert.Nil(t, us.Put(hidden))
+	assert.Equal(t, 0, len(loadChannel("stable").Items), "Hidden files should not be in a channel")
+
+	// the meta data of a channel could be co-signed offline
+	otherPriv, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	role, _ := us.GetRole()
+	role.AddKey(otherPub)
+	role.Threshold = 2
+	us.GetKM().SetRole(us.appliance(), role)
+	meta, _ := us.GetChannelMeta("stable")
+	env := utils.NewSignatureEnvelope(utils.DefaultPayloadType)
+	sig, _ := utils.SHA256Sign(otherPriv, env.PAE(meta))
+	env.AddSignature(otherPub, sig)
+	data, _ := json.Marshal(env)
+	assert.NotNil(t, us.ImportChannelSignatures("stable", data), "Should refuse signatures of the meta data payload type")
+	env = utils.NewSignatureEnvelope(utils.ChannelMetaPayloadType)
+	sig, _ = utils.SHA256Sign(otherPriv, env.PAE(meta))
+	env.AddSignature(otherPub, sig)
+	data, _ = json.Marshal(env)
+	assert.Nil(t, us.ImportChannelSignatures("stable", data), "Fail to import the channel signatures")
+	metaSign, _ := us.GetChannelMetaSign("stable")
+	env, _ = utils.ParseSignatureEnvelope(metaSign)
+	assert.Nil(t, role.VerifyThreshold(utils.ChannelMetaPayloadType, meta, env), "Channel meta should be verified by the threshold of the role")
+	assert.NotNil(t, us.ImportChannelSignatures("beta", data), "Should refuse signatures over another channel")
+}
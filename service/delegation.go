@@ -0,0 +1,251 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultDelegationsFileName = "delegations.json"
+	defaultDelegatedDir        = "delegations"
+)
+
+// GetDelegations provides the signed delegation list bytes
+func (us *UpdateService) GetDelegations() ([]byte, error) {
+	return us.GetStorage().Get(us.delegationsKey())
+}
+
+// GetDelegationList gets the delegation list, it is empty if nothing is delegated
+func (us *UpdateService) GetDelegationList() (utils.Delegations, error) {
+	data, err := us.GetDelegations()
+	if err == storage.ErrorsNotFound {
+		return utils.Delegations{}, nil
+	} else if err != nil {
+		return utils.Delegations{}, err
+	}
+
+	sd, err := utils.ParseSignedDelegations(data)
+	if err != nil {
+		return utils.Delegations{}, err
+	}
+
+	return sd.Signed, nil
+}
+
+// Delegate adds a delegation, or replaces the one with the same name, and signs
+// the delegation list by the key of the repository.
+// A replaced delegation keeps its delegated targets only if they still verify.
+func (us *UpdateService) Delegate(d utils.Delegation) error {
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		return err
+	}
+	if err := ds.Set(d); err != nil {
+		return err
+	}
+	if err := us.saveDelegations(ds); err != nil {
+		return err
+	}
+
+	data, err := us.GetDelegatedTargets(d.Name)
+	if err == storage.ErrorsNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if st, err := utils.ParseSignedDelegatedTargets(data); err != nil || st.Verify(d) != nil {
+		return us.GetStorage().Delete(us.delegatedKey(d.Name))
+	}
+
+	return nil
+}
+
+// RemoveDelegation removes a delegation and its delegated targets
+func (us *UpdateService) RemoveDelegation(name string) error {
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		return err
+	}
+	if err := ds.Remove(name); err != nil {
+		return err
+	}
+	if err := us.saveDelegations(ds); err != nil {
+		return err
+	}
+
+	if _, err := us.GetDelegatedTargets(name); err == nil {
+		return us.GetStorage().Delete(us.delegatedKey(name))
+	}
+
+	return nil
+}
+
+// GetDelegatedTargets provides the signed delegated target list bytes of a delegation
+func (us *UpdateService) GetDelegatedTargets(name string) ([]byte, error) {
+	return us.GetStorage().Get(us.delegatedKey(name))
+}
+
+// ExportDelegatedTargets exports the current items of a delegation as a
+// delegated target list in canonical json, to be signed by 'uc sign' with the keys of the delegation
+func (us *UpdateService) ExportDelegatedTargets(name string) ([]byte, error) {
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := ds.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("Cannot find the delegation: %s", name)
+	}
+
+	dt := utils.DelegatedTargets{Name: name, Version: 1, Targets: make(map[string][]string)}
+	if data, err := us.GetDelegatedTargets(name); err == nil {
+		if st, err := utils.ParseSignedDelegatedTargets(data); err == nil {
+			dt.Version = st.Signed.Version + 1
+		}
+	}
+	for _, item := range us.Items {
+		// an item is signed by the first delegation matching it only
+		if found, ok := ds.Find(item.FullName); ok && found.Name == d.Name {
+			dt.Targets[item.FullName] = item.SHAS
+		}
+	}
+
+	return utils.CanonicalJSON(dt)
+}
+
+// ImportDelegatedTargets stores a delegated target list with the signatures
+// made offline by the keys of its delegation
+func (us *UpdateService) ImportDelegatedTargets(payload []byte, sigs []byte) error {
+	var dt utils.DelegatedTargets
+	if err := json.Unmarshal(payload, &dt); err != nil {
+		return err
+	}
+	env, err := utils.ParseSignatureEnvelope(sigs)
+	if err != nil {
+		return err
+	}
+
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		return err
+	}
+	d, ok := ds.Get(dt.Name)
+	if !ok {
+		return fmt.Errorf("Cannot find the delegation: %s", dt.Name)
+	}
+
+	st := utils.SignedDelegatedTargets{Signed: dt, Signatures: env}
+	if err := st.Verify(d); err != nil {
+		return err
+	}
+	if data, err := us.GetDelegatedTargets(dt.Name); err == nil {
+		if old, err := utils.ParseSignedDelegatedTargets(data); err == nil && dt.Version <= old.Signed.Version {
+			return fmt.Errorf("Delegated targets version %d should be newer than the current version %d", dt.Version, old.Signed.Version)
+		}
+	}
+
+	content, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetStorage().Put(us.delegatedKey(dt.Name), content)
+	return err
+}
+
+// ExportDelegations exports the delegation list in canonical json, to be signed
+// by 'uc sign' with the other keys of the role
+func (us *UpdateService) ExportDelegations() ([]byte, error) {
+	ds, err := us.GetDelegationList()
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.CanonicalJSON(ds)
+}
+
+// ImportDelegationsSignatures adds the signatures of an envelope made offline to
+// the delegation list. Every signature should be made over the current list by a key of the role.
+func (us *UpdateService) ImportDelegationsSignatures(data []byte) error {
+	imported, err := utils.ParseSignatureEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	content, err := us.GetDelegations()
+	if err != nil {
+		return err
+	}
+	sd, err := utils.ParseSignedDelegations(content)
+	if err != nil {
+		return err
+	}
+	payload, err := utils.CanonicalJSON(sd.Signed)
+	if err != nil {
+		return err
+	}
+	role, err := us.GetRole()
+	if err != nil {
+		return err
+	}
+	for _, s := range imported.Signatures {
+		pubKey, ok := role.Keys[s.KeyID]
+		if !ok {
+			return fmt.Errorf("Key %s is not trusted to sign the delegations", s.KeyID)
+		}
+		if err := imported.Verify([]byte(pubKey), utils.DelegationsPayloadType, payload); err != nil {
+			return fmt.Errorf("Fail to verify the signature of key %s: %v", s.KeyID, err)
+		}
+	}
+
+	sd.Signatures.Merge(imported)
+	if content, err = json.Marshal(sd); err != nil {
+		return err
+	}
+	_, err = us.GetStorage().Put(us.delegationsKey(), content)
+	return err
+}
+
+// saveDelegations signs the delegation list by the key of the repository and saves it
+func (us *UpdateService) saveDelegations(ds utils.Delegations) error {
+	km := us.GetKM()
+	if km == nil {
+		return keymanager.ErrorsKMNotSupported
+	}
+
+	payload, err := utils.CanonicalJSON(ds)
+	if err != nil {
+		return err
+	}
+	a := us.appliance()
+	sd := utils.SignedDelegations{Signed: ds, Signatures: utils.NewSignatureEnvelope(utils.DelegationsPayloadType)}
+	sig, err := km.Sign(a, sd.Signatures.PAE(payload))
+	if err != nil {
+		return err
+	}
+	pubBytes, err := km.GetPublicKey(a)
+	if err != nil {
+		return err
+	}
+	if err := sd.Signatures.AddSignature(pubBytes, sig); err != nil {
+		return err
+	}
+	content, err := json.Marshal(sd)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.GetStorage().Put(us.delegationsKey(), content)
+	return err
+}
+
+func (us *UpdateService) delegationsKey() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultDelegationsFileName)
+}
+
+func (us *UpdateService) delegatedKey(name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s.json", us.Proto, us.Version, us.Namespace, us.Repository, defaultDelegatedDir, name)
+}
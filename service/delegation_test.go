@@ -0,0 +1,74 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/liangchenye/update-service/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateServiceDelegations(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	for _, fn := range []string{"linux/app", "windows/app"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		us.Put(item)
+	}
+
+	teamPriv, teamPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	d, _ := utils.NewDelegation("linux", []string{"linux/*"}, 1, teamPub)
+	assert.Nil(t, us.Delegate(d), "Fail to delegate")
+
+	data, err := us.GetDelegations()
+	assert.Nil(t, err, "Fail to get the delegations")
+	sd, err := utils.ParseSignedDelegations(data)
+	assert.Nil(t, err)
+	pubBytes, _ := us.getPublicKey()
+	assert.Nil(t, sd.Verify(pubBytes), "Delegations should be signed by the repository key")
+
+	payload, err := us.ExportDelegatedTargets("linux")
+	assert.Nil(t, err, "Fail to export the delegated targets")
+	var dt utils.DelegatedTargets
+	json.Unmarshal(payload, &dt)
+	assert.Equal(t, map[string][]string{"linux/app": {"sha-linux/app"}}, dt.Targets, "Only the delegated items should be exported")
+
+	sign := func(priv, pub []byte, payloadType string, payload []byte) []byte {
+		env := utils.NewSignatureEnvelope(payloadType)
+		sig, _ := utils.SHA256Sign(priv, env.PAE(payload))
+		env.AddSignature(pub, sig)
+		data, _ := json.Marshal(env)
+		return data
+	}
+	otherPriv, otherPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	assert.NotNil(t, us.ImportDelegatedTargets(payload, sign(otherPriv, otherPub, utils.TargetsPayloadType, payload)), "Should refuse signatures of other keys")
+	assert.Nil(t, us.ImportDelegatedTargets(payload, sign(teamPriv, teamPub, utils.TargetsPayloadType, payload)), "Fail to import the delegated targets")
+	assert.NotNil(t, us.ImportDelegatedTargets(payload, sign(teamPriv, teamPub, utils.TargetsPayloadType, payload)), "Should refuse a version not newer")
+
+	// a role of two keys requires the delegation list to be co-signed
+	role, _ := us.GetRole()
+	role.AddKey(otherPub)
+	role.Threshold = 2
+	us.GetKM().SetRole(us.appliance(), role)
+	payload, err = us.ExportDelegations()
+	assert.Nil(t, err, "Fail to export the delegations")
+	assert.NotNil(t, sd.VerifyRole(role), "The online key alone should not reach the threshold")
+	assert.NotNil(t, us.ImportDelegationsSignatures(sign(teamPriv, teamPub, utils.DelegationsPayloadType, payload)), "Should refuse keys out of the role")
+	assert.NotNil(t, us.ImportDelegationsSignatures(sign(otherPriv, otherPub, utils.TargetsPayloadType, payload)), "Should refuse signatures of another payload type")
+	assert.Nil(t, us.ImportDelegationsSignatures(sign(otherPriv, otherPub, utils.DelegationsPayloadType, payload)), "Fail to import the delegation signatures")
+	data, _ = us.GetDelegations()
+	sd, _ = utils.ParseSignedDelegations(data)
+	assert.Nil(t, sd.VerifyRole(role), "Fail to verify the co-signed delegations by the role")
+
+	_, err = us.GetDelegatedTargets("linux")
+	assert.Nil(t, err, "Fail to get the delegated targets")
+	assert.Nil(t, us.RemoveDelegation("linux"), "Fail to remove the delegation")
+	_, err = us.GetDelegatedTargets("linux")
+	assert.NotNil(t, err, "Delegated targets should be removed with the delegation")
+	assert.NotNil(t, us.RemoveDelegation("linux"), "Should not remove a delegation twice")
+}
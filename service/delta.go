@@ -0,0 +1,289 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultDeltasDir = "deltas"
+
+	// DefaultDeltaVersions is the count of the previous versions a delta is made
+	// from if the 'delta-versions' setting is not set
+	DefaultDeltaVersions = 3
+	// DefaultDeltaMaxLength is the size of the largest file deltas are made of
+	// if the 'delta-max-length' setting is not set, the previous version is
+	// loaded in memory to make a delta
+	DefaultDeltaMaxLength = 64 << 20
+
+	deltaQueueSize = 64
+)
+
+var (
+	errDeltaTooLong = errors.New("delta is not smaller than the file")
+
+	// deltas are made by one worker, deltaJobs counts the queued jobs
+	deltaQueue  = make(chan deltaJob, deltaQueueSize)
+	deltaWorker sync.Once
+	deltaJobs   sync.WaitGroup
+)
+
+type deltaJob struct {
+	us   UpdateService
+	item UpdateServiceItem
+}
+
+// Delta is a binary delta to build a file from a previous version of it, see utils.ApplyDelta
+type Delta struct {
+	// From is the full name of the previous version
+	From string
+	// FromSHA512 is the hex encoded sha512 of the previous version
+	FromSHA512 string
+	// SHA512 and Length are the hex encoded sha512 and the size of the delta
+	SHA512 string
+	Length int64
+}
+
+// GetDelta gets a delta of a file from a previous version, see UpdateServiceItem.Deltas
+func (us *UpdateService) GetDelta(fullname, from string) ([]byte, error) {
+	item, err := us.GetItem(fullname)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := item.GetDelta(from); !ok {
+		return nil, fmt.Errorf("%s has no delta from %s", fullname, from)
+	}
+
+	return us.GetStorage().Get(us.deltaKey(fullname, from))
+}
+
+// keepDeltas keeps the deltas of the existing file 'old' if the item has the
+// same content, it returns whether the deltas of the item should be made
+func keepDeltas(item *UpdateServiceItem, old UpdateServiceItem, exist bool) bool {
+	if exist && old.SameContent(*item) {
+		if item.Deltas == nil {
+			item.Deltas = old.Deltas
+		}
+		return false
+	}
+	item.Deltas = nil
+	return true
+}
+
+// queueDeltas makes the deltas of a new version of a file in the background,
+// jobs are dropped if the queue is full since the file could always be
+// downloaded as a whole
+func (us *UpdateService) queueDeltas(item UpdateServiceItem) {
+	if len(us.deltaFroms(item)) == 0 {
+		return
+	}
+
+	deltaWorker.Do(func() {
+		go func() {
+			for job := range deltaQueue {
+				job.us.buildDeltas(job.item)
+				deltaJobs.Done()
+			}
+		}()
+	})
+
+	deltaJobs.Add(1)
+	job := deltaJob{us: UpdateService{Proto: us.Proto, Version: us.Version, Namespace: us.Namespace, Repository: us.Repository,
+		storageURI: us.storageURI, kmURI: us.kmURI, kmMode: us.kmMode}, item: item}
+	select {
+	case deltaQueue <- job:
+	default:
+		deltaJobs.Done()
+	}
+}
+
+// buildDeltas makes the deltas of a new version of a file from the previous
+// versions, at most 'delta-versions' of them, and adds them to the meta data
+// if the file is not changed meanwhile. A delta is not kept unless it is
+// smaller than the file, and failures are skipped.
+func (us *UpdateService) buildDeltas(item UpdateServiceItem) {
+	if err := us.reload(); err != nil {
+		return
+	}
+
+	var deltas []Delta
+	var datas [][]byte
+	for _, old := range us.deltaFroms(item) {
+		oldContent, err := us.content(old)
+		if err != nil {
+			continue
+		}
+		r, err := us.contentReader(item)
+		if err != nil {
+			return
+		}
+		buf := &limitedBuffer{limit: int(item.Length)}
+		if err := utils.WriteDelta(buf, oldContent, r, item.Length); err != nil {
+			continue
+		}
+		fromSHA, _ := utils.SHA512(oldContent)
+		sha, _ := utils.SHA512(buf.Bytes())
+		deltas = append(deltas, Delta{From: old.FullName, FromSHA512: fromSHA, SHA512: sha, Length: int64(buf.Len())})
+		datas = append(datas, buf.Bytes())
+	}
+	if len(deltas) == 0 {
+		return
+	}
+
+	// the deltas are only stored if the file is not changed or deleted meanwhile
+	unlock, err := us.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	for i := range us.Items {
+		if us.Items[i].FullName != item.FullName || !us.Items[i].SameContent(item) {
+			continue
+		}
+		var stored []Delta
+		for j, d := range deltas {
+			if _, err := us.GetStorage().Put(us.deltaKey(item.FullName, d.From), datas[j]); err == nil {
+				stored = append(stored, d)
+			}
+		}
+		us.Items[i].Deltas = stored
+		if err := us.save(); err != nil {
+			us.Items[i].Deltas = nil
+			us.deleteDeltas(item.FullName, stored)
+		}
+		return
+	}
+}
+
+// deltaFroms selects the previous versions the deltas of a file are made from,
+// the latest first. Files longer than the 'delta-max-length' setting are skipped.
+func (us *UpdateService) deltaFroms(item UpdateServiceItem) []UpdateServiceItem {
+	count := DefaultDeltaVersions
+	if setting, err := utils.GetSetting("delta-versions"); err == nil && setting != "" {
+		count, _ = strconv.Atoi(setting)
+	}
+	maxLength := int64(DefaultDeltaMaxLength)
+	if setting, err := utils.GetSetting("delta-max-length"); err == nil && setting != "" {
+		maxLength, _ = strconv.ParseInt(setting, 10, 64)
+	}
+	name, tag := utils.SplitFullName(item.FullName)
+	version, err := utils.ParseSemVer(tag)
+	if count <= 0 || err != nil || item.Length <= 0 || item.Length > maxLength {
+		return nil
+	}
+
+	var olds []UpdateServiceItem
+	var versions []utils.SemVer
+	for _, old := range us.Items {
+		if old.Length <= 0 || old.Length > maxLength {
+			continue
+		}
+		if n, t := utils.SplitFullName(old.FullName); n == name && !old.Hidden {
+			if v, err := utils.ParseSemVer(t); err == nil && v.Compare(version) < 0 {
+				olds = append(olds, old)
+				versions = append(versions, v)
+			}
+		}
+	}
+	// the latest versions first
+	order := make([]int, len(olds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return versions[order[i]].Compare(versions[order[j]]) > 0 })
+	if len(order) > count {
+		order = order[:count]
+	}
+
+	froms := make([]UpdateServiceItem, len(order))
+	for i, o := range order {
+		froms[i] = olds[o]
+	}
+	return froms
+}
+
+// deleteDeltas deletes the delta blobs of a file
+func (us *UpdateService) deleteDeltas(fullname string, deltas []Delta) {
+	store := us.GetStorage()
+	for _, d := range deltas {
+		store.Delete(us.deltaKey(fullname, d.From))
+	}
+}
+
+// content gets the content of a file, the parts of a multi-part file are joined
+func (us *UpdateService) content(item UpdateServiceItem) ([]byte, error) {
+	r, err := us.contentReader(item)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// contentReader reads the content of a file, the parts of a multi-part file are
+// loaded one by one as they are read
+func (us *UpdateService) contentReader(item UpdateServiceItem) (io.Reader, error) {
+	if item.IsMultiPart() {
+		return &partsReader{us: us, shas: item.SHAS}, nil
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", us.Proto, us.Version, us.Namespace, us.Repository, item.FullName)
+	data, err := us.GetStorage().Get(key)
+	if err != nil {
+		return nil, err
+	}
+	// an upload moves the blob into place after the meta data is saved
+	if sha, ok := item.GetHashes()[HashSHA512]; ok {
+		if dataSHA, _ := utils.SHA512(data); dataSHA != sha {
+			return nil, fmt.Errorf("Fail to read %s, the content is not updated yet", item.FullName)
+		}
+	}
+	return bytes.NewReader(data), nil
+}
+
+type partsReader struct {
+	us   *UpdateService
+	shas []string
+	part []byte
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for len(r.part) == 0 {
+		if len(r.shas) == 0 {
+			return 0, io.EOF
+		}
+		part, err := r.us.GetPart(r.shas[0])
+		if err != nil {
+			return 0, err
+		}
+		r.part, r.shas = part, r.shas[1:]
+	}
+	n := copy(p, r.part)
+	r.part = r.part[n:]
+	return n, nil
+}
+
+// limitedBuffer refuses to grow to 'limit' bytes, a delta is not kept unless it
+// is smaller than the file
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) >= b.limit {
+		return 0, errDeltaTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+func (us *UpdateService) deltaKey(fullname, from string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultDeltasDir, fullname, from)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestDelta(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	put := func(fn string, content []byte) {
+		item, _ := NewUpdateServiceItemFromContent(fn, content, "", nil)
+		us.GetStorage().Put("p/v/n/r/blob/"+fn, content)
+		assert.Nil(t, us.Put(item), "Fail to put an item")
+		// deltas are made in the background
+		deltaJobs.Wait()
+		us.reload()
+	}
+
+	base := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(base)
+	contents := make(map[string][]byte)
+	for i, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "2.0.0"} {
+		content := append([]byte(fmt.Sprintf("version %d", i)), base...)
+		contents["app:"+v] = content
+		put("app:"+v, content)
+	}
+	put("app:stable", contents["app:1.0.0"])
+
+	item, _ := us.GetItem("app:2.0.0")
+	var froms []string
+	for _, d := range item.Deltas {
+		froms = append(froms, d.From)
+	}
+	assert.Equal(t, []string{"app:1.3.0", "app:1.2.0", "app:1.1.0"}, froms, "Deltas should be made from the last versions")
+	first, _ := us.GetItem("app:1.0.0")
+	assert.Equal(t, 0, len(first.Deltas), "The first version has no delta")
+	stable, _ := us.GetItem("app:stable")
+	assert.Equal(t, 0, len(stable.Deltas), "Only semantic versions have deltas")
+
+	d, ok := item.GetDelta("app:1.1.0")
+	assert.True(t, ok)
+	data, err := us.GetDelta("app:2.0.0", "app:1.1.0")
+	assert.Nil(t, err, "Fail to get a delta")
+	assert.Equal(t, d.Length, int64(len(data)))
+	assert.True(t, d.Length < item.Length, "A delta should be smaller than the file")
+	sha, _ := utils.SHA512(contents["app:1.1.0"])
+	assert.Equal(t, sha, d.FromSHA512)
+	content, err := utils.ApplyDelta(contents["app:1.1.0"], data, item.Length)
+	assert.Nil(t, err, "Fail to apply a delta")
+	assert.Nil(t, item.VerifyContent(content), "Fail to build the new version by a delta")
+	_, err = us.GetDelta("app:2.0.0", "app:1.0.0")
+	assert.NotNil(t, err, "Should not get a delta which is not made")
+
+	// putting the same content again, for example promoting it, keeps the deltas
+	again, _ := NewUpdateServiceItemFromContent("app:2.0.0", contents["app:2.0.0"], "", nil)
+	assert.Nil(t, us.Put(again))
+	item, _ = us.GetItem("app:2.0.0")
+	assert.Equal(t, 3, len(item.Deltas))
+
+	// new content drops the deltas of the old one
+	put("app:1.3.0", contents["app:1.3.0"][1:])
+	item, _ = us.GetItem("app:1.3.0")
+	assert.Equal(t, 3, len(item.Deltas))
+	put("app:1.3.0", contents["app:1.3.0"])
+	item, _ = us.GetItem("app:1.3.0")
+	assert.Equal(t, 3, len(item.Deltas), "Fail to remake the deltas of new content")
+
+	utils.SetSetting("delta-max-length", "1024")
+	put("app:2.1.0", append([]byte("version 6"), base...))
+	utils.SetSetting("delta-max-length", "")
+	item, _ = us.GetItem("app:2.1.0")
+	assert.Equal(t, 0, len(item.Deltas), "Deltas should not be made of large files")
+
+	// a transaction makes the deltas of new content after publishing it, and keeps the others
+	v := append([]byte("version 7"), base...)
+	staged, _ := NewUpdateServiceItemFromContent("app:2.2.0", v, "", nil)
+	for i := 0; i < 2; i++ {
+		id, _ := us.Begin()
+		assert.Nil(t, us.Stage(id, staged, v))
+		assert.Nil(t, us.Commit(id), "Fail to commit a transaction")
+		if i == 0 {
+			deltaJobs.Wait()
+			us.reload()
+			item, _ = us.GetItem("app:2.2.0")
+			assert.Equal(t, 3, len(item.Deltas))
+		}
+	}
+	again, _ = us.GetItem("app:2.2.0")
+	assert.Equal(t, item.Deltas, again.Deltas, "Fail to keep the deltas of the same content")
+
+	utils.SetSetting("delta-versions", "0")
+	defer utils.SetSetting("delta-versions", "")
+	put("app:3.0.0", append([]byte("version 5"), base...))
+	item, _ = us.GetItem("app:3.0.0")
+	assert.Equal(t, 0, len(item.Deltas), "Deltas could be disabled")
+
+	reloaded, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	item, _ = reloaded.GetItem("app:2.0.0")
+	assert.Equal(t, d, item.Deltas[2], "Deltas should be in the signed meta data")
+
+	// deltas of a file changed meanwhile are dropped
+	changed := item
+	changed.SHAS = []string{"changed"}
+	us.GetStorage().Put("p/v/n/r/blob/app:2.0.0", contents["app:1.0.0"])
+	us.buildDeltas(changed)
+	us.GetStorage().Put("p/v/n/r/blob/app:2.0.0", contents["app:2.0.0"])
+	data, _ = us.GetDelta("app:2.0.0", "app:1.1.0")
+	content, err = utils.ApplyDelta(contents["app:1.1.0"], data, item.Length)
+	assert.Nil(t, err)
+	assert.Nil(t, item.VerifyContent(content), "Should keep the deltas of the current content")
+
+	us.deleteData([]UpdateServiceItem{item})
+	_, err = us.GetStorage().Get(us.deltaKey("app:2.0.0", "app:1.1.0"))
+	assert.NotNil(t, err, "Fail to delete the deltas of a deleted item")
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	// SignPolicyStrict fails a change of the meta data which could not be signed,
+	// meta.json is rolled back. It is the default policy.
+	SignPolicyStrict = "strict"
+	// SignPolicyLenient keeps a change of the meta data which could not be signed,
+	// the failure is recorded as a health warning.
+	SignPolicyLenient = "lenient"
+
+	// HealthStatusOK means every repository verifies
+	HealthStatusOK = "ok"
+	// HealthStatusWarning means some repositories do not verify or could not be signed
+	HealthStatusWarning = "warning"
+)
+
+var (
+	// signWarnings records the signing failures kept by the lenient policy,
+	// keyed by 'proto/version/namespace/repository'
+	signWarningsLock sync.Mutex
+	signWarnings     = make(map[string]SignWarning)
+)
+
+// SignWarning is a signing failure of the meta data of a repository
+type SignWarning struct {
+	Repository string
+	Error      string
+	Time       time.Time
+}
+
+// RepositoryHealth reports a repository whose meta data does not verify
+type RepositoryHealth struct {
+	Repository string
+	Error      string
+}
+
+// ExpiredItem reports an expired file of a repository whose lifecycle policy only warns
+type ExpiredItem struct {
+	Repository string
+	FullName   string
+	Expired    time.Time
+}
+
+// Health reports the repositories whose meta.sign does not verify against the
+// current public key, the recorded signing failures and the expired files
+type Health struct {
+	Status     string
+	Unverified []RepositoryHealth `json:",omitempty"`
+	Warnings   []SignWarning      `json:",omitempty"`
+	Expired    []ExpiredItem      `json:",omitempty"`
+}
+
+// IsSignPolicySupported checks if a sign policy is supported
+func IsSignPolicySupported(policy string) bool {
+	return policy == SignPolicyStrict || policy == SignPolicyLenient
+}
+
+// SignPolicy gets the sign policy from the 'sign-policy' setting, strict by default
+func SignPolicy() string {
+	if policy, _ := utils.GetSetting("sign-policy"); policy == SignPolicyLenient {
+		return SignPolicyLenient
+	}
+
+	return SignPolicyStrict
+}
+
+func applianceName(a utils.Appliance) string {
+	return fmt.Sprintf("%s/%s/%s/%s", a.Proto, a.Version, a.Namespace, a.Repository)
+}
+
+func recordSignWarning(a utils.Appliance, err error) {
+	signWarningsLock.Lock()
+	defer signWarningsLock.Unlock()
+
+	name := applianceName(a)
+	signWarnings[name] = SignWarning{Repository: name, Error: err.Error(), Time: time.Now().UTC()}
+}
+
+func clearSignWarning(a utils.Appliance) {
+	signWarningsLock.Lock()
+	defer signWarningsLock.Unlock()
+
+	delete(signWarnings, applianceName(a))
+}
+
+// SignWarnings lists the recorded signing failures sorted by repository
+func SignWarnings() []SignWarning {
+	signWarningsLock.Lock()
+	defer signWarningsLock.Unlock()
+
+	var ret []SignWarning
+	for _, w := range signWarnings {
+		ret = append(ret, w)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Repository < ret[j].Repository
+	})
+
+	return ret
+}
+
+// VerifyMetaSign verifies meta.sign against the meta data and the current public key
+func (us *UpdateService) VerifyMetaSign() error {
+	payload, err := us.GetMeta()
+	if err != nil {
+		return err
+	}
+	env, err := us.GetMetaSignEnvelope()
+	if err != nil {
+		return err
+	}
+	pubBytes, err := us.getPublicKey()
+	if err != nil {
+		return err
+	}
+
+	return env.Verify(pubBytes, utils.DefaultPayloadType, payload)
+}
+
+// DefaultHealth checks the repositories of a proto/version by the settings
+func DefaultHealth(p, v string) (Health, error) {
+	storageURI, err := utils.GetSetting("storage-uri")
+	if err != nil {
+		return Health{}, err
+	}
+
+	kmURI, _ := utils.GetSetting("keymanager-uri")
+	kmMode, _ := utils.GetSetting("keymanager-mode")
+	return CheckHealth(storageURI, kmURI, kmMode, p, v)
+}
+
+// CheckHealth verifies the meta.sign of every repository of a proto/version and
+// reports the expired files of the repositories whose lifecycle policy only warns,
+// meta.sign is not verified without a key manager.
+func CheckHealth(storageURI, kmURI, kmMode, p, v string) (Health, error) {
+	health := Health{Status: HealthStatusOK, Warnings: SignWarnings()}
+
+	store, err := storage.NewUpdateServiceStorage(storageURI)
+	if err != nil {
+		return Health{}, err
+	}
+	appliances, err := listRepositories(store, p, v)
+	if err != nil {
+		return Health{}, err
+	}
+
+	now := time.Now()
+	for _, a := range appliances {
+		name := applianceName(a)
+		us, err := NewUpdateService(storageURI, kmURI, kmMode, p, v, a.Namespace, a.Repository)
+		if err == nil && kmURI != "" {
+			err = us.VerifyMetaSign()
+		}
+		if err != nil {
+			health.Unverified = append(health.Unverified, RepositoryHealth{Repository: name, Error: err.Error()})
+			continue
+		}
+
+		policy, err := us.GetLifecyclePolicy()
+		if err != nil || policy.Action != LifecycleWarn {
+			continue
+		}
+		kept := us.lastVersions(policy.KeepLast)
+		for _, item := range us.Items {
+			if item.GetExpired().Before(now) && !kept[item.FullName] {
+				health.Expired = append(health.Expired, ExpiredItem{Repository: name, FullName: item.FullName, Expired: item.GetExpired()})
+			}
+		}
+	}
+
+	if len(health.Unverified) > 0 || len(health.Warnings) > 0 || len(health.Expired) > 0 {
+		health.Status = HealthStatusWarning
+	}
+
+	return health, nil
+}
+
+// listRepositories lists the repositories of a proto/version which have meta data
+func listRepositories(store storage.UpdateServiceStorage, p, v string) ([]utils.Appliance, error) {
+	namespaces, err := store.List(fmt.Sprintf("%s/%s", p, v))
+	if err == storage.ErrorsNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var appliances []utils.Appliance
+	for _, n := range namespaces {
+		repositories, err := store.List(fmt.Sprintf("%s/%s/%s", p, v, n))
+		if err != nil {
+			continue
+		}
+		for _, r := range repositories {
+			// key files of the key manager could share the storage
+			if _, err := store.Get(fmt.Sprintf("%s/%s/%s/%s/%s", p, v, n, r, defaultMetaFileName)); err != nil {
+				continue
+			}
+			appliances = append(appliances, utils.Appliance{Proto: p, Version: v, Namespace: n, Repository: r})
+		}
+	}
+
+	return appliances, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/keymanager/keymanagertest"
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestSignPolicy(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+	defer utils.SetSetting("sign-policy", "")
+
+	mock := keymanagertest.NewMock("mock-policy")
+	keymanager.RegisterKeyManager("mock-policy", mock)
+	us, _ := NewUpdateService(tmpPath, "mock", "mock-policy", "p", "v", "n", "r")
+	item, _ := NewUpdateServiceItem("fn0", []string{"sha0"})
+	assert.Nil(t, us.Put(item), "Fail to put an item")
+	metaBytes, _ := us.GetMeta()
+
+	health, err := CheckHealth(tmpPath, "mock", "mock-policy", "p", "v")
+	assert.Nil(t, err, "Fail to check the health")
+	assert.Equal(t, HealthStatusOK, health.Status, "Signed repository should be healthy")
+
+	// strict: the upload fails and meta.json is rolled back
+	injected := errors.New("sign failure")
+	mock.Fail("Sign", injected)
+	item, _ = NewUpdateServiceItem("fn1", []string{"sha1"})
+	assert.Equal(t, SignPolicyStrict, SignPolicy(), "Strict should be the default policy")
+	assert.NotNil(t, us.Put(item), "Strict policy should fail an unsigned change")
+	assert.Equal(t, 1, len(us.Items), "Items should be rolled back")
+	data, _ := us.GetMeta()
+	assert.Equal(t, metaBytes, data, "meta.json should be rolled back")
+
+	// lenient: the upload is kept and reported
+	utils.SetSetting("sign-policy", SignPolicyLenient)
+	assert.Nil(t, us.Put(item), "Lenient policy should keep an unsigned change")
+	health, _ = CheckHealth(tmpPath, "mock", "mock-policy", "p", "v")
+	assert.Equal(t, HealthStatusWarning, health.Status)
+	assert.Equal(t, 1, len(health.Unverified), "Unsigned repository should be reported")
+	assert.Equal(t, "p/v/n/r", health.Unverified[0].Repository)
+	assert.Equal(t, 1, len(health.Warnings), "Signing failure should be recorded")
+	assert.Equal(t, injected.Error(), health.Warnings[0].Error)
+
+	mock.Fail("Sign", nil)
+	assert.Nil(t, us.Put(item), "Fail to put an item")
+	health, _ = CheckHealth(tmpPath, "mock", "mock-policy", "p", "v")
+	assert.Equal(t, HealthStatusOK, health.Status, "Signing again should clear the warning")
+}
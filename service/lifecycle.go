@@ -0,0 +1,265 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultLifecycleFileName = "lifecycle.json"
+
+	// LifecycleWarn reports the expired files by /health, it is the default action
+	LifecycleWarn = "warn"
+	// LifecycleHide hides the expired files from listings and version resolution,
+	// they could still be pulled by the full name
+	LifecycleHide = "hide"
+	// LifecycleDelete removes the expired files from the meta data and deletes their data
+	LifecycleDelete = "delete"
+)
+
+// LifecyclePolicy is the action on the expired files of a repository, the last
+// 'KeepLast' versions of every name are kept whether they are expired or not
+type LifecyclePolicy struct {
+	Action   string `json:"action"`
+	KeepLast int    `json:"keepLast,omitempty"`
+}
+
+// SweepResult lists the files a lifecycle policy acted on in a repository
+type SweepResult struct {
+	Repository string
+	Expired    []string `json:",omitempty"`
+	Hidden     []string `json:",omitempty"`
+	Deleted    []string `json:",omitempty"`
+	// Aborted are the ids of the expired transactions
+	Aborted []string `json:",omitempty"`
+}
+
+// IsValid checks the action and the count of the kept versions
+func (p LifecyclePolicy) IsValid() error {
+	if p.Action != LifecycleWarn && p.Action != LifecycleHide && p.Action != LifecycleDelete {
+		return fmt.Errorf("Unsupported lifecycle action: '%s'", p.Action)
+	}
+	if p.KeepLast < 0 {
+		return fmt.Errorf("Invalid count of the kept versions: %d", p.KeepLast)
+	}
+	return nil
+}
+
+// GetLifecyclePolicy gets the lifecycle policy of a repository, it only warns by default
+func (us *UpdateService) GetLifecyclePolicy() (LifecyclePolicy, error) {
+	data, err := us.GetStorage().Get(us.lifecycleKey())
+	if err == storage.ErrorsNotFound {
+		return LifecyclePolicy{Action: LifecycleWarn}, nil
+	} else if err != nil {
+		return LifecyclePolicy{}, err
+	}
+
+	var p LifecyclePolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return LifecyclePolicy{}, err
+	}
+	return p, p.IsValid()
+}
+
+// SetLifecyclePolicy sets the lifecycle policy of a repository
+func (us *UpdateService) SetLifecyclePolicy(p LifecyclePolicy) error {
+	if err := p.IsValid(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.GetStorage().Put(us.lifecycleKey(), data)
+	return err
+}
+
+// ApplyLifecycle applies the lifecycle policy to the files expired at 'now', and
+// saves and signs the meta data if a file is hidden, shown again or deleted
+func (us *UpdateService) ApplyLifecycle(now time.Time) (SweepResult, error) {
+	result := SweepResult{Repository: applianceName(us.appliance())}
+	p, err := us.GetLifecyclePolicy()
+	if err != nil {
+		return result, err
+	}
+	unlock, err := us.lock()
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	kept := us.lastVersions(p.KeepLast)
+	items := append([]UpdateServiceItem(nil), us.Items...)
+	var remained, deleted []UpdateServiceItem
+	changed := false
+	for _, item := range us.Items {
+		expired := item.GetExpired().Before(now) && !kept[item.FullName]
+		switch {
+		case !expired:
+			// a hidden file is shown again after its expiry is extended
+			if item.Hidden {
+				item.Hidden, changed = false, true
+			}
+		case p.Action == LifecycleWarn:
+			// a file hidden by the 'hide' policy is shown again once it only warns
+			if item.Hidden {
+				item.Hidden, changed = false, true
+			}
+			result.Expired = append(result.Expired, item.FullName)
+		case p.Action == LifecycleHide:
+			if !item.Hidden {
+				item.Hidden, changed = true, true
+				result.Hidden = append(result.Hidden, item.FullName)
+			}
+		case p.Action == LifecycleDelete:
+			deleted = append(deleted, item)
+			result.Deleted = append(result.Deleted, item.FullName)
+			changed = true
+			continue
+		}
+		remained = append(remained, item)
+	}
+	if !changed {
+		return result, nil
+	}
+
+	us.Items = remained
+	if err := us.save(); err != nil {
+		us.Items = items
+		return SweepResult{Repository: result.Repository}, err
+	}
+	us.deleteData(deleted)
+
+	return result, nil
+}
+
+// lastVersions gets the full names of the last 'count' versions of every name,
+// they are ordered by the semantic versions if all the tags of the name are
+// semantic versions, or by the created time otherwise
+func (us *UpdateService) lastVersions(count int) map[string]bool {
+	kept := make(map[string]bool)
+	if count <= 0 {
+		return kept
+	}
+
+	names := make(map[string][]UpdateServiceItem)
+	for _, item := range us.Items {
+		name, _ := utils.SplitFullName(item.FullName)
+		names[name] = append(names[name], item)
+	}
+	for _, items := range names {
+		versions := make(map[string]utils.SemVer)
+		for _, item := range items {
+			_, tag := utils.SplitFullName(item.FullName)
+			if v, err := utils.ParseSemVer(tag); err == nil {
+				versions[item.FullName] = v
+			}
+		}
+		if len(versions) == len(items) {
+			sort.SliceStable(items, func(i, j int) bool {
+				return versions[items[i].FullName].Compare(versions[items[j].FullName]) > 0
+			})
+		} else {
+			sort.SliceStable(items, func(i, j int) bool { return items[i].GetCreated().After(items[j].GetCreated()) })
+		}
+		for i := 0; i < count && i < len(items); i++ {
+			kept[items[i].FullName] = true
+		}
+	}
+
+	return kept
+}
+
+// deleteData deletes the data of the deleted files, parts are kept if other files
+// or the files staged in pending transactions use them
+func (us *UpdateService) deleteData(deleted []UpdateServiceItem) {
+	store := us.GetStorage()
+	used := make(map[string]bool)
+	items := append([]UpdateServiceItem(nil), us.Items...)
+	for _, tx := range us.pendingTransactions() {
+		items = append(items, tx.Items...)
+	}
+	for _, item := range items {
+		if item.IsMultiPart() {
+			for _, sha := range item.SHAS {
+				used[sha] = true
+			}
+		}
+	}
+
+	for _, item := range deleted {
+		us.deleteDeltas(item.FullName, item.Deltas)
+		if item.IsMultiPart() {
+			for _, sha := range item.SHAS {
+				if !used[sha] {
+					if _, err := store.Get(us.partKey(sha)); err == nil {
+						store.Delete(us.partKey(sha))
+					}
+				}
+			}
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", us.Proto, us.Version, us.Namespace, us.Repository, item.FullName)
+		if _, err := store.Get(key); err == nil {
+			store.Delete(key)
+		}
+	}
+}
+
+// DefaultSweep applies the lifecycle policies of the repositories of a proto/version by the settings
+func DefaultSweep(p, v string, now time.Time) ([]SweepResult, error) {
+	storageURI, err := utils.GetSetting("storage-uri")
+	if err != nil {
+		return nil, err
+	}
+
+	kmURI, _ := utils.GetSetting("keymanager-uri")
+	kmMode, _ := utils.GetSetting("keymanager-mode")
+	return Sweep(storageURI, kmURI, kmMode, p, v, now)
+}
+
+// Sweep applies the lifecycle policy of every repository of a proto/version to
+// the files expired at 'now' and aborts the expired transactions, a failure of a
+// repository does not stop the others
+func Sweep(storageURI, kmURI, kmMode, p, v string, now time.Time) ([]SweepResult, error) {
+	store, err := storage.NewUpdateServiceStorage(storageURI)
+	if err != nil {
+		return nil, err
+	}
+	appliances, err := listRepositories(store, p, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SweepResult
+	var firstErr error
+	for _, a := range appliances {
+		us, err := NewUpdateService(storageURI, kmURI, kmMode, p, v, a.Namespace, a.Repository)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result, err := us.ApplyLifecycle(now)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Fail to sweep %s: %v", result.Repository, err)
+		}
+		result.Aborted = us.AbortExpiredTransactions(now)
+		if len(result.Expired) > 0 || len(result.Hidden) > 0 || len(result.Deleted) > 0 || len(result.Aborted) > 0 {
+			results = append(results, result)
+		}
+	}
+
+	return results, firstErr
+}
+
+func (us *UpdateService) lifecycleKey() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultLifecycleFileName)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestLifecycle(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	for _, fn := range []string{"app:1.0.0", "app:1.1.0", "app:2.0.0"} {
+		item, _ := NewUpdateServiceItemFromContent(fn, []byte(fn), "", nil)
+		assert.Nil(t, us.Put(item), "Fail to put an item")
+		us.GetStorage().Put("p/v/n/r/blob/"+fn, []byte(fn))
+	}
+
+	p, err := us.GetLifecyclePolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, LifecycleWarn, p.Action, "Should only warn by default")
+	assert.NotNil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: "archive"}), "Should not set an invalid action")
+	assert.NotNil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: LifecycleHide, KeepLast: -1}))
+
+	later := time.Now().Add(2 * defaultLifecircle)
+	result, err := us.ApplyLifecycle(later)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(result.Expired), "Fail to report the expired items")
+
+	assert.Nil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: LifecycleHide, KeepLast: 1}))
+	result, err = us.ApplyLifecycle(later)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app:1.0.0", "app:1.1.0"}, result.Hidden, "The last version should be kept")
+	list, _ := us.List()
+	assert.Equal(t, []string{"app:2.0.0"}, list, "Hidden items should not be listed")
+	assert.Equal(t, []string{"2.0.0"}, us.ListVersions("app"))
+	_, err = us.Resolve("app", "^1.0", "")
+	assert.NotNil(t, err, "Hidden items should not be resolved by a constraint")
+	_, err = us.GetItem("app:1.0.0")
+	assert.Nil(t, err, "Hidden items could still be got by the full name")
+
+	assert.Nil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: LifecycleWarn}))
+	result, err = us.ApplyLifecycle(later)
+	assert.Nil(t, err)
+	list, _ = us.List()
+	assert.Equal(t, 3, len(list), "Items should be shown again once the policy only warns")
+	assert.Nil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: LifecycleHide, KeepLast: 1}))
+	us.ApplyLifecycle(later)
+
+	result, err = us.ApplyLifecycle(time.Now())
+	assert.Nil(t, err)
+	list, _ = us.List()
+	assert.Equal(t, 3, len(list), "Items no longer expired should be shown again")
+
+	assert.Nil(t, us.SetLifecyclePolicy(LifecyclePolicy{Action: LifecycleDelete, KeepLast: 1}))
+	result, err = us.ApplyLifecycle(later)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app:1.0.0", "app:1.1.0"}, result.Deleted)
+	reloaded, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	assert.Equal(t, 1, len(reloaded.Items), "Deleted items should leave the signed meta data")
+	_, err = us.GetStorage().Get("p/v/n/r/blob/app:1.0.0")
+	assert.NotNil(t, err, "Fail to delete the data of a deleted item")
+	_, err = us.GetStorage().Get("p/v/n/r/blob/app:2.0.0")
+	assert.Nil(t, err, "Should not delete the data of a kept item")
+
+	// the parts of the files staged in a transaction are kept
+	part := []byte("part")
+	sha, _ := utils.SHA512(part)
+	us.PutPart(sha, part)
+	id, _ := us.Begin()
+	assert.Nil(t, us.StageManifest(id, "app:3.0.0", Manifest{Parts: []ManifestPart{{SHA512: sha, Length: int64(len(part))}}}))
+	staged, _ := NewUpdateServiceItemFromManifest("app:0.1.0", Manifest{Parts: []ManifestPart{{SHA512: sha, Length: int64(len(part))}}})
+	us.deleteData([]UpdateServiceItem{staged})
+	_, err = us.GetPart(sha)
+	assert.Nil(t, err, "Should not delete a part staged in a transaction")
+	assert.Nil(t, us.Commit(id), "Fail to commit the staged file")
+}
+
+func TestLastVersions(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	created := time.Now()
+	for i, fn := range []string{"app:2.0.0", "app:1.0.0", "app:1.1.0", "lib:1.0.0", "lib:latest", "lib:0.9.0"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		item.Created = created.Add(time.Duration(i) * time.Minute)
+		us.Items = append(us.Items, item)
+	}
+
+	kept := us.lastVersions(2)
+	assert.Equal(t, map[string]bool{"app:2.0.0": true, "app:1.1.0": true, "lib:latest": true, "lib:0.9.0": true}, kept,
+		"Semantic versions should be ordered by the versions, the others by the created time")
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	// two loaded services, for example a request and the sweeper, keep the changes of each other
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	other, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	item, _ := NewUpdateServiceItem("app:1.0.0", []string{"sha0"})
+	assert.Nil(t, us.Put(item))
+	item, _ = NewUpdateServiceItem("app:2.0.0", []string{"sha1"})
+	assert.Nil(t, other.Put(item))
+
+	reloaded, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	assert.Equal(t, 2, len(reloaded.Items), "A change should not drop the changes made by others")
+}
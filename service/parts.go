@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultPartsDir = "parts"
+)
+
+// PutPart saves a part of a multi-part file by its content, 'sha512' is the
+// expected hex encoded sha512 of the content.
+// Parts are addressed by their sha512, so uploading a part again is harmless.
+func (us *UpdateService) PutPart(sha512 string, content []byte) error {
+	sum, err := utils.SHA512(content)
+	if err != nil {
+		return err
+	}
+	if sum != sha512 {
+		return fmt.Errorf("%v: expected sha512 <%s>, but get <%s>", ErrorsContentMismatch, sha512, sum)
+	}
+
+	_, err = us.GetStorage().Put(us.partKey(sha512), content)
+	return err
+}
+
+// GetPart gets the content of a part by its sha512
+func (us *UpdateService) GetPart(sha512 string) ([]byte, error) {
+	if len(sha512) != 128 {
+		return nil, errors.New("Invalid sha512 of a part")
+	}
+
+	return us.GetStorage().Get(us.partKey(sha512))
+}
+
+// PutManifest adds a multi-part file whose parts are all uploaded
+func (us *UpdateService) PutManifest(fullname string, m Manifest) error {
+	item, err := NewUpdateServiceItemFromManifest(fullname, m)
+	if err != nil {
+		return err
+	}
+
+	// the parts are verified under the lock, so they are not swept meanwhile
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := us.verifyParts(item); err != nil {
+		return err
+	}
+
+	return us.put(item, true)
+}
+
+// GetItemPart gets the content of a part of a multi-part file by its index
+func (us *UpdateService) GetItemPart(fullname string, index int) ([]byte, error) {
+	item, err := us.GetItem(fullname)
+	if err != nil {
+		return nil, err
+	}
+	sha, _, err := item.GetPart(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return us.GetPart(sha)
+}
+
+// GetItemContent gets the content of a multi-part file by joining its parts
+func (us *UpdateService) GetItemContent(fullname string) ([]byte, error) {
+	item, err := us.GetItem(fullname)
+	if err != nil {
+		return nil, err
+	}
+	if !item.IsMultiPart() {
+		return nil, fmt.Errorf("%s is not a multi-part file", fullname)
+	}
+
+	var buf bytes.Buffer
+	for i := range item.SHAS {
+		content, err := us.GetItemPart(fullname, i)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(content)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// verifyParts checks that all the parts of a multi-part file are uploaded
+func (us *UpdateService) verifyParts(item UpdateServiceItem) error {
+	for i, sha := range item.SHAS {
+		content, err := us.GetPart(sha)
+		if err == storage.ErrorsNotFound {
+			return fmt.Errorf("Part %d <%s> is not uploaded", i, sha)
+		} else if err != nil {
+			return err
+		}
+		if err := item.VerifyPart(i, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (us *UpdateService) partKey(sha512 string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultPartsDir, sha512)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestMultiPart(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+
+	parts := [][]byte{[]byte("layer0"), []byte("layer1-data"), []byte("l2")}
+	var m Manifest
+	for _, part := range parts {
+		sha, _ := utils.SHA512(part)
+		m.Parts = append(m.Parts, ManifestPart{SHA512: sha, Length: int64(len(part))})
+	}
+
+	assert.NotNil(t, us.PutManifest("fn", m), "Should not add a file before its parts are uploaded")
+	assert.NotNil(t, us.PutPart(m.Parts[0].SHA512, parts[1]), "Should not upload a part by another sha512")
+	for i, part := range parts {
+		assert.Nil(t, us.PutPart(m.Parts[i].SHA512, part), "Fail to upload a part")
+	}
+	assert.Nil(t, us.PutPart(m.Parts[0].SHA512, parts[0]), "Uploading a part again should be harmless")
+
+	m.Parts[2].Length++
+	assert.NotNil(t, us.PutManifest("fn", m), "Should not add a file with a wrong part size")
+	m.Parts[2].Length--
+	assert.Nil(t, us.PutManifest("fn", m), "Fail to add a multi-part file")
+
+	item, err := us.GetItem("fn")
+	assert.Nil(t, err)
+	assert.True(t, item.IsMultiPart())
+	assert.Equal(t, 3, len(item.SHAS), "SHAS should list the parts")
+	assert.Equal(t, int64(19), item.Length, "Length should be the total size")
+
+	data, err := us.GetItemPart("fn", 1)
+	assert.Nil(t, err, "Fail to get a part by its index")
+	assert.Equal(t, parts[1], data)
+	_, err = us.GetItemPart("fn", 3)
+	assert.NotNil(t, err, "Should not get a part out of range")
+
+	data, err = us.GetItemContent("fn")
+	assert.Nil(t, err, "Fail to join the parts")
+	assert.Equal(t, []byte("layer0layer1-datal2"), data)
+	assert.Nil(t, item.VerifyContent(data), "Fail to verify the joined parts")
+}
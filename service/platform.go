@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+// ListPlatform gets the 'app' files of an OS and an architecture under a repo, an
+// empty OS or architecture matches any. Files not named as 'os-arch-name' are skipped.
+func (us *UpdateService) ListPlatform(os, arch string) ([]string, error) {
+	if err := us.checkPlatform(os, arch); err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	for _, item := range us.Items {
+		a, err := utils.ParseAppliance(us.Proto, item.FullName)
+		if err != nil || item.Hidden {
+			continue
+		}
+		if (os == "" || a.OS == os) && (arch == "" || a.Arch == arch) {
+			list = append(list, item.FullName)
+		}
+	}
+
+	return list, nil
+}
+
+// ResolvePlatform gets the 'app' item of a name which runs best on an OS and an
+// architecture, only among the items of a release channel if 'channel' is not empty,
+// see ResolvePlatformVersion
+func (us *UpdateService) ResolvePlatform(name, constraint, channel, os, arch string) (UpdateServiceItem, error) {
+	if os == "" || arch == "" {
+		return UpdateServiceItem{}, fmt.Errorf("Both the OS and the architecture are needed to resolve %s", name)
+	}
+	if err := us.checkPlatform(os, arch); err != nil {
+		return UpdateServiceItem{}, err
+	}
+
+	if channel != "" {
+		return ResolvePlatformVersion(us.channelItems(channel), name, constraint, os, arch)
+	}
+	return ResolvePlatformVersion(us.Items, name, constraint, os, arch)
+}
+
+// ResolvePlatformVersion gets the item of 'os-arch-name' resolved by a version constraint,
+// see ResolveVersion. If the architecture has no such item, the architectures whose
+// binaries run on it are tried in order, for example '386' on 'amd64', see utils.CompatibleArchs.
+func ResolvePlatformVersion(items []UpdateServiceItem, name, constraint, os, arch string) (UpdateServiceItem, error) {
+	for _, a := range utils.CompatibleArchs(arch) {
+		if item, err := ResolveVersion(items, fmt.Sprintf("%s-%s-%s", os, a, name), constraint); err == nil {
+			return item, nil
+		}
+	}
+
+	if constraint == "" {
+		constraint = LatestVersion
+	}
+	return UpdateServiceItem{}, fmt.Errorf("Cannot find a version of %s satisfying '%s' for %s/%s", name, constraint, os, arch)
+}
+
+// checkPlatform checks that the files are 'app' files and the OS and the architecture
+// are known, an empty OS or architecture is valid
+func (us *UpdateService) checkPlatform(os, arch string) error {
+	if us.Proto != "app" {
+		return fmt.Errorf("Only app files have platforms, not %s files", us.Proto)
+	}
+	if os != "" && !utils.IsKnownOS(os) {
+		return fmt.Errorf("Unknown OS '%s', it should be one of %s", os, strings.Join(utils.KnownOS, ","))
+	}
+	if arch != "" && !utils.IsKnownArch(arch) {
+		return fmt.Errorf("Unknown architecture '%s', it should be one of %s", arch, strings.Join(utils.KnownArch, ","))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateServiceListPlatform(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "app", "v1", "n", "r")
+	for _, fn := range []string{"linux-amd64-app:1.0", "linux-arm64-app:1.0", "darwin-arm64-app:1.0", "legacy"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		assert.Nil(t, us.Put(item), "Fail to put an item")
+	}
+
+	list, err := us.ListPlatform("linux", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"linux-amd64-app:1.0", "linux-arm64-app:1.0"}, list)
+	list, _ = us.ListPlatform("", "arm64")
+	assert.Equal(t, []string{"linux-arm64-app:1.0", "darwin-arm64-app:1.0"}, list)
+	_, err = us.ListPlatform("linx", "")
+	assert.NotNil(t, err, "Should not list an unknown OS")
+
+	vm, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "vm", "v1", "n", "r")
+	_, err = vm.ListPlatform("linux", "")
+	assert.NotNil(t, err, "Only app files have platforms")
+}
+
+func TestResolvePlatform(t *testing.T) {
+	var items []UpdateServiceItem
+	for _, fn := range []string{"linux-amd64-app:1.0.0", "linux-amd64-app:1.1.0", "linux-386-app:1.2.0",
+		"linux-armv6-app:1.1.0", "linux-arm-app:1.1.0", "darwin-arm64-app:1.1.0"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		items = append(items, item)
+	}
+	us := UpdateService{Proto: "app", Items: items}
+
+	cases := []struct {
+		constraint string
+		os         string
+		arch       string
+		expected   string
+	}{
+		{constraint: "", os: "linux", arch: "amd64", expected: "linux-amd64-app:1.1.0"},
+		{constraint: "1.2.0", os: "linux", arch: "amd64", expected: "linux-386-app:1.2.0"},
+		{constraint: "^1.0", os: "linux", arch: "arm64", expected: "linux-armv6-app:1.1.0"},
+		{constraint: "", os: "linux", arch: "arm", expected: "linux-arm-app:1.1.0"},
+		{constraint: "", os: "darwin", arch: "arm64", expected: "darwin-arm64-app:1.1.0"},
+		{constraint: "", os: "windows", arch: "amd64", expected: ""},
+		{constraint: "", os: "linux", arch: "s390x", expected: ""},
+		{constraint: "^2.0", os: "linux", arch: "amd64", expected: ""},
+		{constraint: "", os: "linux", arch: "", expected: ""},
+		{constraint: "", os: "linx", arch: "amd64", expected: ""},
+	}
+	for _, c := range cases {
+		item, err := us.ResolvePlatform("app", c.constraint, "", c.os, c.arch)
+		if c.expected == "" {
+			assert.NotNil(t, err, "Should not resolve app@"+c.constraint+" for "+c.os+"/"+c.arch)
+		} else {
+			assert.Nil(t, err, "Fail to resolve app@"+c.constraint+" for "+c.os+"/"+c.arch)
+			assert.Equal(t, c.expected, item.FullName)
+		}
+	}
+
+	us.Proto = "vm"
+	_, err := us.ResolvePlatform("app", "", "", "linux", "amd64")
+	assert.NotNil(t, err, "Should not resolve the platform of a vm file")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/liangchenye/update-service/keymanager"
@@ -14,6 +15,17 @@ import (
 const (
 	defaultMetaFileName     = "meta.json"
 	defaultMetaSignFileName = "meta.sign"
+
+	// MetaSignFormatEnvelope serves meta.sign as a json signature envelope
+	MetaSignFormatEnvelope = "envelope"
+	// MetaSignFormatLegacy serves meta.sign as a raw signature for old clients
+	MetaSignFormatLegacy = "legacy"
+)
+
+var (
+	// metaLocks serializes the changes of the meta data of a repository of a storage
+	metaLocksLock sync.Mutex
+	metaLocks     = make(map[string]*sync.Mutex)
 )
 
 // UpdateService represents the meta info of a repository
@@ -24,6 +36,8 @@ type UpdateService struct {
 	Repository string
 	Items      []UpdateServiceItem
 	Updated    time.Time
+	// Channel is the release channel of the meta data of a channel, empty for the whole repository
+	Channel string `json:",omitempty"`
 
 	storageURI string
 	kmURI      string
@@ -100,10 +114,139 @@ func (us *UpdateService) GetMeta() ([]byte, error) {
 	return us.GetStorage().Get(key)
 }
 
-// GetMetaSign provides meta sign bytes
+// GetMetaSign provides meta sign bytes in the format of the 'meta-sign-format' setting,
+// the signature envelope is the default format.
 func (us *UpdateService) GetMetaSign() ([]byte, error) {
+	env, err := us.GetMetaSignEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := us.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	return us.formatMetaSign(env, payload)
+}
+
+// formatMetaSign converts a signature envelope to the format of the 'meta-sign-format' setting.
+// The envelope is signed over the PAE of the payload, the raw signature of the
+// payload old clients verify is made by the key of the repository when it is served.
+func (us *UpdateService) formatMetaSign(env utils.SignatureEnvelope, payload []byte) ([]byte, error) {
+	if format, _ := utils.GetSetting("meta-sign-format"); format == MetaSignFormatLegacy {
+		km := us.GetKM()
+		if km == nil {
+			return nil, keymanager.ErrorsKMNotSupported
+		}
+		return km.Sign(us.appliance(), payload)
+	}
+
+	return json.Marshal(env)
+}
+
+// GetMetaSignEnvelope loads the signature envelope of the meta data, a legacy
+// raw signature verified by the current public key is replaced by an envelope
+// signed by it.
+func (us *UpdateService) GetMetaSignEnvelope() (utils.SignatureEnvelope, error) {
 	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaSignFileName)
-	return us.GetStorage().Get(key)
+	data, err := us.GetStorage().Get(key)
+	if err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+
+	if env, err := utils.ParseSignatureEnvelope(data); err == nil {
+		return env, nil
+	}
+
+	payload, err := us.GetMeta()
+	if err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+	pubBytes, err := us.getPublicKey()
+	if err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+	if err := utils.SHA256Verify(pubBytes, payload, data); err != nil {
+		return utils.SignatureEnvelope{}, fmt.Errorf("Fail to verify the legacy meta signature: %v", err)
+	}
+
+	return us.signEnvelope(utils.DefaultPayloadType, payload)
+}
+
+// GetRole gets the keys trusted to sign the meta data and the threshold of them
+func (us *UpdateService) GetRole() (utils.Role, error) {
+	km := us.GetKM()
+	if km == nil {
+		return utils.Role{}, keymanager.ErrorsKMNotSupported
+	}
+
+	return km.GetRole(us.appliance())
+}
+
+// ImportSignatures adds the signatures of an envelope made offline to meta.sign.
+// Every signature should be made over the current meta data by a key of the role.
+func (us *UpdateService) ImportSignatures(data []byte) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	payload, err := us.GetMeta()
+	if err != nil {
+		return err
+	}
+	env, err := us.GetMetaSignEnvelope()
+	if err == storage.ErrorsNotFound {
+		env = utils.NewSignatureEnvelope(utils.DefaultPayloadType)
+	} else if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaSignFileName)
+	return us.importSignaturesTo(key, payload, env, data)
+}
+
+// importSignaturesTo verifies the signatures of an envelope made offline over a
+// payload of the type of 'env' by the keys of the role, and merges them to the envelope saved to a key
+func (us *UpdateService) importSignaturesTo(key string, payload []byte, env utils.SignatureEnvelope, data []byte) error {
+	imported, err := utils.ParseSignatureEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	role, err := us.GetRole()
+	if err != nil {
+		return err
+	}
+	rl, err := keymanager.GetRevocationList(us.GetKM(), us.appliance())
+	if err != nil {
+		return err
+	}
+	if err := rl.CheckEnvelope(imported); err != nil {
+		return err
+	}
+	for _, s := range imported.Signatures {
+		pubKey, ok := role.Keys[s.KeyID]
+		if !ok {
+			return fmt.Errorf("Key %s is not trusted to sign the meta data", s.KeyID)
+		}
+		if err := imported.Verify([]byte(pubKey), env.PayloadType, payload); err != nil {
+			return fmt.Errorf("Fail to verify the signature of key %s: %v", s.KeyID, err)
+		}
+	}
+
+	env.Merge(imported)
+
+	content, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if _, err := us.GetStorage().Put(key, content); err != nil {
+		return err
+	}
+
+	return us.appendLog(payload, content)
 }
 
 // TODO: this should not be in the update service, update service now is just handling meta/sign issues
@@ -132,36 +275,89 @@ func (us *UpdateService) GetItem(fullname string) (UpdateServiceItem, error) {
 	return UpdateServiceItem{}, fmt.Errorf("Cannot find the meta item: %s", fullname)
 }
 
-// List gets files under a repo
+// List gets files under a repo, hidden files are not listed
 func (us *UpdateService) List() ([]string, error) {
-	//TODO
+	list := []string{}
+	for _, item := range us.Items {
+		if !item.Hidden {
+			list = append(list, item.FullName)
+		}
+	}
 
-	return nil, nil
+	return list, nil
 }
 
-// Put adds an UpdateServiceItem to meta data, save both meta file and sign file
+// Put adds an UpdateServiceItem to meta data, save both meta file and sign file.
+// A file uploaded again stays in its release channels, see Replace to clear them.
 func (us *UpdateService) Put(usi UpdateServiceItem) error {
-	exist := false
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return us.put(usi, true)
+}
+
+// Replace adds an UpdateServiceItem to meta data like Put, the release channels
+// of the item replace the ones of the existing file.
+func (us *UpdateService) Replace(usi UpdateServiceItem) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return us.put(usi, false)
+}
+
+// put adds an UpdateServiceItem to meta data under the lock, it stays in the
+// release channels of the existing file if 'merge' is set
+func (us *UpdateService) put(usi UpdateServiceItem, merge bool) error {
+	old, err := us.GetItem(usi.FullName)
+	if err == nil && merge {
+		usi.mergeChannels(old)
+	}
+	// the deltas are only made for new content
+	exist := err == nil
+	newContent := keepDeltas(&usi, old, exist)
+
+	items := append([]UpdateServiceItem(nil), us.Items...)
+	found := false
 	for i := range us.Items {
 		if us.Items[i].Equal(usi) {
 			us.Items[i] = usi
-			exist = true
+			found = true
 		}
 	}
 
-	if !exist {
+	if !found {
 		us.Items = append(us.Items, usi)
 	}
 
 	if err := us.save(); err != nil {
+		us.Items = items
 		return err
 	}
 
+	if newContent {
+		if exist {
+			us.deleteDeltas(old.FullName, old.Deltas)
+		}
+		us.queueDeltas(usi)
+	}
 	return nil
 }
 
 // Delete removes an UpdateServiceItem from meta data, save both meta file and sign file after that
 func (us *UpdateService) Delete(fullname string) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items := append([]UpdateServiceItem(nil), us.Items...)
 	exist := false
 	for i := range us.Items {
 		if us.Items[i].FullName == fullname {
@@ -176,42 +372,189 @@ func (us *UpdateService) Delete(fullname string) error {
 	}
 
 	if err := us.save(); err != nil {
+		us.Items = items
+		return err
+	}
+
+	return nil
+}
+
+// lock serializes the changes of the meta data of the repository, the meta data
+// is loaded again under the lock so the changes made by others are kept
+func (us *UpdateService) lock() (func(), error) {
+	name := us.storageURI + "/" + fmt.Sprintf("%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository)
+
+	metaLocksLock.Lock()
+	lock, ok := metaLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		metaLocks[name] = lock
+	}
+	metaLocksLock.Unlock()
+
+	lock.Lock()
+	if err := us.reload(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return lock.Unlock, nil
+}
+
+// reload loads the items of the saved meta data
+func (us *UpdateService) reload() error {
+	data, err := us.GetMeta()
+	if err == storage.ErrorsNotFound {
+		return nil
+	} else if err != nil {
 		return err
 	}
 
+	var saved UpdateService
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	us.Items, us.Updated = saved.Items, saved.Updated
 	return nil
 }
 
-// save saves meta data to local file
+// save saves meta data to local file, a signing failure fails it and rolls back
+// meta.json, meta.sign, the channels and the log by the 'strict' policy, or is
+// recorded as a health warning by the 'lenient' one
 func (us *UpdateService) save() error {
+	updated := us.Updated
 	us.Updated = time.Now()
-	content, _ := json.Marshal(us)
-	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaFileName)
-	_, err := us.GetStorage().Put(key, content)
+	// meta.json is saved in canonical json, the same bytes are signed and verified
+	content, err := utils.CanonicalJSON(us)
 	if err != nil {
 		return err
 	}
-
+	var snap *snapshot
 	if us.kmURI != "" {
-		// write sign file, don't popup error even fail to saveSign
-		us.saveSign(content)
+		if snap, err = us.snapshot(); err != nil {
+			us.Updated = updated
+			return err
+		}
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaFileName)
+	if _, err = us.GetStorage().Put(key, content); err != nil {
+		us.Updated = updated
+		return err
 	}
 
+	if us.kmURI == "" {
+		return nil
+	}
+
+	// meta.json and meta.sign should never diverge silently, see the 'sign-policy' setting
+	err = us.saveSign(content)
+	if err == nil {
+		err = us.saveChannels()
+	}
+	if err != nil {
+		if SignPolicy() == SignPolicyLenient {
+			recordSignWarning(us.appliance(), err)
+			return nil
+		}
+
+		us.Updated = updated
+		snap.restore()
+		return fmt.Errorf("Fail to sign the meta data: %v", err)
+	}
+	clearSignWarning(us.appliance())
+
 	return nil
 }
 
-// saveSign signs the meta data and save the signed data to local file
-func (us *UpdateService) saveSign(content []byte) error {
-	a := utils.Appliance{Proto: us.Proto, Version: us.Version, Namespace: us.Namespace, Repository: us.Repository}
-	content, err := us.GetKM().Sign(a, content)
+// Resign signs the current meta data, and the meta data of the channels, again by
+// the online key, for example after the key is revoked and replaced.
+// The offline co-signatures are dropped.
+func (us *UpdateService) Resign() error {
+	unlock, err := us.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	content, err := us.GetMeta()
+	if err != nil {
+		return err
+	}
+	snap, err := us.snapshot()
+	if err != nil {
+		return err
+	}
+	err = us.saveSign(content)
+	if err == nil {
+		err = us.saveChannels()
+	}
+	if err != nil {
+		snap.restore()
+	}
 
-	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaSignFileName)
-	_, err = us.GetStorage().Put(key, content)
 	return err
 }
 
+// saveSign signs the meta data and save the signature envelope to local file
+func (us *UpdateService) saveSign(content []byte) error {
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaSignFileName)
+	return us.saveSignTo(key, utils.DefaultPayloadType, content)
+}
+
+// saveSignTo signs a version of meta data, logs it and saves the signature envelope to a key
+func (us *UpdateService) saveSignTo(key string, payloadType string, content []byte) error {
+	env, err := us.signEnvelope(payloadType, content)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if _, err := us.GetStorage().Put(key, data); err != nil {
+		return err
+	}
+
+	// every served version of the meta data is in the transparency log,
+	// it is appended once the signature is written
+	return us.appendLog(content, data)
+}
+
+// signEnvelope signs a payload of a type by the key of the repository
+func (us *UpdateService) signEnvelope(payloadType string, payload []byte) (utils.SignatureEnvelope, error) {
+	a := us.appliance()
+	km := us.GetKM()
+	if km == nil {
+		return utils.SignatureEnvelope{}, keymanager.ErrorsKMNotSupported
+	}
+
+	env := utils.NewSignatureEnvelope(payloadType)
+	sig, err := km.Sign(a, env.PAE(payload))
+	if err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+	pubBytes, err := km.GetPublicKey(a)
+	if err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+	if err := env.AddSignature(pubBytes, sig); err != nil {
+		return utils.SignatureEnvelope{}, err
+	}
+
+	return env, nil
+}
+
+func (us *UpdateService) getPublicKey() ([]byte, error) {
+	km := us.GetKM()
+	if km == nil {
+		return nil, keymanager.ErrorsKMNotSupported
+	}
+
+	return km.GetPublicKey(us.appliance())
+}
+
+func (us *UpdateService) appliance() utils.Appliance {
+	return utils.Appliance{Proto: us.Proto, Version: us.Version, Namespace: us.Namespace, Repository: us.Repository}
+}
+
 func (us *UpdateService) Debug() {
 }
@@ -1,10 +1,17 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/keymanager/keymanagertest"
 	"github.com/liangchenye/update-service/utils"
 	"github.com/stretchr/testify/assert"
 )
@@ -104,8 +111,20 @@ func TestUpdateServiceOper(t *testing.T) {
 	assert.Nil(t, err, "Fail to read meta file")
 
 	// get meta sign file
-	_, err = newService.GetMetaSign()
+	metaBytes, _ := newService.GetMeta()
+	pubBytes, _ := newService.GetKM().GetPublicKey(newService.appliance())
+	signBytes, err := newService.GetMetaSign()
 	assert.Nil(t, err, "Fail to read meta sign")
+	_, err = utils.ParseSignatureEnvelope(signBytes)
+	assert.Nil(t, err, "Fail to read meta sign as an envelope")
+	assert.Nil(t, utils.VerifyMetaSign(pubBytes, utils.DefaultPayloadType, metaBytes, signBytes), "Fail to verify meta sign")
+
+	// get meta sign file in legacy format
+	utils.SetSetting("meta-sign-format", MetaSignFormatLegacy)
+	signBytes, err = newService.GetMetaSign()
+	utils.SetSetting("meta-sign-format", MetaSignFormatEnvelope)
+	assert.Nil(t, err, "Fail to read legacy meta sign")
+	assert.Nil(t, utils.SHA256Verify(pubBytes, metaBytes, signBytes), "Fail to verify legacy meta sign")
 
 	// delete file
 	err = newService.Delete("fn")
@@ -115,3 +134,103 @@ func TestUpdateServiceOper(t *testing.T) {
 	_, err = newService.GetItem("fn")
 	assert.NotNil(t, err, "Should return error in query deleted item")
 }
+
+// TestMetaCanonicalGolden makes sure the signed bytes of a meta do not change
+// with the struct layout, otherwise all the existing signatures are broken.
+func TestMetaCanonicalGolden(t *testing.T) {
+	_, path, _, _ := runtime.Caller(0)
+	golden, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "testdata", "meta.canonical.json"))
+	assert.Nil(t, err, "Fail to read golden file")
+
+	created := time.Date(2016, time.August, 1, 8, 0, 0, 0, time.UTC)
+	us := UpdateService{Proto: "app", Version: "v1", Namespace: "containerops", Repository: "official", Updated: created}
+	us.Items = []UpdateServiceItem{
+		{FullName: "linux-amd64-appA:1.0", SHAS: []string{"sha0"}, Created: created, Updated: created, Expired: created.Add(defaultLifecircle)},
+	}
+
+	data, err := utils.CanonicalJSON(us)
+	assert.Nil(t, err, "Fail to marshal meta to canonical json")
+	assert.Equal(t, string(golden), string(data), "Canonical meta changed")
+
+	var loaded UpdateService
+	assert.Nil(t, json.Unmarshal(golden, &loaded), "Fail to load canonical meta")
+	again, _ := utils.CanonicalJSON(loaded)
+	assert.Equal(t, data, again, "Fail to get the same canonical meta after loading")
+}
+
+func TestUpdateServiceImportSignatures(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	onlinePub, _ := us.GetKM().GetPublicKey(us.appliance())
+	offlinePriv, offlinePub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	role, _ := utils.NewRole(2, onlinePub, offlinePub)
+	us.GetKM().SetRole(us.appliance(), role)
+
+	item, _ := NewUpdateServiceItem("fn", []string{"sha0"})
+	us.Put(item)
+	payload, _ := us.GetMeta()
+	env, _ := us.GetMetaSignEnvelope()
+	assert.NotNil(t, role.VerifyThreshold(utils.DefaultPayloadType, payload, env), "Online signature only should not reach the threshold")
+
+	// signatures of unknown keys or over other payloads are refused
+	unknownPriv, unknownPub, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	for _, c := range []struct {
+		priv    []byte
+		pub     []byte
+		payload []byte
+	}{
+		{unknownPriv, unknownPub, payload},
+		{offlinePriv, offlinePub, []byte("other payload")},
+	} {
+		imported := utils.NewSignatureEnvelope(utils.DefaultPayloadType)
+		sig, _ := utils.SHA256Sign(c.priv, imported.PAE(c.payload))
+		imported.AddSignature(c.pub, sig)
+		data, _ := json.Marshal(imported)
+		assert.NotNil(t, us.ImportSignatures(data), "Should not import invalid signature")
+	}
+
+	imported := utils.NewSignatureEnvelope(utils.DefaultPayloadType)
+	sig, _ := utils.SHA256Sign(offlinePriv, imported.PAE(payload))
+	imported.AddSignature(offlinePub, sig)
+	data, _ := json.Marshal(imported)
+	err = us.ImportSignatures(data)
+	assert.Nil(t, err, "Fail to import offline signature")
+
+	env, _ = us.GetMetaSignEnvelope()
+	assert.Nil(t, role.VerifyThreshold(utils.DefaultPayloadType, payload, env), "Fail to reach the threshold after co-signing")
+
+	offlineID, _ := utils.KeyID(offlinePub)
+	keymanager.Revoke(us.GetKM(), us.appliance(), offlineID, "leaked")
+	assert.NotNil(t, us.ImportSignatures(data), "Should not import signatures of a revoked key")
+}
+
+func TestUpdateServiceSignFailure(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	mock := keymanagertest.NewMock("mock-service")
+	keymanager.RegisterKeyManager("mock-service", mock)
+	us, err := NewUpdateService(tmpPath, "mock", "mock-service", "p", "v", "n", "r")
+	assert.Nil(t, err, "Fail to create a update service by the mock key manager")
+
+	injected := errors.New("sign failure")
+	mock.Fail("Sign", injected)
+	assert.Equal(t, injected, us.Resign(), "Resign should return the signing failure")
+	_, pubBytes, _ := utils.GenerateKeyPair(utils.KeyTypeEd25519)
+	d, _ := utils.NewDelegation("linux", []string{"linux/*"}, 1, pubBytes)
+	assert.Equal(t, injected, us.Delegate(d), "Delegate should return the signing failure")
+	_, err = us.GetDelegations()
+	assert.NotNil(t, err, "Delegations should not be saved without a signature")
+
+	mock.Fail("Sign", nil)
+	assert.Nil(t, us.Resign(), "Fail to resign after the failure is cleared")
+	env, err := us.GetMetaSignEnvelope()
+	assert.Nil(t, err)
+	payload, _ := us.GetMeta()
+	signPub, _ := mock.GetPublicKey(us.appliance())
+	assert.Nil(t, env.Verify(signPub, utils.DefaultPayloadType, payload), "Fail to verify the meta signed by the mock")
+}
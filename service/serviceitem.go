@@ -2,7 +2,10 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/liangchenye/update-service/utils"
 )
 
 const (
@@ -10,6 +13,19 @@ const (
 	defaultLifecircle = time.Hour * 24 * 180
 )
 
+const (
+	// HashSHA256 labels the hex encoded sha256 of a file in 'Hashes'
+	HashSHA256 = "sha256"
+	// HashSHA512 labels the hex encoded sha512 of a file in 'Hashes',
+	// it is the hash of 'SHAS' of the old items
+	HashSHA512 = "sha512"
+)
+
+var (
+	// ErrorsContentMismatch occurs when a file does not match the length or hashes of its item
+	ErrorsContentMismatch = errors.New("content does not match the meta data of the item")
+)
+
 // UpdateServiceItem keeps the meta data of a vm/app/image
 type UpdateServiceItem struct {
 	// Full represents a uniq name of a file within a repo, for app, fullname means os/arch/appname/tag
@@ -23,6 +39,36 @@ type UpdateServiceItem struct {
 	Updated time.Time
 	// Expired is used to check if a vm/app/image need to be upgraded
 	Expired time.Time
+	// Length is the size of a file in bytes, 0 for the old items
+	Length int64 `json:",omitempty"`
+	// Hashes are the hex encoded hashes of a file by the algorithm, for example "sha256"
+	Hashes map[string]string `json:",omitempty"`
+	// MediaType is the media type of a file, for example "application/gzip"
+	MediaType string `json:",omitempty"`
+	// Annotations are free-form key/values of a file, for example release notes
+	Annotations map[string]string `json:",omitempty"`
+	// PartLengths are the sizes of the parts of a multi-part file, in the order of 'SHAS'
+	PartLengths []int64 `json:",omitempty"`
+	// Channels are the release channels of a file, for example "beta" and "stable"
+	Channels []string `json:",omitempty"`
+	// Hidden files are expired and hidden from listings by the lifecycle policy
+	Hidden bool `json:",omitempty"`
+	// Deltas are the binary deltas to build a file from its previous versions
+	Deltas []Delta `json:",omitempty"`
+}
+
+// Manifest lists the ordered parts of a multi-part file to upload
+type Manifest struct {
+	MediaType   string            `json:"mediaType,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Channels    []string          `json:"channels,omitempty"`
+	Parts       []ManifestPart    `json:"parts"`
+}
+
+// ManifestPart is a part of a multi-part file by its hex encoded sha512 and size
+type ManifestPart struct {
+	SHA512 string `json:"sha512"`
+	Length int64  `json:"length"`
 }
 
 // NewUpdateServiceItem creates a service item by a 'FullName' and a 'SHA' list
@@ -39,6 +85,71 @@ func NewUpdateServiceItem(fn string, shas []string) (usi UpdateServiceItem, err
 	return usi, nil
 }
 
+// NewUpdateServiceItemFromContent creates a service item by a 'FullName' and
+// the content of a file, with its length, sha256 and sha512.
+// 'SHAS' keeps the sha512, so readers of the old format still work.
+func NewUpdateServiceItemFromContent(fn string, content []byte, mediaType string, annotations map[string]string) (UpdateServiceItem, error) {
+	sha256Sum, err := utils.SHA256(content)
+	if err != nil {
+		return UpdateServiceItem{}, err
+	}
+	sha512Sum, err := utils.SHA512(content)
+	if err != nil {
+		return UpdateServiceItem{}, err
+	}
+
+	usi, err := NewUpdateServiceItem(fn, []string{sha512Sum})
+	if err != nil {
+		return usi, err
+	}
+	usi.Length = int64(len(content))
+	usi.Hashes = map[string]string{HashSHA256: sha256Sum, HashSHA512: sha512Sum}
+	usi.MediaType = mediaType
+	if len(annotations) > 0 {
+		usi.Annotations = annotations
+	}
+
+	return usi, nil
+}
+
+// NewUpdateServiceItemFromManifest creates a multi-part service item by a
+// 'FullName' and the ordered parts of a manifest
+func NewUpdateServiceItemFromManifest(fn string, m Manifest) (UpdateServiceItem, error) {
+	if len(m.Parts) == 0 {
+		return UpdateServiceItem{}, errors.New("Manifest should have at least one part")
+	}
+
+	var shas []string
+	var lengths []int64
+	var length int64
+	for i, part := range m.Parts {
+		if len(part.SHA512) != 128 || part.Length < 0 {
+			return UpdateServiceItem{}, fmt.Errorf("Invalid part %d of the manifest", i)
+		}
+		shas = append(shas, part.SHA512)
+		lengths = append(lengths, part.Length)
+		length += part.Length
+	}
+
+	usi, err := NewUpdateServiceItem(fn, shas)
+	if err != nil {
+		return usi, err
+	}
+	usi.Length = length
+	usi.PartLengths = lengths
+	usi.MediaType = m.MediaType
+	if len(m.Annotations) > 0 {
+		usi.Annotations = m.Annotations
+	}
+	for _, c := range m.Channels {
+		if err := usi.AddChannel(c); err != nil {
+			return usi, err
+		}
+	}
+
+	return usi, nil
+}
+
 // isValid checks the fullname and SHAs
 func (usi *UpdateServiceItem) isValid() (bool, error) {
 	if usi.FullName == "" || len(usi.SHAS) == 0 {
@@ -53,11 +164,181 @@ func (usi *UpdateServiceItem) Equal(item UpdateServiceItem) bool {
 	return usi.FullName == item.FullName
 }
 
+// SameContent tells if two items are of the same content by their SHAs
+func (usi *UpdateServiceItem) SameContent(item UpdateServiceItem) bool {
+	if len(usi.SHAS) != len(item.SHAS) {
+		return false
+	}
+	for i := range usi.SHAS {
+		if usi.SHAS[i] != item.SHAS[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetHash get the hash strings of a file
 func (usi *UpdateServiceItem) GetSHAS() []string {
 	return usi.SHAS
 }
 
+// GetHashes gets the hashes of a file by the algorithm, the old items
+// only have the sha512 in 'SHAS'
+func (usi *UpdateServiceItem) GetHashes() map[string]string {
+	if len(usi.Hashes) > 0 {
+		return usi.Hashes
+	}
+	if len(usi.SHAS) == 1 {
+		return map[string]string{HashSHA512: usi.SHAS[0]}
+	}
+
+	return map[string]string{}
+}
+
+// GetAnnotation gets the value of an annotation, "" if not annotated
+func (usi *UpdateServiceItem) GetAnnotation(key string) string {
+	return usi.Annotations[key]
+}
+
+// GetDelta gets the delta of a file from a previous version by its full name
+func (usi *UpdateServiceItem) GetDelta(from string) (Delta, bool) {
+	for _, d := range usi.Deltas {
+		if d.From == from {
+			return d, true
+		}
+	}
+	return Delta{}, false
+}
+
+// IsMultiPart tells if a file is composed of several parts
+func (usi *UpdateServiceItem) IsMultiPart() bool {
+	return len(usi.PartLengths) > 0
+}
+
+// GetPart gets the sha512 and the size of a part of a multi-part file
+func (usi *UpdateServiceItem) GetPart(index int) (string, int64, error) {
+	if !usi.IsMultiPart() || len(usi.PartLengths) != len(usi.SHAS) {
+		return "", 0, fmt.Errorf("%s is not a multi-part file", usi.FullName)
+	}
+	if index < 0 || index >= len(usi.SHAS) {
+		return "", 0, fmt.Errorf("Part %d is out of the %d parts of %s", index, len(usi.SHAS), usi.FullName)
+	}
+
+	return usi.SHAS[index], usi.PartLengths[index], nil
+}
+
+// VerifyPart checks the content of a part of a multi-part file by its size and sha512
+func (usi *UpdateServiceItem) VerifyPart(index int, content []byte) error {
+	sha, length, err := usi.GetPart(index)
+	if err != nil {
+		return err
+	}
+	if length != int64(len(content)) {
+		return fmt.Errorf("%v: expected length %d of part %d, but get %d", ErrorsContentMismatch, length, index, len(content))
+	}
+	sum, err := utils.SHA512(content)
+	if err != nil {
+		return err
+	}
+	if sum != sha {
+		return fmt.Errorf("%v: expected sha512 <%s> of part %d, but get <%s>", ErrorsContentMismatch, sha, index, sum)
+	}
+
+	return nil
+}
+
+// VerifyContent checks the content of a file by the length and every hash
+// of a known algorithm, at least one hash should be checked.
+// A multi-part file is checked part by part.
+func (usi *UpdateServiceItem) VerifyContent(content []byte) error {
+	if usi.Length != 0 && usi.Length != int64(len(content)) {
+		return fmt.Errorf("%v: expected length %d, but get %d", ErrorsContentMismatch, usi.Length, len(content))
+	}
+
+	if usi.IsMultiPart() {
+		var offset int64
+		for i, length := range usi.PartLengths {
+			if offset+length > int64(len(content)) {
+				return fmt.Errorf("%v: content is shorter than the parts", ErrorsContentMismatch)
+			}
+			if err := usi.VerifyPart(i, content[offset:offset+length]); err != nil {
+				return err
+			}
+			offset += length
+		}
+		return nil
+	}
+
+	checked := 0
+	for alg, expected := range usi.GetHashes() {
+		var sum string
+		var err error
+		switch alg {
+		case HashSHA256:
+			sum, err = utils.SHA256(content)
+		case HashSHA512:
+			sum, err = utils.SHA512(content)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if sum != expected {
+			return fmt.Errorf("%v: expected %s <%s>, but get <%s>", ErrorsContentMismatch, alg, expected, sum)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%v: no hash of a known algorithm", ErrorsContentMismatch)
+	}
+
+	return nil
+}
+
+// InChannel tells if a file is in a release channel
+func (usi *UpdateServiceItem) InChannel(channel string) bool {
+	for _, c := range usi.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// AddChannel adds a file to a release channel
+func (usi *UpdateServiceItem) AddChannel(channel string) error {
+	if !IsValidChannel(channel) {
+		return fmt.Errorf("Invalid channel name: '%s'", channel)
+	}
+	if !usi.InChannel(channel) {
+		usi.Channels = append(usi.Channels, channel)
+	}
+	return nil
+}
+
+// mergeChannels adds a file to the release channels of another version of it
+func (usi *UpdateServiceItem) mergeChannels(old UpdateServiceItem) {
+	for _, c := range old.Channels {
+		if !usi.InChannel(c) {
+			usi.Channels = append(usi.Channels, c)
+		}
+	}
+}
+
+// RemoveChannel removes a file from a release channel
+func (usi *UpdateServiceItem) RemoveChannel(channel string) {
+	for i, c := range usi.Channels {
+		if c == channel {
+			usi.Channels = append(usi.Channels[:i], usi.Channels[i+1:]...)
+			break
+		}
+	}
+	if len(usi.Channels) == 0 {
+		usi.Channels = nil
+	}
+}
+
 // GetCreated returns the created time of an application
 func (usi *UpdateServiceItem) GetCreated() time.Time {
 	return usi.Created
@@ -1,10 +1,13 @@
 package service
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
 )
 
 func TestItemisValid(t *testing.T) {
@@ -61,3 +64,63 @@ func TestItemSetGet(t *testing.T) {
 	testItem.SetCreated(testNewCreated)
 	assert.Equal(t, testNewCreated, testItem.GetCreated(), "Fail to set/get created time")
 }
+
+func TestItemFromContent(t *testing.T) {
+	content := []byte("hello update service")
+	item, err := NewUpdateServiceItemFromContent("fn", content, "text/plain", map[string]string{"release-notes": "first release"})
+	assert.Nil(t, err, "Fail to create an item from content")
+	assert.Equal(t, int64(len(content)), item.Length, "Fail to get the length")
+	assert.Equal(t, "text/plain", item.MediaType, "Fail to get the media type")
+	assert.Equal(t, "first release", item.GetAnnotation("release-notes"), "Fail to get the annotation")
+	assert.Equal(t, item.Hashes[HashSHA512], item.SHAS[0], "SHAS should keep the sha512")
+	assert.NotEqual(t, "", item.Hashes[HashSHA256], "Fail to get the sha256")
+
+	assert.Nil(t, item.VerifyContent(content), "Fail to verify the content")
+	assert.NotNil(t, item.VerifyContent([]byte("hello update servicf")), "Should not verify other content")
+	assert.NotNil(t, item.VerifyContent(content[1:]), "Should not verify content of another length")
+
+	item.Hashes[HashSHA256] = "invalid"
+	assert.NotNil(t, item.VerifyContent(content), "Every known hash should be checked")
+	item.Hashes = map[string]string{"md4": "unknown"}
+	assert.NotNil(t, item.VerifyContent(content), "Should not verify without a known hash")
+}
+
+func TestItemOldFormat(t *testing.T) {
+	content := []byte("hello update service")
+	item, _ := NewUpdateServiceItemFromContent("fn", content, "", nil)
+
+	// items saved before the length and hashes
+	old := []byte(`{"FullName":"fn","SHAS":["` + item.SHAS[0] + `"],"Created":"2016-01-01T00:00:00Z","Updated":"2016-01-01T00:00:00Z","Expired":"2016-07-01T00:00:00Z"}`)
+	var loaded UpdateServiceItem
+	assert.Nil(t, json.Unmarshal(old, &loaded), "Fail to load an old item")
+	assert.Equal(t, map[string]string{HashSHA512: item.SHAS[0]}, loaded.GetHashes(), "Old items should have the sha512 hash")
+	assert.Nil(t, loaded.VerifyContent(content), "Fail to verify the content by an old item")
+
+	data, _ := json.Marshal(loaded)
+	assert.Equal(t, old, data, "Old items should be saved in the old format")
+}
+
+func TestItemFromManifest(t *testing.T) {
+	parts := [][]byte{[]byte("part0"), []byte("part1")}
+	var m Manifest
+	for _, part := range parts {
+		sha, _ := utils.SHA512(part)
+		m.Parts = append(m.Parts, ManifestPart{SHA512: sha, Length: int64(len(part))})
+	}
+
+	item, err := NewUpdateServiceItemFromManifest("fn", m)
+	assert.Nil(t, err, "Fail to create an item from a manifest")
+	assert.True(t, item.IsMultiPart())
+	for i, part := range parts {
+		assert.Nil(t, item.VerifyPart(i, part), "Fail to verify a part")
+	}
+	assert.NotNil(t, item.VerifyPart(0, parts[1]), "Should not verify another part")
+	assert.NotNil(t, item.VerifyPart(2, parts[1]), "Should not verify a part out of range")
+	assert.Nil(t, item.VerifyContent([]byte("part0part1")), "Fail to verify the joined parts")
+	assert.NotNil(t, item.VerifyContent([]byte("part1part0")), "Should not verify the parts in another order")
+
+	_, err = NewUpdateServiceItemFromManifest("fn", Manifest{})
+	assert.NotNil(t, err, "Should not create an item without parts")
+	_, err = NewUpdateServiceItemFromManifest("fn", Manifest{Parts: []ManifestPart{{SHA512: "sha0", Length: 1}}})
+	assert.NotNil(t, err, "Should not create an item by an invalid sha512")
+}
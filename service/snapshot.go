@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+// snapshot keeps the signed files of a repository, meta.json, meta.sign, the
+// meta data of the channels and the transparency log, before they are changed,
+// so a change failing half way is rolled back as a whole
+type snapshot struct {
+	us *UpdateService
+	// files are the contents by their keys, nil for the absent ones
+	files      map[string][]byte
+	logSize    int64
+	leafHashes []byte
+	treeHead   []byte
+}
+
+// snapshot keeps the signed files of the current items, it should be taken under the lock
+func (us *UpdateService) snapshot() (*snapshot, error) {
+	s := &snapshot{us: us, files: make(map[string][]byte)}
+	keys := []string{
+		fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaFileName),
+		fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultMetaSignFileName),
+	}
+	channels, err := us.ListChannels()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range channels {
+		keys = append(keys, us.channelKey(c+"/"+defaultMetaFileName), us.channelKey(c+"/"+defaultMetaSignFileName))
+	}
+
+	store := us.GetStorage()
+	for _, key := range keys {
+		if s.files[key], err = snapshotGet(store, key); err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := us.lockLog()
+	defer unlock()
+	if s.logSize, err = us.logSize(); err != nil {
+		return nil, err
+	}
+	if s.leafHashes, err = snapshotGet(store, us.logKey(defaultLeafHashesFileName)); err != nil {
+		return nil, err
+	}
+	if s.treeHead, err = snapshotGet(store, us.logKey(defaultTreeHeadFileName)); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// restore puts back the signed files and drops the log entries appended after the snapshot
+func (s *snapshot) restore() {
+	us := s.us
+	store := us.GetStorage()
+	for key, data := range s.files {
+		snapshotPut(store, key, data)
+	}
+
+	unlock := us.lockLog()
+	defer unlock()
+	size, err := us.logSize()
+	if err != nil {
+		return
+	}
+	for i := s.logSize; i < size; i++ {
+		leaf, err := store.Get(us.logEntryKey(i))
+		if err != nil {
+			continue
+		}
+		// the index only points to the dropped entry if it is its first one
+		indexKey := us.logIndexKey(utils.MerkleLeafHash(leaf))
+		if data, err := store.Get(indexKey); err == nil && string(data) == fmt.Sprint(i) {
+			store.Delete(indexKey)
+		}
+		store.Delete(us.logEntryKey(i))
+	}
+	snapshotPut(store, us.logKey(defaultLeafHashesFileName), s.leafHashes)
+	snapshotPut(store, us.logKey(defaultTreeHeadFileName), s.treeHead)
+}
+
+func snapshotGet(store storage.UpdateServiceStorage, key string) ([]byte, error) {
+	data, err := store.Get(key)
+	if err == storage.ErrorsNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func snapshotPut(store storage.UpdateServiceStorage, key string, data []byte) {
+	if data != nil {
+		store.Put(key, data)
+	} else if _, err := store.Get(key); err == nil {
+		store.Delete(key)
+	}
+}
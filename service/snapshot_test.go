@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/keymanager/keymanagertest"
+)
+
+func TestSaveRollback(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	mock := keymanagertest.NewMock("mock-rollback")
+	keymanager.RegisterKeyManager("mock-rollback", mock)
+	us, _ := NewUpdateService(tmpPath, "mock", "mock-rollback", "p", "v", "n", "r")
+	item, _ := NewUpdateServiceItem("fn0", []string{"sha0"})
+	assert.Nil(t, item.AddChannel("stable"))
+	assert.Nil(t, us.Put(item), "Fail to put an item")
+
+	signed := func() []string {
+		meta, _ := us.GetMeta()
+		metaSign, _ := us.GetMetaSign()
+		channelMeta, _ := us.GetChannelMeta("stable")
+		channelMetaSign, _ := us.GetChannelMetaSign("stable")
+		treeHead, _ := us.GetTreeHead()
+		size, _ := us.logSize()
+		return []string{string(meta), string(metaSign), string(channelMeta), string(channelMetaSign), string(treeHead), fmt.Sprint(size)}
+	}
+	before := signed()
+
+	// each signature of a save fails in turn, nothing signed is left changed
+	injected := errors.New("sign failure")
+	for n := 0; ; n++ {
+		mock.FailAfter("Sign", n, injected)
+		item, _ = NewUpdateServiceItem(fmt.Sprintf("fn%d", n+1), []string{"sha"})
+		assert.Nil(t, item.AddChannel("stable"))
+		if us.Put(item) == nil {
+			assert.True(t, n > 1, "Meta data, channels and log should all be signed")
+			break
+		}
+		assert.Equal(t, before, signed(), "Signed files should be rolled back after %d signatures", n)
+	}
+	mock.Fail("Sign", nil)
+}
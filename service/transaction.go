@@ -0,0 +1,307 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultTransactionsDir     = "transactions"
+	defaultTransactionFileName = "transaction.json"
+
+	// DefaultTransactionTTL is how long a transaction could be committed after it
+	// is begun if the 'transaction-ttl' setting is not set
+	DefaultTransactionTTL = 24 * time.Hour
+)
+
+var (
+	// ErrorsTransactionNotFound occurs when a transaction is not begun, or is committed or aborted
+	ErrorsTransactionNotFound = errors.New("transaction not found")
+
+	transactionIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// Transaction stages files to be published together, the meta data is saved
+// and signed once when it is committed, so clients never see a part of it
+type Transaction struct {
+	ID      string
+	Created time.Time
+	Items   []UpdateServiceItem
+}
+
+// Begin begins a transaction and returns its id
+func (us *UpdateService) Begin() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	tx := Transaction{ID: hex.EncodeToString(b), Created: time.Now()}
+	if err := us.saveTransaction(tx); err != nil {
+		return "", err
+	}
+	return tx.ID, nil
+}
+
+// GetTransaction gets a transaction and the files staged in it, an expired
+// transaction is not found
+func (us *UpdateService) GetTransaction(id string) (Transaction, error) {
+	tx, err := us.loadTransaction(id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if tx.IsExpired(time.Now()) {
+		return Transaction{}, fmt.Errorf("%v: %s is expired", ErrorsTransactionNotFound, id)
+	}
+
+	return tx, nil
+}
+
+// IsExpired tells if a transaction is begun longer than the 'transaction-ttl' setting before 'now'
+func (tx *Transaction) IsExpired(now time.Time) bool {
+	ttl := DefaultTransactionTTL
+	if setting, err := utils.GetSetting("transaction-ttl"); err == nil && setting != "" {
+		if d, err := time.ParseDuration(setting); err == nil {
+			ttl = d
+		}
+	}
+
+	return ttl > 0 && tx.Created.Add(ttl).Before(now)
+}
+
+// AbortExpiredTransactions drops the transactions expired at 'now' and the data
+// staged in them, it returns their ids
+func (us *UpdateService) AbortExpiredTransactions(now time.Time) []string {
+	ids, err := us.GetStorage().List(us.transactionKey("", ""))
+	if err != nil {
+		return nil
+	}
+
+	var aborted []string
+	for _, id := range ids {
+		if tx, err := us.loadTransaction(id); err == nil && tx.IsExpired(now) {
+			us.deleteTransaction(tx)
+			aborted = append(aborted, id)
+		}
+	}
+	return aborted
+}
+
+func (us *UpdateService) loadTransaction(id string) (Transaction, error) {
+	if !transactionIDRegexp.MatchString(id) {
+		return Transaction{}, fmt.Errorf("Invalid transaction id: '%s'", id)
+	}
+
+	data, err := us.GetStorage().Get(us.transactionKey(id, defaultTransactionFileName))
+	if err == storage.ErrorsNotFound {
+		return Transaction{}, fmt.Errorf("%v: %s", ErrorsTransactionNotFound, id)
+	} else if err != nil {
+		return Transaction{}, err
+	}
+
+	var tx Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return Transaction{}, err
+	}
+	return tx, nil
+}
+
+// Stage adds a file to a transaction, a file of the same name staged before is replaced.
+// 'content' is the data of the file, it is nil for a multi-part file whose parts are uploaded.
+func (us *UpdateService) Stage(id string, item UpdateServiceItem, content []byte) error {
+	tx, err := us.GetTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	if item.IsMultiPart() {
+		if err := us.verifyParts(item); err != nil {
+			return err
+		}
+	} else {
+		if err := item.VerifyContent(content); err != nil {
+			return err
+		}
+		if _, err := us.GetStorage().Put(us.transactionKey(id, "blob/"+item.FullName), content); err != nil {
+			return err
+		}
+	}
+
+	exist := false
+	for i := range tx.Items {
+		if tx.Items[i].Equal(item) {
+			tx.Items[i] = item
+			exist = true
+		}
+	}
+	if !exist {
+		tx.Items = append(tx.Items, item)
+	}
+
+	return us.saveTransaction(tx)
+}
+
+// StageManifest adds a multi-part file whose parts are all uploaded to a transaction
+func (us *UpdateService) StageManifest(id string, fullname string, m Manifest) error {
+	item, err := NewUpdateServiceItemFromManifest(fullname, m)
+	if err != nil {
+		return err
+	}
+
+	return us.Stage(id, item, nil)
+}
+
+// Commit publishes the files of a transaction, the meta data is saved and
+// signed once. The staged data replaces the published data only after the
+// signature is written, nothing is published if it fails and it could be committed again.
+func (us *UpdateService) Commit(id string) error {
+	unlock, err := us.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx, err := us.GetTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	store := us.GetStorage()
+	items := append([]UpdateServiceItem(nil), us.Items...)
+	contents := make(map[string][]byte)
+	// the deltas of the files of new content are made after they are published
+	var changed, replaced []UpdateServiceItem
+	for _, item := range tx.Items {
+		var content []byte
+		if item.IsMultiPart() {
+			err = us.verifyParts(item)
+		} else {
+			content, err = store.Get(us.transactionKey(id, "blob/"+item.FullName))
+			contents[item.FullName] = content
+		}
+		if err != nil {
+			us.Items = items
+			return fmt.Errorf("Fail to commit %s: %v", item.FullName, err)
+		}
+
+		old, oldErr := us.GetItem(item.FullName)
+		if keepDeltas(&item, old, oldErr == nil) {
+			changed = append(changed, item)
+			if oldErr == nil {
+				replaced = append(replaced, old)
+			}
+		}
+
+		exist := false
+		for i := range us.Items {
+			if us.Items[i].Equal(item) {
+				item.mergeChannels(us.Items[i])
+				us.Items[i] = item
+				exist = true
+			}
+		}
+		if !exist {
+			us.Items = append(us.Items, item)
+		}
+	}
+
+	if err := us.save(); err != nil {
+		us.Items = items
+		return err
+	}
+
+	// the old data of the replaced files, and the signed meta data, are restored if publishing fails
+	olds := make(map[string][]byte)
+	var moved []string
+	for fullname, content := range contents {
+		key := fmt.Sprintf("%s/%s/%s/%s/blob/%s", us.Proto, us.Version, us.Namespace, us.Repository, fullname)
+		if old, oldErr := store.Get(key); oldErr == nil {
+			olds[key] = old
+		}
+		moved = append(moved, key)
+		if _, err = store.Put(key, content); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		for _, key := range moved {
+			if old, ok := olds[key]; ok {
+				store.Put(key, old)
+			} else {
+				store.Delete(key)
+			}
+		}
+		us.Items = items
+		us.save()
+		return fmt.Errorf("Fail to publish the files of transaction %s: %v", id, err)
+	}
+
+	us.deleteTransaction(tx)
+	for _, old := range replaced {
+		us.deleteDeltas(old.FullName, old.Deltas)
+	}
+	for _, item := range changed {
+		us.queueDeltas(item)
+	}
+	return nil
+}
+
+// Abort drops a transaction and the data staged in it
+func (us *UpdateService) Abort(id string) error {
+	tx, err := us.GetTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	us.deleteTransaction(tx)
+	return nil
+}
+
+// pendingTransactions lists the transactions which are not committed or aborted
+func (us *UpdateService) pendingTransactions() []Transaction {
+	ids, err := us.GetStorage().List(us.transactionKey("", ""))
+	if err != nil {
+		return nil
+	}
+
+	var txs []Transaction
+	for _, id := range ids {
+		if tx, err := us.GetTransaction(id); err == nil {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (us *UpdateService) saveTransaction(tx Transaction) error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.GetStorage().Put(us.transactionKey(tx.ID, defaultTransactionFileName), data)
+	return err
+}
+
+func (us *UpdateService) deleteTransaction(tx Transaction) {
+	store := us.GetStorage()
+	for _, item := range tx.Items {
+		key := us.transactionKey(tx.ID, "blob/"+item.FullName)
+		if _, err := store.Get(key); err == nil {
+			store.Delete(key)
+		}
+	}
+	store.Delete(us.transactionKey(tx.ID, defaultTransactionFileName))
+}
+
+func (us *UpdateService) transactionKey(id, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, defaultTransactionsDir, id, name)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestTransaction(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	treeSize := func() int64 {
+		data, _ := us.GetTreeHead()
+		sth, _ := utils.ParseSignedTreeHead(data)
+		return sth.Signed.TreeSize
+	}
+	size := treeSize()
+
+	id, err := us.Begin()
+	assert.Nil(t, err, "Fail to begin a transaction")
+	for _, fn := range []string{"app:1.0.0", "lib:1.0.0"} {
+		item, _ := NewUpdateServiceItemFromContent(fn, []byte(fn), "", nil)
+		assert.Nil(t, us.Stage(id, item, []byte(fn)), "Fail to stage an item")
+	}
+	item, _ := NewUpdateServiceItemFromContent("bad", []byte("bad"), "", nil)
+	assert.NotNil(t, us.Stage(id, item, []byte("other")), "Should not stage mismatched content")
+
+	part := []byte("part")
+	sha, _ := utils.SHA512(part)
+	m := Manifest{Parts: []ManifestPart{{SHA512: sha, Length: int64(len(part))}}}
+	assert.NotNil(t, us.StageManifest(id, "big", m), "Should not stage a manifest whose parts are not uploaded")
+	assert.Nil(t, us.PutPart(sha, part))
+	assert.Nil(t, us.StageManifest(id, "big", m), "Fail to stage a manifest")
+
+	tx, err := us.GetTransaction(id)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(tx.Items))
+	list, _ := us.List()
+	assert.Equal(t, 0, len(list), "Staged items should not be published")
+	_, err = us.GetStorage().Get("p/v/n/r/blob/app:1.0.0")
+	assert.NotNil(t, err, "Staged data should not be published")
+
+	assert.Nil(t, us.Commit(id), "Fail to commit a transaction")
+	list, _ = us.List()
+	assert.Equal(t, []string{"app:1.0.0", "lib:1.0.0", "big"}, list)
+	assert.Equal(t, size+1, treeSize(), "A transaction should be signed once")
+	data, err := us.GetStorage().Get("p/v/n/r/blob/app:1.0.0")
+	assert.Nil(t, err, "Fail to publish the staged data")
+	assert.Equal(t, "app:1.0.0", string(data))
+	_, err = us.GetTransaction(id)
+	assert.NotNil(t, err, "A committed transaction should be dropped")
+
+	id, _ = us.Begin()
+	item, _ = NewUpdateServiceItemFromContent("app:2.0.0", []byte("2"), "", nil)
+	assert.Nil(t, us.Stage(id, item, []byte("2")))
+	assert.Nil(t, us.Abort(id), "Fail to abort a transaction")
+	assert.NotNil(t, us.Commit(id), "Should not commit an aborted transaction")
+	list, _ = us.List()
+	assert.Equal(t, 3, len(list))
+	assert.NotNil(t, us.Abort("../../meta"), "Should not accept an invalid id")
+
+	// the published data is not replaced unless the meta data is signed
+	unsigned, _ := NewUpdateService(tmpPath, tmpPath, "unknown", "p", "v", "n", "r")
+	id, _ = unsigned.Begin()
+	item, _ = NewUpdateServiceItemFromContent("app:1.0.0", []byte("new"), "", nil)
+	assert.Nil(t, unsigned.Stage(id, item, []byte("new")))
+	assert.NotNil(t, unsigned.Commit(id), "Should not commit if the meta data could not be signed")
+	data, _ = us.GetStorage().Get("p/v/n/r/blob/app:1.0.0")
+	assert.Equal(t, "app:1.0.0", string(data), "Should not publish the data of a failed commit")
+	assert.Nil(t, us.Commit(id), "A failed commit could be committed again")
+	data, _ = us.GetStorage().Get("p/v/n/r/blob/app:1.0.0")
+	assert.Equal(t, "new", string(data))
+
+	// expired transactions are dropped
+	id, _ = us.Begin()
+	assert.Equal(t, 0, len(us.AbortExpiredTransactions(time.Now())))
+	assert.Equal(t, []string{id}, us.AbortExpiredTransactions(time.Now().Add(2*DefaultTransactionTTL)))
+	_, err = us.GetTransaction(id)
+	assert.NotNil(t, err, "An expired transaction should be dropped")
+}
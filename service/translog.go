@@ -0,0 +1,286 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/liangchenye/update-service/keymanager"
+	"github.com/liangchenye/update-service/storage"
+	"github.com/liangchenye/update-service/utils"
+)
+
+const (
+	defaultLogEntriesDir      = "log/entries"
+	defaultLogIndexDir        = "log/index"
+	defaultTreeHeadFileName   = "log/sth.json"
+	defaultLeafHashesFileName = "log/leafhashes"
+)
+
+var (
+	// logLocks serializes the appending to the log of a repository of a storage
+	logLocksLock sync.Mutex
+	logLocks     = make(map[string]*sync.Mutex)
+)
+
+// LogEntry is a leaf of the transparency log of a repository, one version of
+// meta.json and meta.sign by their hex encoded sha256
+type LogEntry struct {
+	Meta     string `json:"meta"`
+	MetaSign string `json:"metaSign"`
+}
+
+// LogLeaf gets the leaf data of a meta.json and its meta.sign in canonical json,
+// clients compute the same leaf from the meta data they got.
+func LogLeaf(meta, metaSign []byte) ([]byte, error) {
+	metaSum := sha256.Sum256(meta)
+	signSum := sha256.Sum256(metaSign)
+
+	return utils.CanonicalJSON(LogEntry{Meta: hex.EncodeToString(metaSum[:]), MetaSign: hex.EncodeToString(signSum[:])})
+}
+
+// GetTreeHead provides the signed tree head bytes of the transparency log
+func (us *UpdateService) GetTreeHead() ([]byte, error) {
+	return us.GetStorage().Get(us.logKey(defaultTreeHeadFileName))
+}
+
+// GetLogEntry provides the leaf data of the transparency log at an index
+func (us *UpdateService) GetLogEntry(index int64) ([]byte, error) {
+	return us.GetStorage().Get(us.logEntryKey(index))
+}
+
+// GetInclusionProof proves a leaf hash is in the log of a size, the current
+// size if 'treeSize' is not positive
+func (us *UpdateService) GetInclusionProof(leafHash []byte, treeSize int64) (utils.InclusionProof, error) {
+	leafHashes, err := us.logLeafHashes(treeSize)
+	if err != nil {
+		return utils.InclusionProof{}, err
+	}
+
+	index, err := us.logIndex(leafHash, leafHashes)
+	if err != nil {
+		return utils.InclusionProof{}, err
+	}
+	if index < 0 || index >= int64(len(leafHashes)) {
+		return utils.InclusionProof{}, fmt.Errorf("Cannot find the leaf %x in the log of size %d", leafHash, len(leafHashes))
+	}
+	path, err := utils.MerkleInclusionProof(leafHashes, index)
+	if err != nil {
+		return utils.InclusionProof{}, err
+	}
+
+	return utils.InclusionProof{LeafIndex: index, TreeSize: int64(len(leafHashes)), AuditPath: path}, nil
+}
+
+// GetConsistencyProof proves the log of size 'first' is a prefix of the log of size 'second'
+func (us *UpdateService) GetConsistencyProof(first, second int64) (utils.ConsistencyProof, error) {
+	if second <= 0 {
+		return utils.ConsistencyProof{}, fmt.Errorf("Invalid tree size: %d", second)
+	}
+	leafHashes, err := us.logLeafHashes(second)
+	if err != nil {
+		return utils.ConsistencyProof{}, err
+	}
+
+	proof, err := utils.MerkleConsistencyProof(leafHashes, first)
+	if err != nil {
+		return utils.ConsistencyProof{}, err
+	}
+
+	return utils.ConsistencyProof{First: first, Second: second, Proof: proof}, nil
+}
+
+// appendLog appends a version of meta.json and meta.sign to the transparency
+// log and signs the new tree head by the key of the repository
+func (us *UpdateService) appendLog(meta, metaSign []byte) error {
+	leaf, err := LogLeaf(meta, metaSign)
+	if err != nil {
+		return err
+	}
+
+	unlock := us.lockLog()
+	defer unlock()
+
+	store := us.GetStorage()
+	size, err := us.logSize()
+	if err != nil {
+		return err
+	}
+	// the index could be taken by another process
+	for {
+		_, err = store.PutIfAbsent(us.logEntryKey(size), leaf)
+		if err != storage.ErrorsAlreadyExist {
+			break
+		}
+		size++
+	}
+	if err != nil {
+		return err
+	}
+
+	leafHashes, err := us.logLeafHashes(size + 1)
+	if err != nil {
+		return err
+	}
+	if err := us.saveLeafHashes(leafHashes); err != nil {
+		return err
+	}
+	leafHash := leafHashes[size]
+	if _, err := store.PutIfAbsent(us.logIndexKey(leafHash), []byte(strconv.FormatInt(size, 10))); err != nil && err != storage.ErrorsAlreadyExist {
+		return err
+	}
+
+	return us.saveTreeHead(utils.TreeHead{TreeSize: size + 1, RootHash: utils.MerkleRootHash(leafHashes), Timestamp: time.Now().UTC()})
+}
+
+func (us *UpdateService) saveTreeHead(th utils.TreeHead) error {
+	km := us.GetKM()
+	if km == nil {
+		return keymanager.ErrorsKMNotSupported
+	}
+
+	payload, err := utils.CanonicalJSON(th)
+	if err != nil {
+		return err
+	}
+	a := us.appliance()
+	sth := utils.SignedTreeHead{Signed: th, Signatures: utils.NewSignatureEnvelope(utils.TreeHeadPayloadType)}
+	sig, err := km.Sign(a, sth.Signatures.PAE(payload))
+	if err != nil {
+		return err
+	}
+	pubBytes, err := km.GetPublicKey(a)
+	if err != nil {
+		return err
+	}
+	if err := sth.Signatures.AddSignature(pubBytes, sig); err != nil {
+		return err
+	}
+	content, err := json.Marshal(sth)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.GetStorage().Put(us.logKey(defaultTreeHeadFileName), content)
+	return err
+}
+
+// logSize counts the entries of the log from the size of the tree head
+func (us *UpdateService) logSize() (int64, error) {
+	var size int64
+	if data, err := us.GetTreeHead(); err == nil {
+		if sth, err := utils.ParseSignedTreeHead(data); err == nil {
+			size = sth.Signed.TreeSize
+		}
+	}
+
+	store := us.GetStorage()
+	for {
+		_, err := store.Get(us.logEntryKey(size))
+		if err == storage.ErrorsNotFound {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+		size++
+	}
+}
+
+// logLeafHashes loads the leaf hashes of the first 'treeSize' entries, all the
+// entries of the current tree head if 'treeSize' is not positive.
+// The hashes are persisted by appendLog, only the entries appended after them are read.
+func (us *UpdateService) logLeafHashes(treeSize int64) ([][]byte, error) {
+	if treeSize <= 0 {
+		data, err := us.GetTreeHead()
+		if err != nil {
+			return nil, err
+		}
+		sth, err := utils.ParseSignedTreeHead(data)
+		if err != nil {
+			return nil, err
+		}
+		treeSize = sth.Signed.TreeSize
+	}
+
+	store := us.GetStorage()
+	var leafHashes [][]byte
+	if data, err := store.Get(us.logKey(defaultLeafHashesFileName)); err == nil {
+		for i := 0; i+sha256.Size <= len(data) && int64(len(leafHashes)) < treeSize; i += sha256.Size {
+			leafHashes = append(leafHashes, data[i:i+sha256.Size])
+		}
+	} else if err != storage.ErrorsNotFound {
+		return nil, err
+	}
+	for i := int64(len(leafHashes)); i < treeSize; i++ {
+		leaf, err := store.Get(us.logEntryKey(i))
+		if err == storage.ErrorsNotFound {
+			return nil, fmt.Errorf("Tree size %d is bigger than the log size %d", treeSize, i)
+		} else if err != nil {
+			return nil, err
+		}
+		leafHashes = append(leafHashes, utils.MerkleLeafHash(leaf))
+	}
+
+	return leafHashes, nil
+}
+
+// saveLeafHashes persists the leaf hashes of the log, a shorter list never replaces a longer one
+func (us *UpdateService) saveLeafHashes(leafHashes [][]byte) error {
+	store := us.GetStorage()
+	key := us.logKey(defaultLeafHashesFileName)
+	if data, err := store.Get(key); err == nil && len(data) >= len(leafHashes)*sha256.Size {
+		return nil
+	}
+
+	_, err := store.Put(key, bytes.Join(leafHashes, nil))
+	return err
+}
+
+// logIndex finds the first index of a leaf hash, logs without the index are searched
+func (us *UpdateService) logIndex(leafHash []byte, leafHashes [][]byte) (int64, error) {
+	data, err := us.GetStorage().Get(us.logIndexKey(leafHash))
+	if err == nil {
+		return strconv.ParseInt(string(data), 10, 64)
+	} else if err != storage.ErrorsNotFound {
+		return 0, err
+	}
+
+	for i := range leafHashes {
+		if bytes.Equal(leafHashes[i], leafHash) {
+			return int64(i), nil
+		}
+	}
+	return -1, nil
+}
+
+func (us *UpdateService) lockLog() func() {
+	name := us.storageURI + "/" + us.logKey("")
+
+	logLocksLock.Lock()
+	lock, ok := logLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		logLocks[name] = lock
+	}
+	logLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (us *UpdateService) logKey(name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", us.Proto, us.Version, us.Namespace, us.Repository, name)
+}
+
+func (us *UpdateService) logIndexKey(leafHash []byte) string {
+	return us.logKey(fmt.Sprintf("%s/%x", defaultLogIndexDir, leafHash))
+}
+
+func (us *UpdateService) logEntryKey(index int64) string {
+	return us.logKey(fmt.Sprintf("%s/%d", defaultLogEntriesDir, index))
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "us-test-")
+	assert.Nil(t, err, "Fail to create a temp dir")
+	defer os.RemoveAll(tmpPath)
+
+	us, _ := NewUpdateService(tmpPath, tmpPath, "peruser", "p", "v", "n", "r")
+	pubBytes, _ := us.getPublicKey()
+
+	var heads []utils.TreeHead
+	for _, fn := range []string{"fn0", "fn1", "fn2"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		assert.Nil(t, us.Put(item), "Fail to put an item")
+
+		data, err := us.GetTreeHead()
+		assert.Nil(t, err, "Fail to get the tree head")
+		sth, err := utils.ParseSignedTreeHead(data)
+		assert.Nil(t, err)
+		assert.Nil(t, sth.Verify(pubBytes), "Tree head should be signed by the repository key")
+		role, _ := us.GetRole()
+		assert.Nil(t, sth.VerifyRole(role), "Tree head should be signed by a key of the role")
+		heads = append(heads, sth.Signed)
+	}
+	// NewUpdateService logs the empty repository
+	assert.Equal(t, int64(4), heads[2].TreeSize, "Every saved meta data should be logged")
+
+	// clients compute the leaf from the served meta data
+	meta, _ := us.GetMeta()
+	metaSign, _ := us.GetMetaSign()
+	leaf, _ := LogLeaf(meta, metaSign)
+	proof, err := us.GetInclusionProof(utils.MerkleLeafHash(leaf), 0)
+	assert.Nil(t, err, "Fail to get the inclusion proof of the served meta data")
+	assert.Equal(t, int64(3), proof.LeafIndex)
+	assert.Nil(t, utils.VerifyInclusion(utils.MerkleLeafHash(leaf), proof.LeafIndex, proof.TreeSize, proof.AuditPath, heads[2].RootHash))
+	_, err = us.GetInclusionProof(utils.MerkleLeafHash(leaf), 3)
+	assert.NotNil(t, err, "Leaf should not be in an older tree")
+
+	// the leaf hashes are persisted, logs without them are read from the entries
+	hashes, _ := us.logLeafHashes(0)
+	assert.Nil(t, us.GetStorage().Delete(us.logKey(defaultLeafHashesFileName)))
+	assert.Nil(t, us.GetStorage().Delete(us.logIndexKey(utils.MerkleLeafHash(leaf))))
+	reloaded, err := us.logLeafHashes(0)
+	assert.Nil(t, err, "Fail to load the leaf hashes from the entries")
+	assert.Equal(t, hashes, reloaded)
+	proof, err = us.GetInclusionProof(utils.MerkleLeafHash(leaf), 0)
+	assert.Nil(t, err, "Fail to find a leaf without the index")
+	assert.Equal(t, int64(3), proof.LeafIndex)
+
+	consistency, err := us.GetConsistencyProof(heads[0].TreeSize, heads[2].TreeSize)
+	assert.Nil(t, err, "Fail to get the consistency proof")
+	assert.Nil(t, utils.VerifyConsistency(heads[0].TreeSize, heads[2].TreeSize, heads[0].RootHash, heads[2].RootHash, consistency.Proof))
+	_, err = us.GetConsistencyProof(1, 5)
+	assert.NotNil(t, err, "Should not prove a tree bigger than the log")
+
+	// re-signing is a new version of meta.sign
+	assert.Nil(t, us.Resign())
+	data, _ := us.GetTreeHead()
+	sth, _ := utils.ParseSignedTreeHead(data)
+	assert.Equal(t, int64(5), sth.Signed.TreeSize, "Resign should be logged")
+	entry, err := us.GetLogEntry(4)
+	assert.Nil(t, err, "Fail to get a log entry")
+	metaSign, _ = us.GetMetaSign()
+	leaf, _ = LogLeaf(meta, metaSign)
+	assert.Equal(t, leaf, entry)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/liangchenye/update-service/utils"
+)
+
+// LatestVersion is the constraint resolving the highest release of a name
+const LatestVersion = "latest"
+
+// ListVersions lists the semantic versions of a name in ascending order,
+// items tagged by other strings and hidden items are skipped
+func (us *UpdateService) ListVersions(name string) []string {
+	var versions []utils.SemVer
+	for _, item := range us.Items {
+		if n, tag := utils.SplitFullName(item.FullName); n == name && !item.Hidden {
+			if v, err := utils.ParseSemVer(tag); err == nil {
+				versions = append(versions, v)
+			}
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Compare(versions[j]) < 0 })
+
+	list := []string{}
+	for _, v := range versions {
+		list = append(list, v.String())
+	}
+	return list
+}
+
+// Resolve gets the item of a name by a version constraint, only among the items
+// of a release channel if 'channel' is not empty, see ResolveVersion
+func (us *UpdateService) Resolve(name, constraint, channel string) (UpdateServiceItem, error) {
+	if channel != "" {
+		return ResolveVersion(us.channelItems(channel), name, constraint)
+	}
+	return ResolveVersion(us.Items, name, constraint)
+}
+
+// ResolveVersion gets the item of a name by a version constraint like '^1.4', '~1.2'
+// or '>=2.0 <3'. Items are tagged by 'name:version' and the highest version satisfying
+// the constraint wins. A tag equal to the constraint, for example 'name:stable', is
+// taken as it is, and 'latest' falls back to the untagged 'name' if there is no version.
+// Hidden items are only resolved by their tags.
+func ResolveVersion(items []UpdateServiceItem, name, constraint string) (UpdateServiceItem, error) {
+	if constraint == "" {
+		constraint = LatestVersion
+	}
+
+	for _, item := range items {
+		if item.FullName == name+":"+constraint {
+			return item, nil
+		}
+	}
+
+	c, err := utils.ParseConstraint(constraint)
+	if err != nil {
+		return UpdateServiceItem{}, err
+	}
+
+	var versions []utils.SemVer
+	var candidates []UpdateServiceItem
+	for _, item := range items {
+		if n, tag := utils.SplitFullName(item.FullName); n == name && !item.Hidden {
+			if v, err := utils.ParseSemVer(tag); err == nil {
+				versions = append(versions, v)
+				candidates = append(candidates, item)
+			}
+		}
+	}
+	if i := c.Latest(versions); i >= 0 {
+		return candidates[i], nil
+	}
+
+	if constraint == LatestVersion {
+		for _, item := range items {
+			if item.FullName == name && !item.Hidden {
+				return item, nil
+			}
+		}
+	}
+
+	return UpdateServiceItem{}, fmt.Errorf("Cannot find a version of %s satisfying '%s'", name, constraint)
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveVersion(t *testing.T) {
+	var items []UpdateServiceItem
+	for _, fn := range []string{"app:1.4.0", "app:1.10.1", "app:2.0.0-rc.1", "app:1.9.3", "app:stable", "other:3.0.0", "plain"} {
+		item, _ := NewUpdateServiceItem(fn, []string{"sha-" + fn})
+		items = append(items, item)
+	}
+	us := UpdateService{Items: items}
+
+	assert.Equal(t, []string{"1.4.0", "1.9.3", "1.10.1", "2.0.0-rc.1"}, us.ListVersions("app"), "Fail to list the versions in order")
+	assert.Equal(t, []string{}, us.ListVersions("none"))
+
+	cases := []struct {
+		name       string
+		constraint string
+		expected   string
+	}{
+		{name: "app", constraint: "", expected: "app:1.10.1"},
+		{name: "app", constraint: "latest", expected: "app:1.10.1"},
+		{name: "app", constraint: "^1.4", expected: "app:1.10.1"},
+		{name: "app", constraint: "~1.9", expected: "app:1.9.3"},
+		{name: "app", constraint: ">=2.0.0-rc.1", expected: "app:2.0.0-rc.1"},
+		{name: "app", constraint: "1.4.0", expected: "app:1.4.0"},
+		{name: "app", constraint: "stable", expected: "app:stable"},
+		{name: "plain", constraint: "latest", expected: "plain"},
+		{name: "app", constraint: ">=2.0 <3", expected: ""},
+		{name: "app", constraint: "unknown", expected: ""},
+		{name: "plain", constraint: "^1.0", expected: ""},
+	}
+
+	for _, c := range cases {
+		item, err := us.Resolve(c.name, c.constraint, "")
+		if c.expected == "" {
+			assert.NotNil(t, err, "Should not resolve "+c.name+"@"+c.constraint)
+		} else {
+			assert.Nil(t, err, "Fail to resolve "+c.name+"@"+c.constraint)
+			assert.Equal(t, c.expected, item.FullName, "Fail to resolve "+c.name+"@"+c.constraint)
+		}
+	}
+}
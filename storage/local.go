@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/liangchenye/update-service/utils"
 )
@@ -38,15 +39,14 @@ func (ussl *UpdateServiceStorageLocal) Supported(uri string) bool {
 	return false
 }
 
-// New creates an UpdateServceStorage interface with a local implmentation
+// New creates an UpdateServceStorage interface with a local implmentation,
+// every call gets a new instance so storages of different uris never share a path.
 func (ussl *UpdateServiceStorageLocal) New(uri string) (UpdateServiceStorage, error) {
 	if !ussl.Supported(uri) {
 		return nil, fmt.Errorf("invalid uri set in StorageLocal.New: %s", uri)
 	}
 
-	ussl.Path = uri
-
-	return ussl, nil
+	return &UpdateServiceStorageLocal{Path: uri}, nil
 }
 
 // Get the data of an input key. Key could be "app/v1/namespace/repository/fullname"
@@ -69,10 +69,43 @@ func (ussl *UpdateServiceStorageLocal) Put(key string, content []byte) (string,
 		}
 	}
 
-	err := ioutil.WriteFile(file, content, 0644)
+	// write to a temp file and rename it, readers never get a partial file
+	tmp, err := writeTempFile(file, content)
 	if err != nil {
 		return "", err
 	}
+	defer os.Remove(tmp)
+
+	if err := os.Rename(tmp, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// PutIfAbsent adds a file with a key only if the key does not exist, it
+// returns ErrorsAlreadyExist otherwise.
+func (ussl *UpdateServiceStorageLocal) PutIfAbsent(key string, content []byte) (string, error) {
+	file := filepath.Join(ussl.Path, key)
+	if !utils.IsDirExist(filepath.Dir(file)) {
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// hard links fail if the target exists, so only one of concurrent writers wins
+	tmp, err := writeTempFile(file, content)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, file); err != nil {
+		if os.IsExist(err) {
+			return "", ErrorsAlreadyExist
+		}
+		return "", err
+	}
 	return file, nil
 }
 
@@ -86,5 +119,46 @@ func (ussl *UpdateServiceStorageLocal) Delete(key string) error {
 	return os.Remove(file)
 }
 
+// List lists the files and directories under a key, the temp files of Put are skipped
+func (ussl *UpdateServiceStorageLocal) List(key string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(ussl.Path, key))
+	if os.IsNotExist(err) {
+		return nil, ErrorsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// writeTempFile writes the content to a temp file next to 'file'
+func writeTempFile(file string, content []byte) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
 func (ussl *UpdateServiceStorageLocal) Debug() {
 }
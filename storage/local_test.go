@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,6 +29,12 @@ func TestLocalSupportAndNew(t *testing.T) {
 		_, err := local.New(c.url)
 		assert.Equal(t, c.expected, err == nil, "Fail to create a new local storage interface")
 	}
+
+	a, _ := local.New("/tmp/a")
+	b, _ := local.New("/tmp/b")
+	assert.Equal(t, "/tmp/a", a.(*UpdateServiceStorageLocal).Path, "Storages of different uris should not share a path")
+	assert.Equal(t, "/tmp/b", b.(*UpdateServiceStorageLocal).Path, "Storages of different uris should not share a path")
+	assert.Equal(t, "", local.Path, "New should not change the registered instance")
 }
 
 func TestLocalGet(t *testing.T) {
@@ -55,7 +63,7 @@ func TestLocalPut(t *testing.T) {
 	key := "containerops/official/appA"
 	l, _ := local.New(tmpPath)
 
-	err = l.Put(key, []byte(testData))
+	_, err = l.Put(key, []byte(testData))
 	assert.Nil(t, err, "Fail to put key")
 
 	content, _ := l.Get(key)
@@ -79,3 +87,63 @@ func TestLocalDelete(t *testing.T) {
 	err = l.Delete(key)
 	assert.NotNil(t, err, "Should not be able to delete")
 }
+
+func TestLocalList(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	var local UpdateServiceStorageLocal
+	l, _ := local.New(tmpPath)
+	l.Put("containerops/official/appA", []byte("a"))
+	l.Put("containerops/official/appB", []byte("b"))
+	l.Put("containerops/other/appC", []byte("c"))
+
+	names, err := l.List("containerops")
+	assert.Nil(t, err, "Fail to list")
+	assert.Equal(t, []string{"official", "other"}, names)
+	names, err = l.List("containerops/official")
+	assert.Nil(t, err, "Fail to list")
+	assert.Equal(t, []string{"appA", "appB"}, names, "Temp files should not be listed")
+
+	_, err = l.List("unknown")
+	assert.Equal(t, ErrorsNotFound, err)
+}
+
+func TestLocalPutIfAbsent(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "dus-test-")
+	defer os.RemoveAll(tmpPath)
+	assert.Nil(t, err, "Fail to create temp dir")
+
+	var local UpdateServiceStorageLocal
+	key := "containerops/official/appA"
+	l, _ := local.New(tmpPath)
+
+	// only one of the concurrent writers wins
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var winners []string
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := fmt.Sprintf("content %d", i)
+			_, err := l.PutIfAbsent(key, []byte(data))
+			if err == nil {
+				lock.Lock()
+				winners = append(winners, data)
+				lock.Unlock()
+			} else {
+				assert.Equal(t, ErrorsAlreadyExist, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, len(winners), "Only one writer should put the key")
+	content, _ := l.Get(key)
+	assert.Equal(t, winners[0], string(content), "Fail to keep the content of the winner")
+
+	files, _ := ioutil.ReadDir(filepath.Join(tmpPath, "containerops/official"))
+	assert.Equal(t, 1, len(files), "Temp files should be removed")
+}
@@ -16,7 +16,13 @@ type UpdateServiceStorage interface {
 	Get(key string) ([]byte, error)
 	// Put returns id or local path
 	Put(key string, data []byte) (string, error)
+	// PutIfAbsent puts the data only if the key does not exist, it returns
+	// ErrorsAlreadyExist otherwise. Only one of concurrent callers succeeds.
+	PutIfAbsent(key string, data []byte) (string, error)
 	Delete(key string) error
+	// List lists the names of the direct children of a key, ErrorsNotFound
+	// if there is nothing under it
+	List(key string) ([]string, error)
 	Debug()
 }
 
@@ -28,6 +34,8 @@ var (
 	ErrorsNotSupported = errors.New("storage type is not supported")
 	// ErrorsNotFound occurs if cannot find a key value
 	ErrorsNotFound = errors.New("cannot find the value of the key")
+	// ErrorsAlreadyExist occurs if a key exists when it should not
+	ErrorsAlreadyExist = errors.New("the key already exists")
 )
 
 // RegisterStorage provides a way to dynamically register an implementation of a
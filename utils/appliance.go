@@ -1,7 +1,37 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrorsInvalidFullName occurs when a full name breaks the naming rules of its protocol
+	ErrorsInvalidFullName = errors.New("invalid full name")
+
+	// KnownOS are the operating systems of the 'app' appliances, named as GOOS
+	KnownOS = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js",
+		"linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows"}
+	// KnownArch are the architectures of the 'app' appliances, named as GOARCH,
+	// 'armv5', 'armv6' and 'armv7' are the variants of 'arm'
+	KnownArch = []string{"386", "amd64", "arm", "armv5", "armv6", "armv7", "arm64", "loong64",
+		"mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le", "riscv64", "s390x", "wasm"}
+
+	// archFallbacks are the architectures whose binaries run on an architecture, best first
+	archFallbacks = map[string][]string{
+		"amd64": {"386"},
+		"arm64": {"armv7", "armv6", "armv5", "arm"},
+		"armv7": {"armv6", "armv5", "arm"},
+		"armv6": {"armv5", "arm"},
+		"armv5": {"arm"},
+		"arm":   {"armv5"},
+	}
+
+	// nameRegexp forbids '/', '..' and ':' in names, which are parts of storage keys
+	nameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+)*$`)
+	tagRegexp  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._+-]*$`)
 )
 
 type Appliance struct {
@@ -36,3 +66,71 @@ func (a *Appliance) FullName() string {
 
 	return val
 }
+
+// SplitFullName splits a full name to the name and the tag, the tag is empty
+// if there is none, for example 'os-arch-name:1.0' is split to 'os-arch-name' and '1.0'
+func SplitFullName(fullname string) (string, string) {
+	if i := strings.LastIndex(fullname, ":"); i > 0 {
+		return fullname[:i], fullname[i+1:]
+	}
+	return fullname, ""
+}
+
+// ParseAppliance parses a full name made by FullName, 'os-arch-name[:tag]' of the
+// 'app' protocol and 'name[:tag]' of the others. The OS and the architecture should
+// be known, a name only has letters, digits, '.', '_' and '-', and the tag is empty
+// if there is none.
+func ParseAppliance(proto, fullname string) (Appliance, error) {
+	a := Appliance{Proto: proto}
+	name, tag := SplitFullName(fullname)
+	if strings.Contains(fullname, ":") && !tagRegexp.MatchString(tag) {
+		return Appliance{}, fmt.Errorf("%v: '%s', invalid tag '%s'", ErrorsInvalidFullName, fullname, tag)
+	}
+	a.Tag = tag
+
+	if proto == "app" {
+		parts := strings.SplitN(name, "-", 3)
+		if len(parts) != 3 {
+			return Appliance{}, fmt.Errorf("%v: '%s', an app should be named as 'os-arch-name[:tag]'", ErrorsInvalidFullName, fullname)
+		}
+		if !IsKnownOS(parts[0]) {
+			return Appliance{}, fmt.Errorf("%v: '%s', unknown OS '%s', it should be one of %s", ErrorsInvalidFullName, fullname, parts[0], strings.Join(KnownOS, ","))
+		}
+		if !IsKnownArch(parts[1]) {
+			return Appliance{}, fmt.Errorf("%v: '%s', unknown architecture '%s', it should be one of %s", ErrorsInvalidFullName, fullname, parts[1], strings.Join(KnownArch, ","))
+		}
+		a.OS, a.Arch, name = parts[0], parts[1], parts[2]
+	}
+	if !nameRegexp.MatchString(name) {
+		return Appliance{}, fmt.Errorf("%v: '%s', a name should only have letters, digits, '.', '_' and '-'", ErrorsInvalidFullName, fullname)
+	}
+	a.Name = name
+
+	return a, nil
+}
+
+// IsKnownOS tells if an operating system is in KnownOS
+func IsKnownOS(os string) bool {
+	return contains(KnownOS, os)
+}
+
+// IsKnownArch tells if an architecture is in KnownArch
+func IsKnownArch(arch string) bool {
+	return contains(KnownArch, arch)
+}
+
+// CompatibleArchs lists the architectures whose binaries run on an architecture, the
+// architecture itself first and then its fallbacks, for example 'amd64' and '386'.
+// The variant of 'arm' is unknown by 'arm', so only the oldest variant is taken.
+func CompatibleArchs(arch string) []string {
+	return append([]string{arch}, archFallbacks[arch]...)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
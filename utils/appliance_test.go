@@ -26,3 +26,49 @@ func TestApplianceFullName(t *testing.T) {
 		assert.Equal(t, c.expected, c.a.FullName(), "Fail to get correct fullname")
 	}
 }
+
+func TestSplitFullName(t *testing.T) {
+	name, tag := SplitFullName("os-arch-name:1.0")
+	assert.Equal(t, "os-arch-name", name)
+	assert.Equal(t, "1.0", tag)
+	name, tag = SplitFullName("name")
+	assert.Equal(t, "name", name)
+	assert.Equal(t, "", tag)
+}
+
+func TestParseAppliance(t *testing.T) {
+	a, err := ParseAppliance("app", "linux-arm64-my-app:1.0.0-rc.1")
+	assert.Nil(t, err)
+	assert.Equal(t, Appliance{Proto: "app", OS: "linux", Arch: "arm64", Name: "my-app", Tag: "1.0.0-rc.1"}, a)
+	assert.Equal(t, "linux-arm64-my-app:1.0.0-rc.1", a.FullName(), "ParseAppliance should be the counterpart of FullName")
+
+	a, err = ParseAppliance("vm", "disk.qcow2")
+	assert.Nil(t, err)
+	assert.Equal(t, "disk.qcow2", a.Name)
+	assert.Equal(t, "", a.Tag)
+
+	cases := []struct {
+		proto    string
+		fullname string
+	}{
+		{"app", "app:1.0"},
+		{"app", "linx-amd64-app"},
+		{"app", "linux-x64-app"},
+		{"app", "linux-amd64-../meta.json"},
+		{"app", "linux-amd64-a/b"},
+		{"vm", ""},
+		{"vm", ".hidden"},
+		{"vm", "name:"},
+		{"vm", "name:a/b"},
+	}
+	for _, c := range cases {
+		_, err := ParseAppliance(c.proto, c.fullname)
+		assert.NotNil(t, err, "Should not parse an invalid full name: "+c.fullname)
+	}
+}
+
+func TestCompatibleArchs(t *testing.T) {
+	assert.Equal(t, []string{"amd64", "386"}, CompatibleArchs("amd64"))
+	assert.Equal(t, []string{"arm64", "armv7", "armv6", "armv5", "arm"}, CompatibleArchs("arm64"))
+	assert.Equal(t, []string{"s390x"}, CompatibleArchs("s390x"))
+}
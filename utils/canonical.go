@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+var (
+	// ErrorsCanonicalNumber occurs when a number is not an integer, which canonical json does not allow
+	ErrorsCanonicalNumber = errors.New("canonical json only supports integer numbers")
+
+	canonicalIntegerRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+)
+
+// CanonicalJSON marshals an object to canonical json, following the OLPC/TUF rules:
+//   - object keys are sorted by their unicode code points
+//   - there is no insignificant whitespace
+//   - numbers must be integers
+//   - strings are utf-8 and only '"' and '\' are escaped, except that control
+//     characters are escaped as '\u00XX' to keep the output valid json
+//
+// The same object always has the same bytes, so it is the payload used in signing and verifying.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return CanonicalizeJSON(data)
+}
+
+// CanonicalizeJSON converts json data to its canonical form
+func CanonicalizeJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("Invalid trailing data after the json value")
+	}
+
+	var buf bytes.Buffer
+	if err := encodeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if t {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		if !canonicalIntegerRegexp.MatchString(string(t)) {
+			return fmt.Errorf("%v: %s", ErrorsCanonicalNumber, t)
+		}
+		if t == "-0" {
+			t = "0"
+		}
+		buf.WriteString(string(t))
+	case string:
+		return encodeCanonicalString(buf, t)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		// byte order of utf-8 strings is the order of their code points
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeCanonicalString(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeCanonical(buf, t[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("Unsupported type %T in canonical json", v)
+	}
+
+	return nil
+}
+
+func encodeCanonicalString(buf *bytes.Buffer, s string) error {
+	if !utf8.ValidString(s) {
+		return errors.New("canonical json strings should be valid utf-8")
+	}
+
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(s[i])
+		case s[i] < 0x20:
+			fmt.Fprintf(buf, "\\u%04x", s[i])
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	buf.WriteByte('"')
+
+	return nil
+}
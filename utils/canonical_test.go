@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalizeJSONGolden(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(testDataDir, "canonical", "*.json"))
+	assert.NotEqual(t, 0, len(files), "Fail to find golden files")
+
+	for _, file := range files {
+		input, _ := ioutil.ReadFile(file)
+		golden, err := ioutil.ReadFile(strings.TrimSuffix(file, ".json") + ".canonical")
+		assert.Nil(t, err, "Fail to read golden file of %s", file)
+
+		data, err := CanonicalizeJSON(input)
+		assert.Nil(t, err, "Fail to canonicalize %s", file)
+		assert.Equal(t, string(golden), string(data), "Fail to get canonical json of %s", file)
+
+		again, _ := CanonicalizeJSON(data)
+		assert.Equal(t, data, again, "Canonical json of %s should be stable", file)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	type item struct {
+		Name string
+		Size int
+		Tags map[string]string
+	}
+
+	data, err := CanonicalJSON(item{Name: "a&b", Size: 3, Tags: map[string]string{"y": "1", "x": "2"}})
+	assert.Nil(t, err, "Fail to marshal canonical json")
+	assert.Equal(t, `{"Name":"a&b","Size":3,"Tags":{"x":"2","y":"1"}}`, string(data))
+
+	cases := []string{`1.5`, `{"a":1e3}`, `{"a":1} {}`, `{invalid`}
+	for _, c := range cases {
+		_, err := CanonicalizeJSON([]byte(c))
+		assert.NotNil(t, err, "Should not canonicalize %s", c)
+	}
+}
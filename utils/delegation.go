@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+)
+
+const (
+	// DelegationsPayloadType is the payload type of the signatures of the delegations of a repository
+	DelegationsPayloadType = "application/vnd.update-service.delegations+json"
+	// TargetsPayloadType is the payload type of the signatures of the targets of a delegation
+	TargetsPayloadType = "application/vnd.update-service.targets+json"
+)
+
+// Delegation trusts a role to sign the items whose full names match any of
+// its path patterns, for example 'linux/amd64/*'. The patterns are matched
+// by path.Match, so '*' does not match '/'.
+type Delegation struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+	Role  Role     `json:"role"`
+}
+
+// Delegations lists the delegations of a repository signed by its owner, the
+// version grows with every change so clients could refuse an older list.
+// The first delegation matching a full name is the one to verify its item.
+type Delegations struct {
+	Version     int          `json:"version"`
+	Delegations []Delegation `json:"delegations"`
+}
+
+// SignedDelegations is a delegation list with the signatures over its canonical json
+type SignedDelegations struct {
+	Signed     Delegations       `json:"signed"`
+	Signatures SignatureEnvelope `json:"signatures"`
+}
+
+// DelegatedTargets lists the items signed by the role of a delegation,
+// 'Targets' maps the full names to the hashes of the items.
+type DelegatedTargets struct {
+	Name    string              `json:"name"`
+	Version int                 `json:"version"`
+	Targets map[string][]string `json:"targets"`
+}
+
+// SignedDelegatedTargets is a delegated target list with the signatures over its canonical json
+type SignedDelegatedTargets struct {
+	Signed     DelegatedTargets  `json:"signed"`
+	Signatures SignatureEnvelope `json:"signatures"`
+}
+
+// NewDelegation creates a delegation trusting 'threshold' of the public keys to
+// sign the items matching the path patterns
+func NewDelegation(name string, paths []string, threshold int, pubKeys ...[]byte) (Delegation, error) {
+	role, err := NewRole(threshold, pubKeys...)
+	if err != nil {
+		return Delegation{}, err
+	}
+
+	d := Delegation{Name: name, Paths: paths, Role: role}
+	if err := d.IsValid(); err != nil {
+		return Delegation{}, err
+	}
+
+	return d, nil
+}
+
+// IsValid checks the name, the path patterns and the role of a delegation
+func (d *Delegation) IsValid() error {
+	if d.Name == "" || strings.ContainsAny(d.Name, "/\\") {
+		return fmt.Errorf("Invalid delegation name: '%s'", d.Name)
+	}
+	if len(d.Paths) == 0 {
+		return errors.New("Delegation should have at least one path pattern")
+	}
+	for _, p := range d.Paths {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return fmt.Errorf("Invalid delegation path pattern: '%s'", p)
+		}
+	}
+
+	return d.Role.IsValid()
+}
+
+// Matches checks if a full name matches any path pattern of a delegation
+func (d *Delegation) Matches(fullname string) bool {
+	for _, p := range d.Paths {
+		if ok, _ := path.Match(p, fullname); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Set adds a delegation, or replaces the one with the same name
+func (ds *Delegations) Set(d Delegation) error {
+	if err := d.IsValid(); err != nil {
+		return err
+	}
+
+	for i := range ds.Delegations {
+		if ds.Delegations[i].Name == d.Name {
+			ds.Delegations[i] = d
+			ds.Version++
+			return nil
+		}
+	}
+	ds.Delegations = append(ds.Delegations, d)
+	ds.Version++
+
+	return nil
+}
+
+// Remove removes a delegation by name
+func (ds *Delegations) Remove(name string) error {
+	for i := range ds.Delegations {
+		if ds.Delegations[i].Name == name {
+			ds.Delegations = append(ds.Delegations[:i], ds.Delegations[i+1:]...)
+			ds.Version++
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Cannot find the delegation: %s", name)
+}
+
+// Get gets a delegation by name
+func (ds *Delegations) Get(name string) (Delegation, bool) {
+	for _, d := range ds.Delegations {
+		if d.Name == name {
+			return d, true
+		}
+	}
+
+	return Delegation{}, false
+}
+
+// Find gets the first delegation matching a full name
+func (ds *Delegations) Find(fullname string) (Delegation, bool) {
+	for _, d := range ds.Delegations {
+		if d.Matches(fullname) {
+			return d, true
+		}
+	}
+
+	return Delegation{}, false
+}
+
+// ParseSignedDelegations loads a signed delegation list
+func ParseSignedDelegations(data []byte) (SignedDelegations, error) {
+	var sd SignedDelegations
+	if err := json.Unmarshal(data, &sd); err != nil {
+		return SignedDelegations{}, err
+	}
+	if len(sd.Signatures.Signatures) == 0 {
+		return SignedDelegations{}, errors.New("delegation list should have at least one signature")
+	}
+
+	return sd, nil
+}
+
+// Verify verifies the delegation list by the signature of the public key of the repository
+func (sd *SignedDelegations) Verify(pubBytes []byte) error {
+	payload, err := CanonicalJSON(sd.Signed)
+	if err != nil {
+		return err
+	}
+
+	return sd.Signatures.Verify(pubBytes, DelegationsPayloadType, payload)
+}
+
+// VerifyRole verifies the delegation list by the threshold of the role of the repository
+func (sd *SignedDelegations) VerifyRole(role Role) error {
+	payload, err := CanonicalJSON(sd.Signed)
+	if err != nil {
+		return err
+	}
+
+	return role.VerifyThreshold(DelegationsPayloadType, payload, sd.Signatures)
+}
+
+// CheckUpdate checks that a delegated target list could replace a cached one, it
+// should be of the same delegation and not be older
+func (dt *DelegatedTargets) CheckUpdate(cached DelegatedTargets) error {
+	if cached.Name != "" && dt.Name != cached.Name {
+		return fmt.Errorf("Delegated targets of '%s' could not replace the ones of '%s'", dt.Name, cached.Name)
+	}
+	if dt.Version < cached.Version {
+		return fmt.Errorf("Delegated targets version %d of '%s' is older than the cached version %d", dt.Version, dt.Name, cached.Version)
+	}
+
+	return nil
+}
+
+// ParseSignedDelegatedTargets loads a signed delegated target list
+func ParseSignedDelegatedTargets(data []byte) (SignedDelegatedTargets, error) {
+	var st SignedDelegatedTargets
+	if err := json.Unmarshal(data, &st); err != nil {
+		return SignedDelegatedTargets{}, err
+	}
+	if len(st.Signatures.Signatures) == 0 {
+		return SignedDelegatedTargets{}, errors.New("delegated target list should have at least one signature")
+	}
+
+	return st, nil
+}
+
+// Verify verifies the delegated target list by the threshold of the role of a
+// delegation, the targets should all be within the paths of the delegation
+func (st *SignedDelegatedTargets) Verify(d Delegation) error {
+	if st.Signed.Name != d.Name {
+		return fmt.Errorf("Delegated targets of '%s' could not be verified by the delegation '%s'", st.Signed.Name, d.Name)
+	}
+	for fullname := range st.Signed.Targets {
+		if !d.Matches(fullname) {
+			return fmt.Errorf("Delegation '%s' is not trusted to sign '%s'", d.Name, fullname)
+		}
+	}
+
+	payload, err := CanonicalJSON(st.Signed)
+	if err != nil {
+		return err
+	}
+
+	return d.Role.VerifyThreshold(TargetsPayloadType, payload, st.Signatures)
+}
+
+// VerifyItem checks that an item of a full name and hashes is signed in the targets
+func (dt *DelegatedTargets) VerifyItem(fullname string, shas []string) error {
+	signed, ok := dt.Targets[fullname]
+	if !ok {
+		return fmt.Errorf("'%s' is not signed by the delegation '%s'", fullname, dt.Name)
+	}
+	if !reflect.DeepEqual(signed, shas) {
+		return fmt.Errorf("Hashes of '%s' do not match the ones signed by the delegation '%s'", fullname, dt.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelegations(t *testing.T) {
+	_, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+
+	for _, c := range []struct {
+		name     string
+		paths    []string
+		expected bool
+	}{
+		{name: "linux", paths: []string{"linux/amd64/*"}, expected: true},
+		{name: "", paths: []string{"linux/*"}, expected: false},
+		{name: "a/b", paths: []string{"linux/*"}, expected: false},
+		{name: "linux", paths: nil, expected: false},
+		{name: "linux", paths: []string{"linux/[*"}, expected: false},
+	} {
+		_, err := NewDelegation(c.name, c.paths, 1, pubBytes)
+		assert.Equal(t, c.expected, err == nil, "Fail to validate the delegation %s %v", c.name, c.paths)
+	}
+	_, err := NewDelegation("linux", []string{"linux/*"}, 2, pubBytes)
+	assert.NotNil(t, err, "Threshold should be reachable by the keys")
+
+	var ds Delegations
+	linux, _ := NewDelegation("linux", []string{"linux/amd64/*"}, 1, pubBytes)
+	all, _ := NewDelegation("all", []string{"*/*/*"}, 1, pubBytes)
+	assert.Nil(t, ds.Set(linux), "Fail to add a delegation")
+	assert.Nil(t, ds.Set(all), "Fail to add a delegation")
+	assert.Nil(t, ds.Set(linux), "Fail to replace a delegation")
+	assert.Equal(t, 3, ds.Version, "Version should grow with every change")
+	assert.Equal(t, 2, len(ds.Delegations))
+
+	d, ok := ds.Find("linux/amd64/app")
+	assert.True(t, ok)
+	assert.Equal(t, "linux", d.Name, "First matching delegation should be found")
+	d, ok = ds.Find("windows/amd64/app")
+	assert.True(t, ok)
+	assert.Equal(t, "all", d.Name)
+	_, ok = ds.Find("linux/amd64/app/1.0")
+	assert.False(t, ok, "'*' should not match '/'")
+
+	assert.Nil(t, ds.Remove("all"), "Fail to remove a delegation")
+	assert.NotNil(t, ds.Remove("all"), "Should not remove a delegation twice")
+	_, ok = ds.Find("windows/amd64/app")
+	assert.False(t, ok)
+}
+
+func TestSignedDelegatedTargets(t *testing.T) {
+	privBytes, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+	otherPriv, otherPub, _ := GenerateKeyPair(KeyTypeEd25519)
+	d, _ := NewDelegation("linux", []string{"linux/*"}, 1, pubBytes)
+
+	sign := func(priv, pub []byte, dt DelegatedTargets) SignedDelegatedTargets {
+		payload, _ := CanonicalJSON(dt)
+		st := SignedDelegatedTargets{Signed: dt, Signatures: NewSignatureEnvelope(TargetsPayloadType)}
+		sig, _ := SHA256Sign(priv, st.Signatures.PAE(payload))
+		st.Signatures.AddSignature(pub, sig)
+		return st
+	}
+
+	dt := DelegatedTargets{Name: "linux", Version: 1, Targets: map[string][]string{"linux/app": {"sha0"}}}
+	st := sign(privBytes, pubBytes, dt)
+	assert.Nil(t, st.Verify(d), "Fail to verify the delegated targets")
+	assert.Nil(t, st.Signed.VerifyItem("linux/app", []string{"sha0"}), "Fail to verify a delegated item")
+	assert.NotNil(t, st.Signed.VerifyItem("linux/app", []string{"sha1"}), "Should refuse other hashes")
+	assert.NotNil(t, st.Signed.VerifyItem("linux/other", []string{"sha0"}), "Should refuse unsigned items")
+
+	st = sign(otherPriv, otherPub, dt)
+	assert.NotNil(t, st.Verify(d), "Should refuse targets signed by other keys")
+
+	dt.Targets["windows/app"] = []string{"sha1"}
+	st = sign(privBytes, pubBytes, dt)
+	assert.NotNil(t, st.Verify(d), "Should refuse targets out of the delegated paths")
+
+	dt = DelegatedTargets{Name: "windows", Version: 1}
+	st = sign(privBytes, pubBytes, dt)
+	assert.NotNil(t, st.Verify(d), "Should refuse targets of another delegation")
+
+	cached := DelegatedTargets{Name: "linux", Version: 2}
+	assert.NotNil(t, dt.CheckUpdate(cached), "Should refuse the targets of another delegation")
+	dt.Name = "linux"
+	assert.NotNil(t, dt.CheckUpdate(cached), "Should refuse older targets")
+	dt.Version = 2
+	assert.Nil(t, dt.CheckUpdate(cached))
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A delta is the magic followed by a gzip stream of the length of the new
+// content and the ops to build it: copying a range of the old content, or
+// adding new bytes. Ranges are matched by a rolling hash of deltaBlockSize bytes.
+const (
+	deltaMagic     = "USDELTA1"
+	deltaBlockSize = 32
+	deltaHashBase  = 257
+	// deltaChunkSize is the size of the reads of the new content, and the
+	// most bytes added by one op
+	deltaChunkSize = 1 << 20
+
+	deltaOpCopy = 0
+	deltaOpAdd  = 1
+)
+
+var (
+	// ErrorsInvalidDelta occurs when a delta is broken or is not made from the old content
+	ErrorsInvalidDelta = errors.New("invalid delta")
+	// ErrorsDeltaTooLong occurs when a delta builds a content longer than expected
+	ErrorsDeltaTooLong = errors.New("delta builds a content longer than expected")
+)
+
+// MakeDelta makes a binary delta to build 'new' from 'old', see ApplyDelta
+func MakeDelta(old, new []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteDelta(&buf, old, bytes.NewReader(new), int64(len(new))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteDelta writes a delta like MakeDelta, the new content of 'length' bytes
+// is read from 'new' as the delta is made, so only the old content and a window
+// of the new content are kept in memory
+func WriteDelta(dst io.Writer, old []byte, new io.Reader, length int64) error {
+	// the first offset of every block of the old content by its hash
+	blocks := make(map[uint32]int)
+	for off := 0; off+deltaBlockSize <= len(old); off += deltaBlockSize {
+		h := deltaHash(old[off : off+deltaBlockSize])
+		if _, ok := blocks[h]; !ok {
+			blocks[h] = off
+		}
+	}
+
+	if _, err := io.WriteString(dst, deltaMagic); err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(zw)
+	writeUvarint(w, uint64(length))
+
+	// pow is deltaHashBase^(deltaBlockSize-1), to roll the first byte out of the hash
+	pow := uint32(1)
+	for i := 1; i < deltaBlockSize; i++ {
+		pow *= deltaHashBase
+	}
+
+	// buf holds the new content from the first byte not written to the delta
+	var buf []byte
+	var read int64
+	var readErr error
+	chunk := make([]byte, deltaChunkSize)
+	fill := func(n int) bool {
+		for len(buf) < n && readErr == nil {
+			var c int
+			c, readErr = new.Read(chunk)
+			buf = append(buf, chunk[:c]...)
+			read += int64(c)
+		}
+		return len(buf) >= n
+	}
+
+	var h uint32
+	if fill(deltaBlockSize) {
+		h = deltaHash(buf[:deltaBlockSize])
+	}
+	for i := 0; fill(i + deltaBlockSize); {
+		off, ok := blocks[h]
+		if !ok || !bytes.Equal(old[off:off+deltaBlockSize], buf[i:i+deltaBlockSize]) {
+			if fill(i + deltaBlockSize + 1) {
+				h = (h-uint32(buf[i])*pow)*deltaHashBase + uint32(buf[i+deltaBlockSize])
+			}
+			i++
+			// the hash stays the one of buf[i:i+deltaBlockSize]
+			if i >= deltaChunkSize {
+				writeDeltaAdd(w, buf[:i])
+				buf, i = buf[i:], 0
+			}
+			continue
+		}
+
+		start := i
+		for start > 0 && off > 0 && old[off-1] == buf[start-1] {
+			start--
+			off--
+		}
+		if start > 0 {
+			writeDeltaAdd(w, buf[:start])
+		}
+		// the matched bytes are dropped while the match is extended
+		pos, n := off+i-start+deltaBlockSize, i-start+deltaBlockSize
+		buf = buf[i+deltaBlockSize:]
+		for pos < len(old) && fill(1) {
+			k := 0
+			for k < len(buf) && pos+k < len(old) && old[pos+k] == buf[k] {
+				k++
+			}
+			pos, n, buf = pos+k, n+k, buf[k:]
+			if len(buf) > 0 {
+				break
+			}
+		}
+		w.WriteByte(deltaOpCopy)
+		writeUvarint(w, uint64(off))
+		writeUvarint(w, uint64(n))
+
+		i = 0
+		if fill(deltaBlockSize) {
+			h = deltaHash(buf[:deltaBlockSize])
+		}
+	}
+	if len(buf) > 0 {
+		writeDeltaAdd(w, buf)
+	}
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	if read != length {
+		return fmt.Errorf("Fail to make a delta: read %d bytes, expect %d", read, length)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ApplyDelta builds the new content from the old content by a delta made by
+// MakeDelta, a delta of a new content longer than 'maxLength' is refused
+func ApplyDelta(old, delta []byte, maxLength int64) ([]byte, error) {
+	if !bytes.HasPrefix(delta, []byte(deltaMagic)) {
+		return nil, ErrorsInvalidDelta
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(delta[len(deltaMagic):]))
+	if err != nil {
+		return nil, ErrorsInvalidDelta
+	}
+	r := bufio.NewReader(zr)
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrorsInvalidDelta
+	}
+	if maxLength < 0 || length > uint64(maxLength) {
+		return nil, ErrorsDeltaTooLong
+	}
+	ret := make([]byte, 0, length)
+	for uint64(len(ret)) < length {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrorsInvalidDelta
+		}
+		switch op {
+		case deltaOpCopy:
+			off, offErr := binary.ReadUvarint(r)
+			n, nErr := binary.ReadUvarint(r)
+			if offErr != nil || nErr != nil || off > uint64(len(old)) || n > uint64(len(old))-off || n > length-uint64(len(ret)) {
+				return nil, ErrorsInvalidDelta
+			}
+			ret = append(ret, old[off:off+n]...)
+		case deltaOpAdd:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > length-uint64(len(ret)) {
+				return nil, ErrorsInvalidDelta
+			}
+			ret = ret[:len(ret)+int(n)]
+			if _, err := io.ReadFull(r, ret[len(ret)-int(n):]); err != nil {
+				return nil, ErrorsInvalidDelta
+			}
+		default:
+			return nil, ErrorsInvalidDelta
+		}
+	}
+	// nothing should follow the ops
+	if rest, err := ioutil.ReadAll(r); err != nil || len(rest) > 0 {
+		return nil, ErrorsInvalidDelta
+	}
+
+	return ret, nil
+}
+
+func deltaHash(block []byte) uint32 {
+	var h uint32
+	for _, b := range block {
+		h = h*deltaHashBase + uint32(b)
+	}
+	return h
+}
+
+func writeDeltaAdd(w *bufio.Writer, data []byte) {
+	w.WriteByte(deltaOpAdd)
+	writeUvarint(w, uint64(len(data)))
+	w.Write(data)
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], v)])
+}
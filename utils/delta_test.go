@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	old := make([]byte, 256<<10)
+	r.Read(old)
+	// the new version changes some bytes, inserts and removes some ranges
+	new := append([]byte(nil), old[:1000]...)
+	new = append(new, []byte("inserted")...)
+	new = append(new, old[1000:50000]...)
+	new = append(new, old[60000:]...)
+	new[100000] ^= 0xff
+	new = append(new, []byte("appended")...)
+
+	cases := []struct {
+		old []byte
+		new []byte
+	}{
+		{old, new},
+		{old, old},
+		{nil, new},
+		{old, nil},
+		{[]byte("short"), []byte("shorter")},
+	}
+	for _, c := range cases {
+		delta, err := MakeDelta(c.old, c.new)
+		assert.Nil(t, err, "Fail to make a delta")
+		ret, err := ApplyDelta(c.old, delta, int64(len(c.new)))
+		assert.Nil(t, err, "Fail to apply a delta")
+		assert.True(t, bytes.Equal(c.new, ret), "Fail to build the new content by a delta")
+	}
+
+	// the new content is read by small reads, and is longer than a chunk
+	long := make([]byte, 3*deltaChunkSize)
+	r.Read(long)
+	long = append(long, new...)
+	var buf bytes.Buffer
+	assert.Nil(t, WriteDelta(&buf, old, iotest.HalfReader(bytes.NewReader(long)), int64(len(long))))
+	ret, err := ApplyDelta(old, buf.Bytes(), int64(len(long)))
+	assert.Nil(t, err, "Fail to apply a delta")
+	assert.True(t, bytes.Equal(long, ret), "Fail to build the new content by a streamed delta")
+	assert.NotNil(t, WriteDelta(&buf, old, bytes.NewReader(new), int64(len(new))+1), "Should not make a delta of a short read")
+
+	delta, _ := MakeDelta(old, new)
+	assert.True(t, len(delta) < 1024, "A delta of a small change should be small")
+
+	_, err = ApplyDelta(old, delta, int64(len(new)-1))
+	assert.Equal(t, ErrorsDeltaTooLong, err, "Should not apply a delta longer than expected")
+	_, err = ApplyDelta(old[:1000], delta, int64(len(new)))
+	assert.NotNil(t, err, "Should not apply a delta to other content")
+	_, err = ApplyDelta(old, delta[:len(delta)-10], int64(len(new)))
+	assert.NotNil(t, err, "Should not apply a broken delta")
+	_, err = ApplyDelta(old, new, int64(len(new)))
+	assert.NotNil(t, err, "Should not apply a file which is not a delta")
+}
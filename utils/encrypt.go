@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// EncryptedPayloadType is the payload type of an encrypted envelope
+const EncryptedPayloadType = "application/vnd.update-service.encrypted+json"
+
+// The algorithms to wrap the data key of an encrypted envelope, it depends on
+// the key type of the public key.
+const (
+	// WrapAlgRSAPKCS1v15 is the data key wrapped to a legacy 'rsa' key as RSAEncrypt
+	// does, the existing envelopes are unwrapped but no new one is made
+	WrapAlgRSAPKCS1v15 = "rsa-pkcs1v15"
+	// WrapAlgRSAOAEP wraps the data key to a 'rsa' or 'rsa-pss' key by RSA-OAEP with SHA-256
+	WrapAlgRSAOAEP = "rsa-oaep-sha256"
+	// WrapAlgECDHP256 wraps the data key to a 'ecdsa-p256' key by AES-256-GCM with a key
+	// agreed by an ephemeral ECDH key and derived by HKDF-SHA256
+	WrapAlgECDHP256 = "ecdh-es-p256+a256gcm"
+
+	// EncAESGCM encrypts the payload by AES-256-GCM
+	EncAESGCM = "aes-256-gcm"
+
+	dataKeySize = 32
+	ecdhInfo    = "update-service " + WrapAlgECDHP256
+)
+
+var (
+	// ErrorsEncryptionNotSupported occurs when a key type could not wrap a data key
+	ErrorsEncryptionNotSupported = errors.New("key type could not be used for encryption")
+	// ErrorsDecryption occurs when a rsa key could not unwrap a data key, it
+	// never tells which padding fails
+	ErrorsDecryption = errors.New("fail to decrypt by the rsa key")
+)
+
+// EncryptedEnvelope is a payload encrypted by a random AES-GCM data key,
+// the data key is wrapped to a public key and could only be unwrapped by its
+// private key, for example by KeyManager.Decrypt.
+type EncryptedEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	// KeyID is the id of the public key the data key is wrapped to
+	KeyID string `json:"keyid"`
+	// Alg is the algorithm to wrap the data key
+	Alg string `json:"alg"`
+	// Enc is the algorithm to encrypt the payload
+	Enc        string `json:"enc"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypt encrypts a payload by a random data key wrapped to a public key,
+// it returns the json encoded EncryptedEnvelope.
+func Encrypt(pubBytes []byte, payload []byte) ([]byte, error) {
+	keyID, err := KeyID(pubBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, alg, err := WrapKey(pubBytes, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	env := EncryptedEnvelope{PayloadType: EncryptedPayloadType, KeyID: keyID, Alg: alg, Enc: EncAESGCM, WrappedKey: wrapped}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, err
+	}
+	env.Ciphertext = gcm.Seal(nil, env.Nonce, payload, env.additionalData())
+
+	return json.Marshal(env)
+}
+
+// IsEncrypted checks if the data is an encrypted envelope
+func IsEncrypted(data []byte) bool {
+	_, err := ParseEncryptedEnvelope(data)
+	return err == nil
+}
+
+// ParseEncryptedEnvelope loads an encrypted envelope
+func ParseEncryptedEnvelope(data []byte) (EncryptedEnvelope, error) {
+	var env EncryptedEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return EncryptedEnvelope{}, err
+	}
+	if env.PayloadType != EncryptedPayloadType {
+		return EncryptedEnvelope{}, fmt.Errorf("Invalid payload type of an encrypted envelope: %s", env.PayloadType)
+	}
+	if env.Enc != EncAESGCM {
+		return EncryptedEnvelope{}, fmt.Errorf("Unsupported encryption: %s", env.Enc)
+	}
+
+	return env, nil
+}
+
+// Decrypt decrypts the payload, 'unwrap' gets the data key from the wrapped key,
+// for example UnwrapKey with a private key or KeyManager.Decrypt.
+func (env *EncryptedEnvelope) Decrypt(unwrap func(wrapped []byte) ([]byte, error)) ([]byte, error) {
+	dataKey, err := unwrap(env.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to unwrap the data key: %v", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("Invalid nonce of the encrypted envelope")
+	}
+
+	return gcm.Open(nil, env.Nonce, env.Ciphertext, env.additionalData())
+}
+
+// additionalData binds the header of the envelope to the ciphertext
+func (env *EncryptedEnvelope) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%s", env.PayloadType, env.KeyID, env.Alg, env.Enc))
+}
+
+// CheckEncryptionKey checks if a data key could be wrapped to a public key, only
+// 'rsa', 'rsa-pss' and 'ecdsa-p256' keys could, 'ed25519' keys only sign
+func CheckEncryptionKey(pubBytes []byte) error {
+	keyType, err := GetKeyType(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	switch keyType {
+	case KeyTypeRSA, KeyTypeRSAPSS, KeyTypeECDSAP256:
+		return nil
+	}
+	return fmt.Errorf("%v: %s", ErrorsEncryptionNotSupported, keyType)
+}
+
+// WrapKey encrypts a data key to a public key, the algorithm depends on the key type
+func WrapKey(pubBytes []byte, dataKey []byte) ([]byte, string, error) {
+	pubKey, keyType, err := getPublicKey(pubBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch keyType {
+	case KeyTypeRSA, KeyTypeRSAPSS:
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubKey.(*rsa.PublicKey), dataKey, nil)
+		return wrapped, WrapAlgRSAOAEP, err
+	case KeyTypeECDSAP256:
+		wrapped, err := ecdhWrap(pubKey.(*ecdsa.PublicKey), dataKey)
+		return wrapped, WrapAlgECDHP256, err
+	}
+
+	return nil, "", fmt.Errorf("%v: %s", ErrorsEncryptionNotSupported, keyType)
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey. A legacy 'rsa' key also
+// decrypts the existing data wrapped as RSADecrypt does, every failure of a rsa
+// key is ErrorsDecryption so it is not a padding oracle.
+func UnwrapKey(privBytes []byte, wrapped []byte) ([]byte, error) {
+	signer, keyType, err := getSigner(privBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyType {
+	case KeyTypeRSA:
+		privKey := signer.(*rsa.PrivateKey)
+		if dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privKey, wrapped, nil); err == nil {
+			return dataKey, nil
+		}
+		if data, err := rsa.DecryptPKCS1v15(rand.Reader, privKey, wrapped); err == nil {
+			return data, nil
+		}
+		return nil, ErrorsDecryption
+	case KeyTypeRSAPSS:
+		dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, signer.(*rsa.PrivateKey), wrapped, nil)
+		if err != nil {
+			return nil, ErrorsDecryption
+		}
+		return dataKey, nil
+	case KeyTypeECDSAP256:
+		return ecdhUnwrap(signer.(*ecdsa.PrivateKey), wrapped)
+	}
+
+	return nil, fmt.Errorf("%v: %s", ErrorsEncryptionNotSupported, keyType)
+}
+
+// ecdhWrap wraps a data key as 'ephemeral public key | nonce | sealed data key'
+func ecdhWrap(pubKey *ecdsa.PublicKey, dataKey []byte) ([]byte, error) {
+	recipient, err := pubKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralBytes := ephemeral.PublicKey().Bytes()
+	gcm, err := ecdhGCM(shared, ephemeralBytes, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	wrapped := append(ephemeralBytes, nonce...)
+	return gcm.Seal(wrapped, nonce, dataKey, nil), nil
+}
+
+func ecdhUnwrap(privKey *ecdsa.PrivateKey, wrapped []byte) ([]byte, error) {
+	recipient, err := privKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(recipient.PublicKey().Bytes())
+	if len(wrapped) < size {
+		return nil, errors.New("Invalid wrapped key")
+	}
+	ephemeral, err := recipient.Curve().NewPublicKey(wrapped[:size])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := ecdhGCM(shared, wrapped[:size], recipient.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < size+gcm.NonceSize() {
+		return nil, errors.New("Invalid wrapped key")
+	}
+	nonce := wrapped[size : size+gcm.NonceSize()]
+
+	return gcm.Open(nil, nonce, wrapped[size+gcm.NonceSize():], nil)
+}
+
+// ecdhGCM derives the key wrapping key from the shared secret and both public keys
+func ecdhGCM(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	info := append([]byte(ecdhInfo), ephemeral...)
+	info = append(info, recipient...)
+	kek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), kek); err != nil {
+		return nil, err
+	}
+
+	return newGCM(kek)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	// much larger than a rsa key could encrypt directly
+	payload := bytes.Repeat([]byte("This is the confidential content. "), 1000)
+
+	for _, c := range []struct {
+		keyType string
+		alg     string
+	}{
+		{KeyTypeRSA, WrapAlgRSAOAEP},
+		{KeyTypeRSAPSS, WrapAlgRSAOAEP},
+		{KeyTypeECDSAP256, WrapAlgECDHP256},
+	} {
+		privBytes, pubBytes, _ := GenerateKeyPair(c.keyType)
+		unwrap := func(wrapped []byte) ([]byte, error) {
+			return UnwrapKey(privBytes, wrapped)
+		}
+
+		assert.Nil(t, CheckEncryptionKey(pubBytes), "%s keys should encrypt", c.keyType)
+		data, err := Encrypt(pubBytes, payload)
+		assert.Nil(t, err, "Fail to encrypt to a %s key", c.keyType)
+		assert.True(t, IsEncrypted(data), "Fail to detect an encrypted envelope")
+
+		env, err := ParseEncryptedEnvelope(data)
+		assert.Nil(t, err, "Fail to parse an encrypted envelope")
+		keyID, _ := KeyID(pubBytes)
+		assert.Equal(t, keyID, env.KeyID, "Fail to record the key id")
+		assert.Equal(t, c.alg, env.Alg, "Fail to wrap the data key by the key type")
+
+		plain, err := env.Decrypt(unwrap)
+		assert.Nil(t, err, "Fail to decrypt by a %s key", c.keyType)
+		assert.Equal(t, payload, plain, "Fail to decrypt correctly")
+
+		// the header is bound to the ciphertext
+		tampered := env
+		tampered.KeyID = "other"
+		_, err = tampered.Decrypt(unwrap)
+		assert.NotNil(t, err, "Should not decrypt a tampered envelope")
+
+		otherPriv, _, _ := GenerateKeyPair(c.keyType)
+		_, err = env.Decrypt(func(wrapped []byte) ([]byte, error) {
+			return UnwrapKey(otherPriv, wrapped)
+		})
+		assert.NotNil(t, err, "Should not decrypt by another key")
+	}
+
+	_, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+	_, err := Encrypt(pubBytes, payload)
+	assert.NotNil(t, err, "Ed25519 keys could not encrypt")
+	assert.NotNil(t, CheckEncryptionKey(pubBytes), "Ed25519 keys could not encrypt")
+
+	assert.False(t, IsEncrypted(payload), "Plain data is not an encrypted envelope")
+	data, _ := json.Marshal(EncryptedEnvelope{PayloadType: "other", Enc: EncAESGCM})
+	assert.False(t, IsEncrypted(data), "Should check the payload type")
+}
+
+func TestUnwrapLegacyRSA(t *testing.T) {
+	privBytes, pubBytes, _ := GenerateKeyPair(KeyTypeRSA)
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+
+	encrypted, _ := RSAEncrypt(pubBytes, dataKey)
+	decrypted, err := UnwrapKey(privBytes, encrypted)
+	assert.Nil(t, err, "Legacy rsa keys should unwrap as RSADecrypt")
+	assert.Equal(t, dataKey, decrypted)
+}
+
+func TestUnwrapRSAError(t *testing.T) {
+	privBytes, pubBytes, _ := GenerateKeyPair(KeyTypeRSA)
+	encrypted, _ := RSAEncrypt(pubBytes, []byte("0123456789abcdef0123456789abcdef"))
+	wrapped, _, _ := WrapKey(pubBytes, []byte("0123456789abcdef0123456789abcdef"))
+
+	// broken paddings of both schemes fail the same way
+	for _, data := range [][]byte{encrypted, wrapped} {
+		broken := append([]byte(nil), data...)
+		broken[len(broken)-1] ^= 1
+		_, err := UnwrapKey(privBytes, broken)
+		assert.Equal(t, ErrorsDecryption, err)
+	}
+	_, err := UnwrapKey(privBytes, []byte("short"))
+	assert.Equal(t, ErrorsDecryption, err)
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultPayloadType is the payload type of the signed meta data
+	DefaultPayloadType = "application/vnd.update-service.meta+json"
+	// ChannelMetaPayloadType is the payload type of the signed meta data of a release channel
+	ChannelMetaPayloadType = "application/vnd.update-service.channel-meta+json"
+	// RolePayloadType is the payload type of a role signed by the root key
+	RolePayloadType = "application/vnd.update-service.role+json"
+)
+
+var (
+	// ErrorsNoValidSignature occurs when no signature in an envelope could be verified
+	ErrorsNoValidSignature = errors.New("no valid signature found in the envelope")
+)
+
+// Signature is a signature of a payload made by a key
+type Signature struct {
+	// KeyID is the hex encoded sha256 of the DER public key
+	KeyID string `json:"keyid"`
+	// Alg is the key type of the signing key
+	Alg string `json:"alg"`
+	// Sig is the signature, base64 encoded in json
+	Sig []byte `json:"sig"`
+}
+
+// SignatureEnvelope is the self describing signature file of a payload,
+// it could have one or more signatures.
+type SignatureEnvelope struct {
+	PayloadType string      `json:"payloadType"`
+	Created     time.Time   `json:"created"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// KeyID gets the id of a PEM encoded public key
+func KeyID(pubBytes []byte) (string, error) {
+	block, _ := pem.Decode(pubBytes)
+	if block == nil {
+		return "", errors.New("Fail to decode public key")
+	}
+
+	return keyIDFromDER(block.Bytes), nil
+}
+
+func keyIDFromDER(der []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(der))
+}
+
+// NewSignatureEnvelope creates an envelope without any signature
+func NewSignatureEnvelope(payloadType string) SignatureEnvelope {
+	return SignatureEnvelope{PayloadType: payloadType, Created: time.Now().UTC()}
+}
+
+// PAE is the DSSE pre-authentication encoding of a payload and its type, which is
+// what is signed, so the signature of a type of payload never verifies another type:
+// "DSSEv1" SP len(type) SP type SP len(payload) SP payload
+func PAE(payloadType string, payload []byte) []byte {
+	header := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(header), payload...)
+}
+
+// PAE is the bytes to sign for a payload of the envelope
+func (env *SignatureEnvelope) PAE(payload []byte) []byte {
+	return PAE(env.PayloadType, payload)
+}
+
+// ParseSignatureEnvelope loads an envelope, it returns an error if the data
+// is not an envelope, for example a legacy raw signature.
+func ParseSignatureEnvelope(data []byte) (SignatureEnvelope, error) {
+	var env SignatureEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return SignatureEnvelope{}, err
+	}
+	if len(env.Signatures) == 0 {
+		return SignatureEnvelope{}, errors.New("envelope should have at least one signature")
+	}
+
+	return env, nil
+}
+
+// AddSignature adds a signature made over env.PAE(payload) by the private key of
+// 'pubBytes', a former signature of the same key is replaced.
+func (env *SignatureEnvelope) AddSignature(pubBytes []byte, sig []byte) error {
+	keyID, err := KeyID(pubBytes)
+	if err != nil {
+		return err
+	}
+	keyType, err := GetKeyType(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	s := Signature{KeyID: keyID, Alg: keyType, Sig: sig}
+	for i := range env.Signatures {
+		if env.Signatures[i].KeyID == keyID {
+			env.Signatures[i] = s
+			return nil
+		}
+	}
+	env.Signatures = append(env.Signatures, s)
+
+	return nil
+}
+
+// Merge adds all the signatures of another envelope
+func (env *SignatureEnvelope) Merge(other SignatureEnvelope) {
+	for _, s := range other.Signatures {
+		replaced := false
+		for i := range env.Signatures {
+			if env.Signatures[i].KeyID == s.KeyID {
+				env.Signatures[i] = s
+				replaced = true
+			}
+		}
+		if !replaced {
+			env.Signatures = append(env.Signatures, s)
+		}
+	}
+}
+
+// GetSignature gets the signature made by a key id
+func (env *SignatureEnvelope) GetSignature(keyID string) (Signature, error) {
+	for _, s := range env.Signatures {
+		if s.KeyID == keyID {
+			return s, nil
+		}
+	}
+
+	return Signature{}, fmt.Errorf("Cannot find the signature of key: %s", keyID)
+}
+
+// Verify verifies a payload of a type by the signature of the public key in the
+// envelope, the envelope should be of the type and signed over PAE(payloadType, payload)
+func (env *SignatureEnvelope) Verify(pubBytes []byte, payloadType string, payload []byte) error {
+	if env.PayloadType != payloadType {
+		return fmt.Errorf("Payload type '%s' of the envelope should be '%s'", env.PayloadType, payloadType)
+	}
+	keyID, err := KeyID(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	s, err := env.GetSignature(keyID)
+	if err != nil {
+		return ErrorsNoValidSignature
+	}
+
+	keyType, err := GetKeyType(pubBytes)
+	if err != nil {
+		return err
+	}
+	if s.Alg != keyType {
+		return fmt.Errorf("Signature algorithm '%s' does not match the key type '%s'", s.Alg, keyType)
+	}
+
+	return SHA256Verify(pubBytes, PAE(payloadType, payload), s.Sig)
+}
+
+// VerifyMetaSign verifies a payload of a type by a sign file, the sign file could
+// be an envelope or a legacy raw signature of the payload.
+func VerifyMetaSign(pubBytes []byte, payloadType string, payload []byte, signBytes []byte) error {
+	env, err := ParseSignatureEnvelope(signBytes)
+	if err != nil {
+		return SHA256Verify(pubBytes, payload, signBytes)
+	}
+
+	return env.Verify(pubBytes, payloadType, payload)
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureEnvelope(t *testing.T) {
+	payload := []byte("This is the payload of the envelope")
+	env := NewSignatureEnvelope(DefaultPayloadType)
+
+	var pubs [][]byte
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeEd25519} {
+		privBytes, pubBytes, _ := GenerateKeyPair(keyType)
+		sig, _ := SHA256Sign(privBytes, env.PAE(payload))
+		err := env.AddSignature(pubBytes, sig)
+		assert.Nil(t, err, "Fail to add signature")
+		pubs = append(pubs, pubBytes)
+	}
+	assert.Equal(t, 2, len(env.Signatures), "Fail to add signatures of different keys")
+
+	data, _ := json.Marshal(env)
+	parsed, err := ParseSignatureEnvelope(data)
+	assert.Nil(t, err, "Fail to parse envelope")
+	for _, pubBytes := range pubs {
+		assert.Nil(t, parsed.Verify(pubBytes, DefaultPayloadType, payload), "Fail to verify envelope")
+		assert.Nil(t, VerifyMetaSign(pubBytes, DefaultPayloadType, payload, data), "Fail to verify meta sign envelope")
+		assert.NotNil(t, parsed.Verify(pubBytes, DefaultPayloadType, []byte("invalid payload")), "Should not verify invalid payload")
+		assert.NotNil(t, parsed.Verify(pubBytes, ChannelMetaPayloadType, payload), "Should not verify another payload type")
+	}
+
+	_, otherPub, _ := GenerateKeyPair(KeyTypeECDSAP256)
+	assert.Equal(t, ErrorsNoValidSignature, parsed.Verify(otherPub, DefaultPayloadType, payload))
+}
+
+func TestSignatureEnvelopePayloadType(t *testing.T) {
+	payload := []byte("This is the payload of the envelope")
+	privBytes, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+
+	// a signature made for a role could not be moved to a meta envelope
+	role := NewSignatureEnvelope(RolePayloadType)
+	sig, _ := SHA256Sign(privBytes, role.PAE(payload))
+	assert.Nil(t, role.AddSignature(pubBytes, sig))
+	assert.Nil(t, role.Verify(pubBytes, RolePayloadType, payload), "Fail to verify envelope")
+
+	meta := NewSignatureEnvelope(DefaultPayloadType)
+	meta.Signatures = role.Signatures
+	assert.NotNil(t, meta.Verify(pubBytes, DefaultPayloadType, payload), "Should not verify a signature of another payload type")
+	role.PayloadType = DefaultPayloadType
+	assert.NotNil(t, role.Verify(pubBytes, DefaultPayloadType, payload), "Should not verify a changed payload type")
+
+	// the lengths are encoded, moving bytes between the type and the payload changes it
+	assert.NotEqual(t, PAE("a", []byte("b c")), PAE("a b", []byte("c")))
+}
+
+func TestVerifyMetaSignLegacy(t *testing.T) {
+	pubBytes, _ := ioutil.ReadFile(filepath.Join(testDataDir, "rsa_public_key.pem"))
+	signBytes, _ := ioutil.ReadFile(filepath.Join(testDataDir, "hello.sig"))
+	contentBytes, _ := ioutil.ReadFile(filepath.Join(testDataDir, "hello.txt"))
+
+	_, err := ParseSignatureEnvelope(signBytes)
+	assert.NotNil(t, err, "Raw signature should not be parsed as an envelope")
+	assert.Nil(t, VerifyMetaSign(pubBytes, DefaultPayloadType, contentBytes, signBytes), "Fail to verify legacy signature")
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// KeyFormatPEM is the format keys are stored in
+	KeyFormatPEM = "pem"
+	// KeyFormatPKCS1 is PEM encoded PKCS#1, only for rsa private keys
+	KeyFormatPKCS1 = "pkcs1"
+	// KeyFormatPKCS8 is PEM encoded PKCS#8 private keys or PKIX public keys, without the 'Key-Type' header
+	KeyFormatPKCS8 = "pkcs8"
+	// KeyFormatJWK is JSON Web Key (RFC 7517)
+	KeyFormatJWK = "jwk"
+)
+
+// JWK is a JSON Web Key of the supported key types
+type JWK struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+	// EC and OKP
+	X string `json:"x,omitempty"`
+	Y string `json:"y,omitempty"`
+	// private part of all the key types
+	D string `json:"d,omitempty"`
+}
+
+var jwkAlgs = map[string]string{
+	KeyTypeRSA:       "RS256",
+	KeyTypeRSAPSS:    "PS256",
+	KeyTypeECDSAP256: "ES256",
+	KeyTypeEd25519:   "EdDSA",
+}
+
+// ImportPrivateKey converts a private key in PEM PKCS#1, PEM PKCS#8, PEM EC or
+// JWK to the PEM format keys are stored in.
+// 'keyType' chooses between 'rsa' and 'rsa-pss' of a rsa key, or checks the key
+// type of other keys, the key type is detected if it is empty.
+func ImportPrivateKey(data []byte, keyType string) ([]byte, error) {
+	var signer crypto.Signer
+	var detected string
+	var err error
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		signer, detected, err = parseJWKPrivateKey(trimmed)
+	} else {
+		signer, detected, err = getSigner(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if keyType == "" {
+		keyType = detected
+	} else if !IsKeyTypeSupported(keyType) {
+		return nil, fmt.Errorf("%v: %s", ErrorsKeyTypeNotSupported, keyType)
+	} else if keyType != detected && !(keyType == KeyTypeRSAPSS && detected == KeyTypeRSA) {
+		return nil, fmt.Errorf("Key type '%s' does not match the '%s' key", keyType, detected)
+	}
+
+	return encodePrivateKey(signer, keyType)
+}
+
+// ExportPrivateKey converts a stored private key to a format
+func ExportPrivateKey(privBytes []byte, format string) ([]byte, error) {
+	signer, keyType, err := getSigner(privBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case KeyFormatPEM:
+		return privBytes, nil
+	case KeyFormatPKCS1:
+		rsaKey, ok := signer.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("Only rsa keys could be exported in PKCS#1")
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil
+	case KeyFormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(signer)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case KeyFormatJWK:
+		jwk, err := newJWK(signer.Public(), signer, keyType)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(jwk, "", "\t")
+	}
+
+	return nil, fmt.Errorf("Unsupported key format: %s", format)
+}
+
+// ExportPublicKey converts a stored public key to a format
+func ExportPublicKey(pubBytes []byte, format string) ([]byte, error) {
+	pubKey, keyType, err := getPublicKey(pubBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case KeyFormatPEM:
+		return pubBytes, nil
+	case KeyFormatPKCS8:
+		der, err := x509.MarshalPKIXPublicKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	case KeyFormatJWK:
+		jwk, err := newJWK(pubKey, nil, keyType)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(jwk, "", "\t")
+	}
+
+	return nil, fmt.Errorf("Unsupported key format: %s", format)
+}
+
+// encodePrivateKey encodes a private key in the stored format, 'rsa' keys in
+// legacy PKCS#1 and the others in PKCS#8 with a 'Key-Type' header
+func encodePrivateKey(signer crypto.Signer, keyType string) ([]byte, error) {
+	if keyType == KeyTypeRSA {
+		rsaKey, ok := signer.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("Fail get rsa private key from signer")
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Headers: map[string]string{keyTypeHeader: keyType}, Bytes: der}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+func parseJWKPrivateKey(data []byte) (crypto.Signer, string, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, "", err
+	}
+	if jwk.D == "" {
+		return nil, "", errors.New("JWK is not a private key")
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, e, d := jwkInt(jwk.N), jwkInt(jwk.E), jwkInt(jwk.D)
+		p, q := jwkInt(jwk.P), jwkInt(jwk.Q)
+		if n == nil || e == nil || d == nil || p == nil || q == nil || !e.IsInt64() {
+			return nil, "", errors.New("Invalid RSA JWK")
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, "", err
+		}
+		key.Precompute()
+		if jwk.Alg == jwkAlgs[KeyTypeRSAPSS] {
+			return key, KeyTypeRSAPSS, nil
+		}
+		return key, KeyTypeRSA, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, "", errors.New("Only the P-256 curve is supported")
+		}
+		d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), d)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, KeyTypeECDSAP256, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, "", errors.New("Only the Ed25519 curve is supported")
+		}
+		seed, err := base64.RawURLEncoding.DecodeString(jwk.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, "", errors.New("Invalid Ed25519 JWK")
+		}
+		return ed25519.NewKeyFromSeed(seed), KeyTypeEd25519, nil
+	}
+
+	return nil, "", fmt.Errorf("Unsupported JWK key type: %s", jwk.Kty)
+}
+
+func newJWK(pubKey crypto.PublicKey, signer crypto.Signer, keyType string) (JWK, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Alg: jwkAlgs[keyType], Kid: keyIDFromDER(der)}
+
+	switch k := pubKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = jwkString(k.N.Bytes())
+		jwk.E = jwkString(big.NewInt(int64(k.E)).Bytes())
+		if priv, ok := signer.(*rsa.PrivateKey); ok {
+			if len(priv.Primes) != 2 {
+				return JWK{}, errors.New("Only rsa keys with two primes are supported")
+			}
+			jwk.D = jwkString(priv.D.Bytes())
+			jwk.P = jwkString(priv.Primes[0].Bytes())
+			jwk.Q = jwkString(priv.Primes[1].Bytes())
+			jwk.DP = jwkString(priv.Precomputed.Dp.Bytes())
+			jwk.DQ = jwkString(priv.Precomputed.Dq.Bytes())
+			jwk.QI = jwkString(priv.Precomputed.Qinv.Bytes())
+		}
+	case *ecdsa.PublicKey:
+		point, err := k.Bytes()
+		if err != nil {
+			return JWK{}, err
+		}
+		// uncompressed point: 0x04 | x | y
+		size := (len(point) - 1) / 2
+		jwk.Kty, jwk.Crv = "EC", "P-256"
+		jwk.X = jwkString(point[1 : 1+size])
+		jwk.Y = jwkString(point[1+size:])
+		if priv, ok := signer.(*ecdsa.PrivateKey); ok {
+			d, err := priv.Bytes()
+			if err != nil {
+				return JWK{}, err
+			}
+			jwk.D = jwkString(d)
+		}
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = jwkString(k)
+		if priv, ok := signer.(ed25519.PrivateKey); ok {
+			jwk.D = jwkString(priv.Seed())
+		}
+	default:
+		return JWK{}, ErrorsKeyTypeNotSupported
+	}
+
+	return jwk, nil
+}
+
+func jwkString(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
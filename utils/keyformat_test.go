@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportPrivateKey(t *testing.T) {
+	testData := []byte("This is the testdata for import and export")
+
+	for _, c := range []struct {
+		keyType string
+		format  string
+		// importType is needed if the format loses the key type
+		importType string
+	}{
+		{KeyTypeRSA, KeyFormatPEM, ""},
+		{KeyTypeRSA, KeyFormatPKCS1, ""},
+		{KeyTypeRSA, KeyFormatPKCS8, ""},
+		{KeyTypeRSA, KeyFormatJWK, ""},
+		{KeyTypeRSAPSS, KeyFormatPEM, ""},
+		{KeyTypeRSAPSS, KeyFormatPKCS1, KeyTypeRSAPSS},
+		{KeyTypeRSAPSS, KeyFormatPKCS8, KeyTypeRSAPSS},
+		{KeyTypeRSAPSS, KeyFormatJWK, ""},
+		{KeyTypeECDSAP256, KeyFormatPKCS8, ""},
+		{KeyTypeECDSAP256, KeyFormatJWK, ""},
+		{KeyTypeEd25519, KeyFormatPKCS8, ""},
+		{KeyTypeEd25519, KeyFormatJWK, ""},
+	} {
+		privBytes, pubBytes, _ := GenerateKeyPair(c.keyType)
+
+		exported, err := ExportPrivateKey(privBytes, c.format)
+		assert.Nil(t, err, "Fail to export %s key in %s", c.keyType, c.format)
+		imported, err := ImportPrivateKey(exported, c.importType)
+		assert.Nil(t, err, "Fail to import %s key in %s", c.keyType, c.format)
+
+		keyType, _ := GetKeyType(imported)
+		assert.Equal(t, c.keyType, keyType, "Fail to keep the key type of %s in %s", c.keyType, c.format)
+		signBytes, err := SHA256Sign(imported, testData)
+		assert.Nil(t, err, "Fail to sign by imported %s key in %s", c.keyType, c.format)
+		assert.Nil(t, SHA256Verify(pubBytes, testData, signBytes), "Imported %s key in %s should match the public key", c.keyType, c.format)
+	}
+
+	for _, keyType := range []string{KeyTypeECDSAP256, KeyTypeEd25519} {
+		privBytes, _, _ := GenerateKeyPair(keyType)
+		_, err := ExportPrivateKey(privBytes, KeyFormatPKCS1)
+		assert.NotNil(t, err, "Only rsa keys could be exported in PKCS#1")
+	}
+
+	privBytes, _, _ := GenerateKeyPair(KeyTypeEd25519)
+	_, err := ExportPrivateKey(privBytes, "unknown")
+	assert.NotNil(t, err, "Should not export to an unknown format")
+	_, err = ImportPrivateKey(privBytes, KeyTypeECDSAP256)
+	assert.NotNil(t, err, "Should not import a key as another key type")
+}
+
+func TestImportECPrivateKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	imported, err := ImportPrivateKey(data, "")
+	assert.Nil(t, err, "Fail to import an EC private key")
+	keyType, _ := GetKeyType(imported)
+	assert.Equal(t, KeyTypeECDSAP256, keyType)
+
+	key, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ = x509.MarshalECPrivateKey(key)
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	_, err = ImportPrivateKey(data, "")
+	assert.NotNil(t, err, "Should not import a key of an unsupported curve")
+}
+
+func TestExportPublicKey(t *testing.T) {
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeRSAPSS, KeyTypeECDSAP256, KeyTypeEd25519} {
+		privBytes, pubBytes, _ := GenerateKeyPair(keyType)
+		keyID, _ := KeyID(pubBytes)
+
+		data, err := ExportPublicKey(pubBytes, KeyFormatPKCS8)
+		assert.Nil(t, err, "Fail to export %s public key in pkcs8", keyType)
+		pkcs8ID, _ := KeyID(data)
+		assert.Equal(t, keyID, pkcs8ID, "Key id should not change with the format")
+
+		data, err = ExportPublicKey(pubBytes, KeyFormatJWK)
+		assert.Nil(t, err, "Fail to export %s public key in jwk", keyType)
+		assert.Contains(t, string(data), keyID, "JWK should have the key id")
+		_, err = ImportPrivateKey(data, "")
+		assert.NotNil(t, err, "Should not import a public JWK as a private key")
+
+		privJWK, _ := ExportPrivateKey(privBytes, KeyFormatJWK)
+		assert.Contains(t, string(privJWK), keyID, "Private JWK should have the key id of the public key")
+	}
+}
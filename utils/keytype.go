@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	// KeyTypeRSA is RSA-2048 with PKCS#1 v1.5 signatures, the default and the only type of old repos
+	KeyTypeRSA = "rsa"
+	// KeyTypeRSAPSS is RSA-2048 with PSS signatures
+	KeyTypeRSAPSS = "rsa-pss"
+	// KeyTypeECDSAP256 is ECDSA on the P-256 curve with ASN.1 signatures
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	// KeyTypeEd25519 is Ed25519
+	KeyTypeEd25519 = "ed25519"
+
+	// DefaultKeyType is used when no key type is configured
+	DefaultKeyType = KeyTypeRSA
+
+	// keyTypeHeader records the key type in the PEM headers of a key
+	keyTypeHeader = "Key-Type"
+
+	defaultRSABitsSize = 2048
+)
+
+var (
+	// ErrorsKeyTypeNotSupported occurs when a key type is unknown
+	ErrorsKeyTypeNotSupported = errors.New("key type is not supported")
+)
+
+// IsKeyTypeSupported checks if a key type is known
+func IsKeyTypeSupported(keyType string) bool {
+	switch keyType {
+	case KeyTypeRSA, KeyTypeRSAPSS, KeyTypeECDSAP256, KeyTypeEd25519:
+		return true
+	}
+	return false
+}
+
+// GenerateKeyPair generates a private key and a public key of a key type.
+// The key type is recorded in the PEM headers of both keys, 'rsa' keys
+// are kept in the legacy format of GenerateRSAKeyPair.
+func GenerateKeyPair(keyType string) ([]byte, []byte, error) {
+	var signer crypto.Signer
+	var err error
+
+	switch keyType {
+	case KeyTypeRSA:
+		return GenerateRSAKeyPair(defaultRSABitsSize)
+	case KeyTypeRSAPSS:
+		signer, err = rsa.GenerateKey(rand.Reader, defaultRSABitsSize)
+	case KeyTypeECDSAP256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, ErrorsKeyTypeNotSupported
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privBytes, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := map[string]string{keyTypeHeader: keyType}
+	privBlock := &pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: privBytes}
+	pubBlock := &pem.Block{Type: "PUBLIC KEY", Headers: headers, Bytes: pubBytes}
+
+	return pem.EncodeToMemory(privBlock), pem.EncodeToMemory(pubBlock), nil
+}
+
+// GetKeyType gets the key type of a PEM encoded private or public key.
+// The key type is inferred from the parsed key, the 'Key-Type' header is only
+// a hint which should match it and tells 'rsa-pss' from 'rsa' keys.
+// Keys without a 'Key-Type' header are legacy 'rsa' keys.
+func GetKeyType(keyBytes []byte) (string, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return "", errors.New("Fail to decode key")
+	}
+
+	// the headers of an encrypted key are authenticated by its cipher
+	if block.Type == encryptedKeyType {
+		keyType := block.Headers[keyTypeHeader]
+		if !IsKeyTypeSupported(keyType) {
+			return "", fmt.Errorf("%v: %s", ErrorsKeyTypeNotSupported, keyType)
+		}
+		return keyType, nil
+	}
+
+	_, keyType, err := parseKeyBlock(block)
+	return keyType, err
+}
+
+// GetPublicKeyFromPrivate gets the PEM encoded public key of a private key,
+// in the same format as GenerateKeyPair creates.
+func GetPublicKeyFromPrivate(privBytes []byte) ([]byte, error) {
+	signer, keyType, err := getSigner(privBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	if keyType == KeyTypeRSA {
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pubBytes}), nil
+	}
+
+	headers := map[string]string{keyTypeHeader: keyType}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: headers, Bytes: pubBytes}), nil
+}
+
+func getSigner(privBytes []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(privBytes)
+	if block == nil {
+		return nil, "", errors.New("Fail to decode private key")
+	}
+	key, keyType, err := parseKeyBlock(block)
+	if err != nil {
+		return nil, "", err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, "", errors.New("Fail get signer from private interface")
+	}
+
+	return signer, keyType, nil
+}
+
+func getPublicKey(pubBytes []byte) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode(pubBytes)
+	if block == nil {
+		return nil, "", errors.New("Fail to decode public key")
+	}
+	key, keyType, err := parseKeyBlock(block)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := key.(crypto.Signer); ok {
+		return nil, "", errors.New("Fail to get public key, it is a private key")
+	}
+
+	return key, keyType, nil
+}
+
+// parseKeyBlock parses the key of a PEM block and infers its key type
+func parseKeyBlock(block *pem.Block) (interface{}, string, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("Unsupported key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyType, err := inferKeyType(key, block.Headers[keyTypeHeader])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, keyType, nil
+}
+
+// inferKeyType gets the key type of a parsed key, a rsa key is 'rsa-pss' only
+// by the header, other headers should be the inferred key type or empty.
+func inferKeyType(key interface{}, header string) (string, error) {
+	var keyType string
+	switch k := key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		keyType = KeyTypeRSA
+		if header == KeyTypeRSAPSS {
+			keyType = KeyTypeRSAPSS
+		}
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("Only the P-256 curve is supported")
+		}
+		keyType = KeyTypeECDSAP256
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("Only the P-256 curve is supported")
+		}
+		keyType = KeyTypeECDSAP256
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		keyType = KeyTypeEd25519
+	default:
+		return "", ErrorsKeyTypeNotSupported
+	}
+
+	if header != "" && header != keyType {
+		return "", fmt.Errorf("Key type '%s' of the header does not match the '%s' key", header, keyType)
+	}
+
+	return keyType, nil
+}
+
+func signWithType(signer crypto.Signer, keyType string, contentBytes []byte) ([]byte, error) {
+	hashed := sha256.Sum256(contentBytes)
+
+	switch keyType {
+	case KeyTypeRSA:
+		return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case KeyTypeRSAPSS:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		return signer.Sign(rand.Reader, hashed[:], opts)
+	case KeyTypeECDSAP256:
+		return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case KeyTypeEd25519:
+		// Ed25519 hashes the message itself
+		return signer.Sign(rand.Reader, contentBytes, crypto.Hash(0))
+	}
+
+	return nil, ErrorsKeyTypeNotSupported
+}
+
+func verifyWithType(pubKey crypto.PublicKey, keyType string, contentBytes []byte, signBytes []byte) error {
+	hashed := sha256.Sum256(contentBytes)
+
+	switch keyType {
+	case KeyTypeRSA, KeyTypeRSAPSS:
+		rsaKey, ok := pubKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("Fail get rsa public key from public interface")
+		}
+		if keyType == KeyTypeRSA {
+			return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hashed[:], signBytes)
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		return rsa.VerifyPSS(rsaKey, crypto.SHA256, hashed[:], signBytes, opts)
+	case KeyTypeECDSAP256:
+		ecKey, ok := pubKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("Fail get ecdsa public key from public interface")
+		}
+		if !ecdsa.VerifyASN1(ecKey, hashed[:], signBytes) {
+			return errors.New("ecdsa: verification error")
+		}
+		return nil
+	case KeyTypeEd25519:
+		edKey, ok := pubKey.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("Fail get ed25519 public key from public interface")
+		}
+		if !ed25519.Verify(edKey, contentBytes, signBytes) {
+			return errors.New("ed25519: verification error")
+		}
+		return nil
+	}
+
+	return ErrorsKeyTypeNotSupported
+}
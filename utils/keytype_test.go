@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateKeyPairSignVerify(t *testing.T) {
+	testData := []byte("This is the testdata for sign and verify")
+
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeRSAPSS, KeyTypeECDSAP256, KeyTypeEd25519} {
+		privBytes, pubBytes, err := GenerateKeyPair(keyType)
+		assert.Nil(t, err, "Fail to generate key pair of %s", keyType)
+
+		privType, _ := GetKeyType(privBytes)
+		pubType, _ := GetKeyType(pubBytes)
+		assert.Equal(t, keyType, privType, "Fail to record key type in private key")
+		assert.Equal(t, keyType, pubType, "Fail to record key type in public key")
+
+		signBytes, err := SHA256Sign(privBytes, testData)
+		assert.Nil(t, err, "Fail to sign with %s", keyType)
+		err = SHA256Verify(pubBytes, testData, signBytes)
+		assert.Nil(t, err, "Fail to verify with %s", keyType)
+		err = SHA256Verify(pubBytes, []byte("Invalid content data"), signBytes)
+		assert.NotNil(t, err, "Fail to verify invalid signed data with %s", keyType)
+	}
+
+	_, _, err := GenerateKeyPair("unknown")
+	assert.Equal(t, ErrorsKeyTypeNotSupported, err)
+}
+
+func TestGetKeyType(t *testing.T) {
+	pubBytes, _ := ioutil.ReadFile(filepath.Join(testDataDir, "rsa_public_key.pem"))
+	keyType, err := GetKeyType(pubBytes)
+	assert.Nil(t, err, "Fail to get key type of a legacy key")
+	assert.Equal(t, KeyTypeRSA, keyType, "Legacy keys should be rsa keys")
+
+	_, err = GetKeyType([]byte("invalid key"))
+	assert.NotNil(t, err, "Should not get key type of invalid key")
+}
+
+func TestGetKeyTypeHeader(t *testing.T) {
+	withHeader := func(keyBytes []byte, keyType string) []byte {
+		block, _ := pem.Decode(keyBytes)
+		block.Headers = map[string]string{}
+		if keyType != "" {
+			block.Headers[keyTypeHeader] = keyType
+		}
+		return pem.EncodeToMemory(block)
+	}
+
+	edPriv, edPub, _ := GenerateKeyPair(KeyTypeEd25519)
+	for _, keyBytes := range [][]byte{edPriv, edPub} {
+		keyType, err := GetKeyType(withHeader(keyBytes, ""))
+		assert.Nil(t, err, "Fail to infer the key type without the header")
+		assert.Equal(t, KeyTypeEd25519, keyType, "Key type should be inferred from the key")
+		_, err = GetKeyType(withHeader(keyBytes, KeyTypeRSA))
+		assert.NotNil(t, err, "Should refuse a header not matching the key")
+	}
+
+	// a forged header does not change how a signature is verified
+	sig, _ := SHA256Sign(edPriv, []byte("data"))
+	assert.NotNil(t, SHA256Verify(withHeader(edPub, KeyTypeECDSAP256), []byte("data"), sig), "Should not verify by a forged key type")
+	assert.Nil(t, SHA256Verify(withHeader(edPub, ""), []byte("data"), sig), "Fail to verify by the inferred key type")
+
+	_, rsaPub, _ := GenerateKeyPair(KeyTypeRSAPSS)
+	keyType, _ := GetKeyType(rsaPub)
+	assert.Equal(t, KeyTypeRSAPSS, keyType, "The header should tell rsa-pss keys")
+	_, err := GetKeyType(withHeader(rsaPub, KeyTypeEd25519))
+	assert.NotNil(t, err, "Should refuse an ed25519 header of a rsa key")
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TreeHeadPayloadType is the payload type of the signatures of a tree head
+const TreeHeadPayloadType = "application/vnd.update-service.treehead+json"
+
+var (
+	// ErrorsInvalidProof occurs when a merkle inclusion or consistency proof does not verify
+	ErrorsInvalidProof = errors.New("merkle proof does not verify")
+)
+
+// TreeHead is the size and the root hash of a merkle tree of a transparency log
+type TreeHead struct {
+	TreeSize  int64     `json:"treeSize"`
+	RootHash  []byte    `json:"rootHash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SignedTreeHead is a tree head with the signatures over its canonical json
+type SignedTreeHead struct {
+	Signed     TreeHead          `json:"signed"`
+	Signatures SignatureEnvelope `json:"signatures"`
+}
+
+// InclusionProof proves a leaf is in the tree of a size
+type InclusionProof struct {
+	LeafIndex int64    `json:"leafIndex"`
+	TreeSize  int64    `json:"treeSize"`
+	AuditPath [][]byte `json:"auditPath"`
+}
+
+// ConsistencyProof proves the tree of the first size is a prefix of the tree of the second size
+type ConsistencyProof struct {
+	First  int64    `json:"first"`
+	Second int64    `json:"second"`
+	Proof  [][]byte `json:"proof"`
+}
+
+// ParseSignedTreeHead loads a signed tree head
+func ParseSignedTreeHead(data []byte) (SignedTreeHead, error) {
+	var sth SignedTreeHead
+	if err := json.Unmarshal(data, &sth); err != nil {
+		return SignedTreeHead{}, err
+	}
+	if len(sth.Signatures.Signatures) == 0 {
+		return SignedTreeHead{}, errors.New("tree head should have at least one signature")
+	}
+
+	return sth, nil
+}
+
+// Verify verifies the tree head by the signature of a public key
+func (sth *SignedTreeHead) Verify(pubBytes []byte) error {
+	payload, err := CanonicalJSON(sth.Signed)
+	if err != nil {
+		return err
+	}
+
+	return sth.Signatures.Verify(pubBytes, TreeHeadPayloadType, payload)
+}
+
+// VerifyRole verifies the tree head by a key of a role, the tree head is signed
+// online with every append, so one key of the role is enough
+func (sth *SignedTreeHead) VerifyRole(role Role) error {
+	payload, err := CanonicalJSON(sth.Signed)
+	if err != nil {
+		return err
+	}
+
+	online := Role{Keys: role.Keys, Threshold: 1}
+	return online.VerifyThreshold(TreeHeadPayloadType, payload, sth.Signatures)
+}
+
+// MerkleLeafHash is the RFC 6962 hash of a leaf: SHA-256(0x00 || data)
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// merkleNodeHash is the RFC 6962 hash of an interior node: SHA-256(0x01 || left || right)
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint is the largest power of 2 smaller than n, n > 1
+func splitPoint(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// MerkleRootHash is the RFC 6962 root hash of a tree by its leaf hashes
+func MerkleRootHash(leafHashes [][]byte) []byte {
+	switch n := int64(len(leafHashes)); n {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leafHashes[0]
+	default:
+		k := splitPoint(n)
+		return merkleNodeHash(MerkleRootHash(leafHashes[:k]), MerkleRootHash(leafHashes[k:]))
+	}
+}
+
+// MerkleInclusionProof is the RFC 6962 audit path of a leaf in a tree by its leaf hashes
+func MerkleInclusionProof(leafHashes [][]byte, index int64) ([][]byte, error) {
+	n := int64(len(leafHashes))
+	if index < 0 || index >= n {
+		return nil, fmt.Errorf("Leaf index %d is out of the tree size %d", index, n)
+	}
+
+	return inclusionPath(leafHashes, index), nil
+}
+
+func inclusionPath(leafHashes [][]byte, m int64) [][]byte {
+	n := int64(len(leafHashes))
+	if n == 1 {
+		return nil
+	}
+
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionPath(leafHashes[:k], m), MerkleRootHash(leafHashes[k:]))
+	}
+	return append(inclusionPath(leafHashes[k:], m-k), MerkleRootHash(leafHashes[:k]))
+}
+
+// MerkleConsistencyProof is the RFC 6962 proof that the tree of size 'first' is
+// a prefix of the tree by its leaf hashes
+func MerkleConsistencyProof(leafHashes [][]byte, first int64) ([][]byte, error) {
+	n := int64(len(leafHashes))
+	if first < 0 || first > n {
+		return nil, fmt.Errorf("Tree size %d is out of the tree size %d", first, n)
+	}
+	if first == 0 || first == n {
+		return nil, nil
+	}
+
+	return subProof(leafHashes, first, true), nil
+}
+
+func subProof(leafHashes [][]byte, m int64, complete bool) [][]byte {
+	n := int64(len(leafHashes))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{MerkleRootHash(leafHashes)}
+	}
+
+	k := splitPoint(n)
+	if m <= k {
+		return append(subProof(leafHashes[:k], m, complete), MerkleRootHash(leafHashes[k:]))
+	}
+	return append(subProof(leafHashes[k:], m-k, false), MerkleRootHash(leafHashes[:k]))
+}
+
+// VerifyInclusion verifies an audit path of a leaf hash against a root hash, see RFC 9162 2.1.3.2
+func VerifyInclusion(leafHash []byte, index, size int64, proof [][]byte, root []byte) error {
+	if index < 0 || index >= size {
+		return fmt.Errorf("%v: leaf index %d is out of the tree size %d", ErrorsInvalidProof, index, size)
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrorsInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrorsInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency verifies that the tree of size 'first' and root 'firstRoot'
+// is a prefix of the tree of size 'second' and root 'secondRoot', see RFC 9162 2.1.4.2
+func VerifyConsistency(first, second int64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	if first < 0 || first > second {
+		return fmt.Errorf("%v: tree size %d is bigger than %d", ErrorsInvalidProof, first, second)
+	}
+	if first == second {
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrorsInvalidProof
+		}
+		return nil
+	}
+	if first == 0 {
+		if len(proof) != 0 {
+			return ErrorsInvalidProof
+		}
+		return nil
+	}
+	if len(proof) == 0 {
+		return ErrorsInvalidProof
+	}
+
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrorsInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrorsInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLeafHashes(n int) [][]byte {
+	var leafHashes [][]byte
+	for i := 0; i < n; i++ {
+		leafHashes = append(leafHashes, MerkleLeafHash([]byte(fmt.Sprintf("leaf %d", i))))
+	}
+	return leafHashes
+}
+
+func TestMerkleRootHash(t *testing.T) {
+	// the empty tree and the tree of the empty leaf of RFC 6962
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(MerkleRootHash(nil)))
+	assert.Equal(t, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d", hex.EncodeToString(MerkleLeafHash(nil)))
+
+	leafHashes := testLeafHashes(3)
+	expected := merkleNodeHash(merkleNodeHash(leafHashes[0], leafHashes[1]), leafHashes[2])
+	assert.Equal(t, expected, MerkleRootHash(leafHashes), "Fail to compute the root of an unbalanced tree")
+}
+
+func TestMerkleInclusionProof(t *testing.T) {
+	for n := 1; n <= 20; n++ {
+		leafHashes := testLeafHashes(n)
+		root := MerkleRootHash(leafHashes)
+		for i := 0; i < n; i++ {
+			proof, err := MerkleInclusionProof(leafHashes, int64(i))
+			assert.Nil(t, err)
+			assert.Nil(t, VerifyInclusion(leafHashes[i], int64(i), int64(n), proof, root), "Fail to verify leaf %d of %d", i, n)
+			assert.NotNil(t, VerifyInclusion(MerkleLeafHash([]byte("other")), int64(i), int64(n), proof, root), "Should refuse another leaf")
+			if n > 1 {
+				assert.NotNil(t, VerifyInclusion(leafHashes[i], int64((i+1)%n), int64(n), proof, root), "Should refuse another index")
+			}
+		}
+	}
+
+	_, err := MerkleInclusionProof(testLeafHashes(2), 2)
+	assert.NotNil(t, err, "Should refuse an index out of the tree")
+}
+
+func TestMerkleConsistencyProof(t *testing.T) {
+	for n := 1; n <= 20; n++ {
+		leafHashes := testLeafHashes(n)
+		root := MerkleRootHash(leafHashes)
+		for m := 0; m <= n; m++ {
+			firstRoot := MerkleRootHash(leafHashes[:m])
+			proof, err := MerkleConsistencyProof(leafHashes, int64(m))
+			assert.Nil(t, err)
+			assert.Nil(t, VerifyConsistency(int64(m), int64(n), firstRoot, root, proof), "Fail to verify %d to %d", m, n)
+			if m > 0 && m < n {
+				assert.NotNil(t, VerifyConsistency(int64(m), int64(n), MerkleLeafHash([]byte("other")), root, proof), "Should refuse another first root")
+				assert.NotNil(t, VerifyConsistency(int64(m), int64(n), firstRoot, MerkleLeafHash([]byte("other")), proof), "Should refuse another second root")
+			}
+		}
+	}
+
+	// a forked tree is not consistent
+	leafHashes := testLeafHashes(5)
+	forked := append(testLeafHashes(3), MerkleLeafHash([]byte("fork")), leafHashes[4])
+	proof, _ := MerkleConsistencyProof(leafHashes, 4)
+	assert.NotNil(t, VerifyConsistency(4, 5, MerkleRootHash(forked[:4]), MerkleRootHash(leafHashes), proof), "Should refuse a forked tree")
+}
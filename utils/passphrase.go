@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"sort"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedKeyType = "ENCRYPTED KEY"
+
+	kdfHeader     = "KDF"
+	kdfNHeader    = "KDF-N"
+	kdfRHeader    = "KDF-R"
+	kdfPHeader    = "KDF-P"
+	kdfSaltHeader = "KDF-Salt"
+	cipherHeader  = "Cipher"
+	nonceHeader   = "Nonce"
+
+	defaultKDF         = "scrypt"
+	defaultKDFN        = 1 << 15
+	defaultKDFR        = 8
+	defaultKDFP        = 1
+	defaultKDFSaltSize = 16
+	defaultCipher      = "aes-256-gcm"
+
+	// the cost parameters of a key are capped, a stored key could not make
+	// loading it take unbounded memory or time
+	maxKDFMemory = 256 << 20
+	maxKDFP      = 16
+)
+
+var (
+	// ErrorsPassphraseRequired occurs when loading an encrypted key without a passphrase
+	ErrorsPassphraseRequired = errors.New("a passphrase is required to load the encrypted private key")
+)
+
+// IsEncryptedPrivateKey checks if a PEM encoded private key is encrypted by EncryptPrivateKey
+func IsEncryptedPrivateKey(keyBytes []byte) bool {
+	block, _ := pem.Decode(keyBytes)
+	return block != nil && block.Type == encryptedKeyType
+}
+
+// EncryptPrivateKey encrypts a PEM encoded private key by a passphrase.
+// The AES-256-GCM key is derived from the passphrase by scrypt, the KDF
+// parameters are kept in the PEM headers, which are authenticated by GCM.
+func EncryptPrivateKey(keyBytes []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrorsPassphraseRequired
+	}
+	keyType, err := GetKeyType(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, defaultKDFSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, defaultKDFN, defaultKDFR, defaultKDFP, 32)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		keyTypeHeader: keyType,
+		kdfHeader:     defaultKDF,
+		kdfNHeader:    strconv.Itoa(defaultKDFN),
+		kdfRHeader:    strconv.Itoa(defaultKDFR),
+		kdfPHeader:    strconv.Itoa(defaultKDFP),
+		kdfSaltHeader: hex.EncodeToString(salt),
+		cipherHeader:  defaultCipher,
+		nonceHeader:   hex.EncodeToString(nonce),
+	}
+	block := &pem.Block{
+		Type:    encryptedKeyType,
+		Headers: headers,
+		Bytes:   gcm.Seal(nil, nonce, keyBytes, headersAAD(headers)),
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// DecryptPrivateKey decrypts a private key encrypted by EncryptPrivateKey
+func DecryptPrivateKey(keyBytes []byte, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil || block.Type != encryptedKeyType {
+		return nil, errors.New("Fail to decode encrypted private key")
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrorsPassphraseRequired
+	}
+	if block.Headers[cipherHeader] != defaultCipher {
+		return nil, errors.New("Unsupported cipher of the encrypted private key")
+	}
+
+	salt, err := hex.DecodeString(block.Headers[kdfSaltHeader])
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(block.Headers[nonceHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Headers[kdfHeader] != defaultKDF {
+		return nil, errors.New("Unsupported KDF of the encrypted private key")
+	}
+	n, nErr := strconv.Atoi(block.Headers[kdfNHeader])
+	r, rErr := strconv.Atoi(block.Headers[kdfRHeader])
+	p, pErr := strconv.Atoi(block.Headers[kdfPHeader])
+	if nErr != nil || rErr != nil || pErr != nil || n < 2 || r < 1 || p < 1 ||
+		r > maxKDFMemory/128 || n > maxKDFMemory/128/r || p > maxKDFP {
+		return nil, errors.New("Invalid KDF parameters of the encrypted private key")
+	}
+	key, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("Invalid nonce of the encrypted private key")
+	}
+
+	data, err := gcm.Open(nil, nonce, block.Bytes, headersAAD(block.Headers))
+	if err != nil {
+		return nil, errors.New("Fail to decrypt the private key, the passphrase may be wrong")
+	}
+
+	return data, nil
+}
+
+// headersAAD is the additional data of the encrypted key, all the PEM headers
+// sorted by name
+func headersAAD(headers map[string]string) []byte {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var aad []byte
+	for _, name := range names {
+		aad = append(aad, name+": "+headers[name]+"\n"...)
+	}
+	return aad
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeEd25519} {
+		privBytes, _, _ := GenerateKeyPair(keyType)
+		assert.False(t, IsEncryptedPrivateKey(privBytes), "Plain key should not be encrypted")
+
+		encrypted, err := EncryptPrivateKey(privBytes, passphrase)
+		assert.Nil(t, err, "Fail to encrypt private key")
+		assert.True(t, IsEncryptedPrivateKey(encrypted), "Fail to check encrypted key")
+		encryptedType, _ := GetKeyType(encrypted)
+		assert.Equal(t, keyType, encryptedType, "Fail to keep key type of encrypted key")
+
+		decrypted, err := DecryptPrivateKey(encrypted, passphrase)
+		assert.Nil(t, err, "Fail to decrypt private key")
+		assert.Equal(t, privBytes, decrypted, "Fail to get the original private key")
+
+		_, err = DecryptPrivateKey(encrypted, []byte("wrong passphrase"))
+		assert.NotNil(t, err, "Should not decrypt by a wrong passphrase")
+		_, err = DecryptPrivateKey(encrypted, nil)
+		assert.Equal(t, ErrorsPassphraseRequired, err)
+	}
+
+	privBytes, _, _ := GenerateKeyPair(KeyTypeEd25519)
+	_, err := EncryptPrivateKey(privBytes, nil)
+	assert.Equal(t, ErrorsPassphraseRequired, err)
+	_, err = DecryptPrivateKey(privBytes, passphrase)
+	assert.NotNil(t, err, "Should not decrypt a plain key")
+
+	// the headers are authenticated, and the cost parameters are capped
+	encrypted, _ := EncryptPrivateKey(privBytes, passphrase)
+	for name, value := range map[string]string{keyTypeHeader: KeyTypeRSA, "Comment": "added", kdfNHeader: "1073741824", kdfPHeader: "1000"} {
+		block, _ := pem.Decode(encrypted)
+		block.Headers[name] = value
+		_, err = DecryptPrivateKey(pem.EncodeToMemory(block), passphrase)
+		assert.NotNil(t, err, "Should not decrypt a key of changed headers")
+	}
+
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RevocationPayloadType is the payload type of the signatures of a revocation list
+const RevocationPayloadType = "application/vnd.update-service.revocations+json"
+
+// ErrorsKeyRevoked occurs when something is signed by a revoked key
+var ErrorsKeyRevoked = errors.New("key is revoked")
+
+// Revocation tells clients to stop trusting a key
+type Revocation struct {
+	KeyID   string    `json:"keyid"`
+	Revoked time.Time `json:"revoked"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// RevocationList lists the revoked keys of a namespace, the version grows
+// with every revocation so clients could refuse an older list.
+type RevocationList struct {
+	Version     int          `json:"version"`
+	Revocations []Revocation `json:"revocations"`
+}
+
+// SignedRevocationList is a revocation list with the signatures over its canonical json
+type SignedRevocationList struct {
+	Signed     RevocationList    `json:"signed"`
+	Signatures SignatureEnvelope `json:"signatures"`
+}
+
+// Revoke adds a key id to the list
+func (rl *RevocationList) Revoke(keyID string, reason string) error {
+	if keyID == "" {
+		return errors.New("Key id should not be empty")
+	}
+	if rl.IsRevoked(keyID) {
+		return fmt.Errorf("Key %s is already revoked", keyID)
+	}
+
+	rl.Revocations = append(rl.Revocations, Revocation{KeyID: keyID, Revoked: time.Now().UTC(), Reason: reason})
+	rl.Version++
+
+	return nil
+}
+
+// IsRevoked checks if a key id is revoked
+func (rl *RevocationList) IsRevoked(keyID string) bool {
+	for _, r := range rl.Revocations {
+		if r.KeyID == keyID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckEnvelope returns ErrorsKeyRevoked if any signature of an envelope is made by a revoked key
+func (rl *RevocationList) CheckEnvelope(env SignatureEnvelope) error {
+	for _, s := range env.Signatures {
+		if rl.IsRevoked(s.KeyID) {
+			return fmt.Errorf("%v: %s", ErrorsKeyRevoked, s.KeyID)
+		}
+	}
+
+	return nil
+}
+
+// CheckPublicKey returns ErrorsKeyRevoked if a public key is revoked
+func (rl *RevocationList) CheckPublicKey(pubBytes []byte) error {
+	keyID, err := KeyID(pubBytes)
+	if err != nil {
+		return err
+	}
+	if rl.IsRevoked(keyID) {
+		return fmt.Errorf("%v: %s", ErrorsKeyRevoked, keyID)
+	}
+
+	return nil
+}
+
+// CheckUpdate checks that a list could replace a cached one, it should not be
+// older than the cached one or drop any key the cached one revokes
+func (rl *RevocationList) CheckUpdate(cached RevocationList) error {
+	if rl.Version < cached.Version {
+		return fmt.Errorf("Revocation list version %d is older than the cached version %d", rl.Version, cached.Version)
+	}
+	for _, r := range cached.Revocations {
+		if !rl.IsRevoked(r.KeyID) {
+			return fmt.Errorf("Revocation list drops the revoked key %s", r.KeyID)
+		}
+	}
+
+	return nil
+}
+
+// CheckRole returns ErrorsKeyRevoked if any key of a role is revoked
+func (rl *RevocationList) CheckRole(role Role) error {
+	for keyID := range role.Keys {
+		if rl.IsRevoked(keyID) {
+			return fmt.Errorf("%v: %s is still a key of the role", ErrorsKeyRevoked, keyID)
+		}
+	}
+
+	return nil
+}
+
+// ParseSignedRevocationList loads a signed revocation list
+func ParseSignedRevocationList(data []byte) (SignedRevocationList, error) {
+	var srl SignedRevocationList
+	if err := json.Unmarshal(data, &srl); err != nil {
+		return SignedRevocationList{}, err
+	}
+	if len(srl.Signatures.Signatures) == 0 {
+		return SignedRevocationList{}, errors.New("revocation list should have at least one signature")
+	}
+
+	return srl, nil
+}
+
+// Verify verifies the list by the signature of a public key, a key could not
+// sign a list which revokes itself.
+func (srl *SignedRevocationList) Verify(pubBytes []byte) error {
+	if err := srl.Signed.CheckPublicKey(pubBytes); err != nil {
+		return err
+	}
+
+	payload, err := CanonicalJSON(srl.Signed)
+	if err != nil {
+		return err
+	}
+
+	return srl.Signatures.Verify(pubBytes, RevocationPayloadType, payload)
+}
+
+// VerifyRole verifies the list by the threshold of a role, the keys revoked by
+// the list are not counted, so a leaked key could not sign the list alone.
+func (srl *SignedRevocationList) VerifyRole(role Role) error {
+	trusted := Role{Threshold: role.Threshold, Keys: make(map[string]string)}
+	for keyID, pubKey := range role.Keys {
+		if !srl.Signed.IsRevoked(keyID) {
+			trusted.Keys[keyID] = pubKey
+		}
+	}
+
+	payload, err := CanonicalJSON(srl.Signed)
+	if err != nil {
+		return err
+	}
+
+	return trusted.VerifyThreshold(RevocationPayloadType, payload, srl.Signatures)
+}
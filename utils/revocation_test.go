@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationList(t *testing.T) {
+	var rl RevocationList
+	assert.False(t, rl.IsRevoked("id1"), "Empty list should not revoke any key")
+
+	assert.Nil(t, rl.Revoke("id1", "leaked"), "Fail to revoke a key")
+	assert.True(t, rl.IsRevoked("id1"), "Fail to revoke a key")
+	assert.Equal(t, 1, rl.Version, "Version should grow with every revocation")
+	assert.NotNil(t, rl.Revoke("id1", ""), "Should not revoke a key twice")
+	assert.NotNil(t, rl.Revoke("", ""), "Should not revoke an empty key id")
+
+	env := NewSignatureEnvelope(DefaultPayloadType)
+	env.Signatures = []Signature{{KeyID: "id2"}}
+	assert.Nil(t, rl.CheckEnvelope(env), "Envelope without revoked keys should pass")
+	env.Signatures = append(env.Signatures, Signature{KeyID: "id1"})
+	assert.NotNil(t, rl.CheckEnvelope(env), "Envelope with a revoked key should be refused")
+}
+
+func TestSignedRevocationList(t *testing.T) {
+	privBytes, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+	_, otherPub, _ := GenerateKeyPair(KeyTypeEd25519)
+	otherID, _ := KeyID(otherPub)
+
+	sign := func(rl RevocationList) []byte {
+		payload, _ := CanonicalJSON(rl)
+		srl := SignedRevocationList{Signed: rl, Signatures: NewSignatureEnvelope(RevocationPayloadType)}
+		sig, _ := SHA256Sign(privBytes, srl.Signatures.PAE(payload))
+		srl.Signatures.AddSignature(pubBytes, sig)
+		data, _ := CanonicalJSON(srl)
+		return data
+	}
+
+	var rl RevocationList
+	rl.Revoke(otherID, "leaked")
+	srl, err := ParseSignedRevocationList(sign(rl))
+	assert.Nil(t, err, "Fail to parse a signed revocation list")
+	assert.Nil(t, srl.Verify(pubBytes), "Fail to verify a signed revocation list")
+	assert.NotNil(t, srl.Verify(otherPub), "Should not verify by another key")
+
+	srl.Signed.Revocations = nil
+	assert.NotNil(t, srl.Verify(pubBytes), "Should not verify a modified list")
+
+	keyID, _ := KeyID(pubBytes)
+	rl.Revoke(keyID, "")
+	srl, _ = ParseSignedRevocationList(sign(rl))
+	assert.NotNil(t, srl.Verify(pubBytes), "A key should not sign a list revoking itself")
+
+	_, err = ParseSignedRevocationList([]byte(`{"signed": {"version": 1}}`))
+	assert.NotNil(t, err, "Should not parse an unsigned list")
+}
+
+func TestRevocationListCheck(t *testing.T) {
+	privs := make([][]byte, 3)
+	pubs := make([][]byte, 3)
+	for i := range privs {
+		privs[i], pubs[i], _ = GenerateKeyPair(KeyTypeEd25519)
+	}
+	role, _ := NewRole(2, pubs...)
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i], _ = KeyID(pubs[i])
+	}
+
+	var cached RevocationList
+	cached.Revoke("old", "")
+	var rl RevocationList
+	rl.Revoke(ids[0], "leaked")
+	assert.NotNil(t, rl.CheckUpdate(cached), "Should not drop a revoked key")
+	rl.Revoke("old", "")
+	assert.Nil(t, rl.CheckUpdate(cached))
+	assert.NotNil(t, cached.CheckUpdate(rl), "Should not replace a list by an older one")
+	assert.NotNil(t, rl.CheckRole(role), "Should refuse a role with a revoked key")
+
+	srl := SignedRevocationList{Signed: rl, Signatures: NewSignatureEnvelope(RevocationPayloadType)}
+	payload, _ := CanonicalJSON(rl)
+	for _, i := range []int{0, 1} {
+		sig, _ := SHA256Sign(privs[i], srl.Signatures.PAE(payload))
+		srl.Signatures.AddSignature(pubs[i], sig)
+	}
+	assert.NotNil(t, srl.VerifyRole(role), "The signature of a revoked key should not be counted")
+	sig, _ := SHA256Sign(privs[2], srl.Signatures.PAE(payload))
+	srl.Signatures.AddSignature(pubs[2], sig)
+	assert.Nil(t, srl.VerifyRole(role), "Fail to verify a list by the threshold of the role")
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Role lists the public keys trusted to sign a meta data and how many of
+// them are required, a meta data is trusted only if it is signed by at
+// least 'Threshold' different keys of the role.
+// The version grows with every change so clients could refuse an older role,
+// and a role is not trusted after it expires. Both are omitted by the old roles.
+type Role struct {
+	Threshold int `json:"threshold"`
+	// Keys maps the key ids to PEM encoded public keys
+	Keys    map[string]string `json:"keys"`
+	Version int               `json:"version,omitempty"`
+	Expires *time.Time        `json:"expires,omitempty"`
+}
+
+// NewRole creates a role by a threshold and public keys
+func NewRole(threshold int, pubKeys ...[]byte) (Role, error) {
+	role := Role{Threshold: threshold, Keys: make(map[string]string)}
+	for _, pubBytes := range pubKeys {
+		if err := role.AddKey(pubBytes); err != nil {
+			return Role{}, err
+		}
+	}
+
+	if err := role.IsValid(); err != nil {
+		return Role{}, err
+	}
+
+	return role, nil
+}
+
+// AddKey adds a public key to a role
+func (r *Role) AddKey(pubBytes []byte) error {
+	if _, err := GetKeyType(pubBytes); err != nil {
+		return err
+	}
+	keyID, err := KeyID(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	if r.Keys == nil {
+		r.Keys = make(map[string]string)
+	}
+	r.Keys[keyID] = string(pubBytes)
+
+	return nil
+}
+
+// IsValid checks if the threshold could be reached by the keys of a role
+func (r *Role) IsValid() error {
+	if r.Threshold < 1 {
+		return errors.New("Role threshold should be at least 1")
+	}
+	if r.Threshold > len(r.Keys) {
+		return fmt.Errorf("Role threshold %d is bigger than the key count %d", r.Threshold, len(r.Keys))
+	}
+	// one key should never be counted twice
+	for keyID, pubKey := range r.Keys {
+		if id, err := KeyID([]byte(pubKey)); err != nil || id != keyID {
+			return fmt.Errorf("Role key id %s does not match its public key", keyID)
+		}
+	}
+
+	return nil
+}
+
+// IsExpired tells if a role is expired at 'now', a role without expiry never expires
+func (r *Role) IsExpired(now time.Time) bool {
+	return r.Expires != nil && now.After(*r.Expires)
+}
+
+// CheckUpdate checks that a role could replace a cached one, it should not be
+// expired or older than the cached one
+func (r *Role) CheckUpdate(cached Role, now time.Time) error {
+	if r.IsExpired(now) {
+		return fmt.Errorf("Role version %d is expired at %s", r.Version, r.Expires.Format(time.RFC3339))
+	}
+	if r.Version < cached.Version {
+		return fmt.Errorf("Role version %d is older than the cached version %d", r.Version, cached.Version)
+	}
+
+	return nil
+}
+
+// VerifyThreshold verifies that a payload of a type is signed by at least
+// 'Threshold' different keys of the role in an envelope
+func (r *Role) VerifyThreshold(payloadType string, payload []byte, env SignatureEnvelope) error {
+	if err := r.IsValid(); err != nil {
+		return err
+	}
+
+	valid := 0
+	for _, pubKey := range r.Keys {
+		if env.Verify([]byte(pubKey), payloadType, payload) == nil {
+			valid++
+		}
+		if valid >= r.Threshold {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%v: %d of %d required signatures are valid", ErrorsNoValidSignature, valid, r.Threshold)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleVerifyThreshold(t *testing.T) {
+	payload := []byte("This is the payload signed by several keys")
+
+	var privs, pubs [][]byte
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSAP256, KeyTypeEd25519} {
+		privBytes, pubBytes, _ := GenerateKeyPair(keyType)
+		privs = append(privs, privBytes)
+		pubs = append(pubs, pubBytes)
+	}
+
+	role, err := NewRole(2, pubs...)
+	assert.Nil(t, err, "Fail to create a 2-of-3 role")
+
+	env := NewSignatureEnvelope(DefaultPayloadType)
+	sig, _ := SHA256Sign(privs[0], env.PAE(payload))
+	env.AddSignature(pubs[0], sig)
+	assert.NotNil(t, role.VerifyThreshold(DefaultPayloadType, payload, env), "One signature should not reach the threshold")
+
+	// the same signature again should not be counted twice
+	env.Signatures = append(env.Signatures, env.Signatures[0])
+	assert.NotNil(t, role.VerifyThreshold(DefaultPayloadType, payload, env), "One key should not be counted twice")
+
+	sig, _ = SHA256Sign(privs[2], env.PAE(payload))
+	env.AddSignature(pubs[2], sig)
+	assert.Nil(t, role.VerifyThreshold(DefaultPayloadType, payload, env), "Fail to verify 2-of-3 signatures")
+	assert.NotNil(t, role.VerifyThreshold(DefaultPayloadType, []byte("invalid payload"), env), "Should not verify invalid payload")
+}
+
+func TestRoleIsValid(t *testing.T) {
+	_, pubBytes, _ := GenerateKeyPair(KeyTypeEd25519)
+	keyID, _ := KeyID(pubBytes)
+
+	cases := []struct {
+		role     Role
+		expected bool
+	}{
+		{Role{Threshold: 1, Keys: map[string]string{keyID: string(pubBytes)}}, true},
+		{Role{Threshold: 0, Keys: map[string]string{keyID: string(pubBytes)}}, false},
+		{Role{Threshold: 2, Keys: map[string]string{keyID: string(pubBytes)}}, false},
+		{Role{Threshold: 2, Keys: map[string]string{keyID: string(pubBytes), "fake": string(pubBytes)}}, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.role.IsValid() == nil, "Fail to check role")
+	}
+
+	_, err := NewRole(1, []byte("invalid key"))
+	assert.NotNil(t, err, "Should not create role with invalid key")
+}
+
+func TestRoleCheckUpdate(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	cached := Role{Threshold: 1, Version: 2}
+
+	assert.Nil(t, (&Role{Version: 2}).CheckUpdate(cached, now))
+	assert.Nil(t, (&Role{Version: 3, Expires: &expires}).CheckUpdate(cached, now))
+	assert.NotNil(t, (&Role{Version: 1}).CheckUpdate(cached, now), "Should not replace a role by an older one")
+	assert.NotNil(t, (&Role{Version: 3, Expires: &expires}).CheckUpdate(cached, now.Add(2*time.Hour)), "Should not trust an expired role")
+
+	// the old roles are signed without the version and the expiry
+	data, _ := CanonicalJSON(Role{Threshold: 1, Keys: map[string]string{}})
+	assert.Equal(t, `{"keys":{},"threshold":1}`, string(data))
+}
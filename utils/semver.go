@@ -0,0 +1,311 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrorsInvalidSemVer occurs when a string is not a semantic version
+	ErrorsInvalidSemVer = errors.New("invalid semantic version")
+	// ErrorsInvalidConstraint occurs when a string is not a version constraint
+	ErrorsInvalidConstraint = errors.New("invalid version constraint")
+
+	semVerRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+		`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+		`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+	partialRegexp = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?` +
+		`(?:-([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?(?:\+[0-9a-zA-Z-.]+)?$`)
+)
+
+// SemVer is a semantic version, see https://semver.org
+type SemVer struct {
+	Major      int64
+	Minor      int64
+	Patch      int64
+	PreRelease []string
+	Build      string
+}
+
+// ParseSemVer parses a full semantic version like '1.4.2-rc.1+build', a leading 'v' is allowed
+func ParseSemVer(s string) (SemVer, error) {
+	m := semVerRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return SemVer{}, fmt.Errorf("%v: %s", ErrorsInvalidSemVer, s)
+	}
+
+	var v SemVer
+	v.Major, _ = strconv.ParseInt(m[1], 10, 64)
+	v.Minor, _ = strconv.ParseInt(m[2], 10, 64)
+	v.Patch, _ = strconv.ParseInt(m[3], 10, 64)
+	if m[4] != "" {
+		v.PreRelease = strings.Split(m[4], ".")
+	}
+	v.Build = m[5]
+
+	return v, nil
+}
+
+// String returns the version without the leading 'v'
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// IsPreRelease tells if a version is a pre-release
+func (v SemVer) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+// Compare returns 0 if v, o have the same precedence, -1 if v < o, other wise returns 1.
+// Build meta data is ignored and a pre-release is lower than its normal version.
+func (v SemVer) Compare(o SemVer) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	switch {
+	case len(v.PreRelease) == 0 && len(o.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(o.PreRelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.PreRelease) && i < len(o.PreRelease); i++ {
+		if c := comparePreRelease(v.PreRelease[i], o.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(v.PreRelease)), int64(len(o.PreRelease)))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePreRelease compares two identifiers, numeric ones are lower than alphanumeric ones
+func comparePreRelease(a, b string) int {
+	an, aErr := strconv.ParseInt(a, 10, 64)
+	bn, bErr := strconv.ParseInt(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInt(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return Compare(a, b)
+}
+
+// comparator is a single comparison like '>=1.2.0'
+type comparator struct {
+	op string
+	v  SemVer
+}
+
+func (c comparator) check(v SemVer) bool {
+	r := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return r == 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
+
+// Constraint is a set of version ranges, a version satisfies it if it is in any of them.
+// Pre-releases are only satisfied by a range mentioning a pre-release of the same
+// major.minor.patch, so '^1.4' never resolves to '2.0.0-rc.1' or '1.5.0-beta'.
+type Constraint struct {
+	raw    string
+	ranges [][]comparator
+}
+
+// ParseConstraint parses a version constraint, for example:
+// 'latest' or '*' for any release, '1.4.2' or '=1.4.2' for an exact version,
+// '1.4' or '1.4.x' for '>=1.4.0 <1.5.0', '~1.2' for '>=1.2.0 <1.3.0',
+// '^1.4' for '>=1.4.0 <2.0.0', '>=2.0 <3' for a range, and '||' joins ranges.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: s}
+	for _, r := range strings.Split(s, "||") {
+		var cs []comparator
+		fields := strings.Fields(r)
+		if len(fields) == 0 {
+			fields = []string{"*"}
+		}
+		for _, f := range fields {
+			parsed, err := parseComparator(f)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("%v: %s", ErrorsInvalidConstraint, s)
+			}
+			cs = append(cs, parsed...)
+		}
+		c.ranges = append(c.ranges, cs)
+	}
+
+	return c, nil
+}
+
+// String returns the constraint as it is parsed
+func (c Constraint) String() string {
+	return c.raw
+}
+
+// Check tells if a version satisfies the constraint
+func (c Constraint) Check(v SemVer) bool {
+	for _, r := range c.ranges {
+		if checkRange(r, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRange(r []comparator, v SemVer) bool {
+	allowPre := !v.IsPreRelease()
+	for _, c := range r {
+		if !c.check(v) {
+			return false
+		}
+		if c.v.IsPreRelease() && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			allowPre = true
+		}
+	}
+	return allowPre
+}
+
+// Latest gets the index of the highest version satisfying the constraint, -1 if none
+func (c Constraint) Latest(versions []SemVer) int {
+	latest := -1
+	for i := range versions {
+		if c.Check(versions[i]) && (latest < 0 || versions[i].Compare(versions[latest]) > 0) {
+			latest = i
+		}
+	}
+	return latest
+}
+
+// parseComparator parses one field of a constraint to the comparators it means
+func parseComparator(s string) ([]comparator, error) {
+	if s == "latest" || s == "*" || s == "x" || s == "X" {
+		return nil, nil
+	}
+
+	var op string
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	m := partialRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrorsInvalidSemVer
+	}
+	// the count of the given parts, up to the first wildcard
+	var nums []int64
+	for _, p := range m[1:4] {
+		if p == "" || p == "x" || p == "X" || p == "*" {
+			break
+		}
+		n, _ := strconv.ParseInt(p, 10, 64)
+		nums = append(nums, n)
+	}
+	if len(nums) == 0 {
+		if op == "" || op == "=" || op == ">=" || op == "<=" || op == "~" || op == "^" {
+			return nil, nil
+		}
+		return nil, ErrorsInvalidSemVer
+	}
+
+	low := SemVer{}
+	low.Major = nums[0]
+	if len(nums) > 1 {
+		low.Minor = nums[1]
+	}
+	if len(nums) > 2 {
+		low.Patch = nums[2]
+		if m[4] != "" {
+			low.PreRelease = strings.Split(m[4], ".")
+		}
+	}
+	// high is the lowest version above the partial version, for example 1.3.0-0 for 1.2
+	high := func(parts int) SemVer {
+		switch parts {
+		case 1:
+			return SemVer{Major: low.Major + 1, PreRelease: []string{"0"}}
+		case 2:
+			return SemVer{Major: low.Major, Minor: low.Minor + 1, PreRelease: []string{"0"}}
+		}
+		return SemVer{Major: low.Major, Minor: low.Minor, Patch: low.Patch + 1, PreRelease: []string{"0"}}
+	}
+
+	switch op {
+	case "", "=":
+		if len(nums) == 3 {
+			return []comparator{{"=", low}}, nil
+		}
+		return []comparator{{">=", low}, {"<", high(len(nums))}}, nil
+	case ">=":
+		return []comparator{{">=", low}}, nil
+	case "<":
+		return []comparator{{"<", low}}, nil
+	case ">":
+		if len(nums) == 3 {
+			return []comparator{{">", low}}, nil
+		}
+		return []comparator{{">=", high(len(nums))}}, nil
+	case "<=":
+		if len(nums) == 3 {
+			return []comparator{{"<=", low}}, nil
+		}
+		return []comparator{{"<", high(len(nums))}}, nil
+	case "~":
+		if len(nums) == 1 {
+			return []comparator{{">=", low}, {"<", high(1)}}, nil
+		}
+		return []comparator{{">=", low}, {"<", high(2)}}, nil
+	case "^":
+		// the first non-zero part of the version could not change
+		switch {
+		case low.Major != 0 || len(nums) == 1:
+			return []comparator{{">=", low}, {"<", high(1)}}, nil
+		case low.Minor != 0 || len(nums) == 2:
+			return []comparator{{">=", low}, {"<", high(2)}}, nil
+		}
+		return []comparator{{">=", low}, {"<", high(3)}}, nil
+	}
+
+	return nil, ErrorsInvalidSemVer
+}
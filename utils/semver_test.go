@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemVer(t *testing.T) {
+	cases := []struct {
+		s        string
+		expected string
+		ok       bool
+	}{
+		{s: "1.4.2", expected: "1.4.2", ok: true},
+		{s: "v1.4.2", expected: "1.4.2", ok: true},
+		{s: "1.0.0-rc.1+build.5", expected: "1.0.0-rc.1+build.5", ok: true},
+		{s: "1.4", ok: false},
+		{s: "01.4.2", ok: false},
+		{s: "1.4.2-01", ok: false},
+		{s: "latest", ok: false},
+	}
+
+	for _, c := range cases {
+		v, err := ParseSemVer(c.s)
+		assert.Equal(t, c.ok, err == nil, "Fail to parse "+c.s)
+		if c.ok {
+			assert.Equal(t, c.expected, v.String())
+		}
+	}
+}
+
+func TestSemVerCompare(t *testing.T) {
+	// in the order of https://semver.org/#spec-item-11
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0"}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseSemVer(ordered[i])
+			b, _ := ParseSemVer(ordered[j])
+			assert.Equal(t, compareInt(int64(i), int64(j)), a.Compare(b), "Fail to compare "+ordered[i]+" and "+ordered[j])
+		}
+	}
+
+	a, _ := ParseSemVer("1.0.0+build1")
+	b, _ := ParseSemVer("1.0.0+build2")
+	assert.Equal(t, 0, a.Compare(b), "Build meta data should be ignored")
+}
+
+func TestConstraint(t *testing.T) {
+	cases := []struct {
+		c       string
+		match   []string
+		nomatch []string
+	}{
+		{c: "latest", match: []string{"0.0.1", "3.2.1"}, nomatch: []string{"3.3.0-rc.1"}},
+		{c: "1.4.2", match: []string{"1.4.2", "1.4.2+build"}, nomatch: []string{"1.4.3", "1.4.2-rc.1"}},
+		{c: "1.4", match: []string{"1.4.0", "1.4.9"}, nomatch: []string{"1.5.0", "1.3.9"}},
+		{c: "1.x", match: []string{"1.0.0", "1.9.9"}, nomatch: []string{"2.0.0"}},
+		{c: "~1.2", match: []string{"1.2.0", "1.2.7"}, nomatch: []string{"1.3.0", "1.1.9"}},
+		{c: "~1.2.3", match: []string{"1.2.3", "1.2.9"}, nomatch: []string{"1.2.2", "1.3.0"}},
+		{c: "^1.4", match: []string{"1.4.0", "1.9.0"}, nomatch: []string{"1.3.9", "2.0.0", "2.0.0-rc.1", "1.5.0-beta"}},
+		{c: "^0.2.3", match: []string{"0.2.3", "0.2.9"}, nomatch: []string{"0.3.0"}},
+		{c: "^0.0.3", match: []string{"0.0.3"}, nomatch: []string{"0.0.4"}},
+		{c: ">=2.0 <3", match: []string{"2.0.0", "2.9.9"}, nomatch: []string{"1.9.9", "3.0.0", "3.0.0-rc.1"}},
+		{c: ">1.2", match: []string{"1.3.0"}, nomatch: []string{"1.2.9"}},
+		{c: "<=1.2", match: []string{"1.2.9"}, nomatch: []string{"1.3.0"}},
+		{c: "^1.0.0-rc.1", match: []string{"1.0.0-rc.2", "1.0.0", "1.2.0"}, nomatch: []string{"1.0.0-beta", "1.1.0-rc.1"}},
+		{c: "1.2 || >=3", match: []string{"1.2.1", "3.1.0"}, nomatch: []string{"2.0.0"}},
+	}
+
+	for _, c := range cases {
+		constraint, err := ParseConstraint(c.c)
+		assert.Nil(t, err, "Fail to parse "+c.c)
+		for _, s := range c.match {
+			v, _ := ParseSemVer(s)
+			assert.True(t, constraint.Check(v), s+" should satisfy "+c.c)
+		}
+		for _, s := range c.nomatch {
+			v, _ := ParseSemVer(s)
+			assert.False(t, constraint.Check(v), s+" should not satisfy "+c.c)
+		}
+	}
+
+	for _, s := range []string{"abc", ">=", "^1.a", "> >"} {
+		_, err := ParseConstraint(s)
+		assert.NotNil(t, err, "Should not parse "+s)
+	}
+}
+
+func TestConstraintLatest(t *testing.T) {
+	var versions []SemVer
+	for _, s := range []string{"1.4.0", "1.10.1", "2.0.0-rc.1", "1.9.3", "0.9.0"} {
+		v, _ := ParseSemVer(s)
+		versions = append(versions, v)
+	}
+
+	c, _ := ParseConstraint("^1.4")
+	assert.Equal(t, 1, c.Latest(versions), "Fail to resolve the latest version")
+	c, _ = ParseConstraint("~1.9")
+	assert.Equal(t, 3, c.Latest(versions))
+	c, _ = ParseConstraint(">=3")
+	assert.Equal(t, -1, c.Latest(versions), "Should not resolve a version")
+}
@@ -18,7 +18,6 @@ package utils
 
 import (
 	"bytes"
-	"crypto"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
@@ -151,6 +150,17 @@ func SHA512(body []byte) (string, error) {
 	return fmt.Sprintf("%x", sha512h.Sum(nil)), nil
 }
 
+// SHA256 returns the hex encoded sha256 of a content
+func SHA256(body []byte) (string, error) {
+	sha256h := sha256.New()
+	_, err := io.Copy(sha256h, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256h.Sum(nil)), nil
+}
+
 // Compare returns 0 if a, b are equal, -1 if a < b, other wise returns 1
 func Compare(a, b string) int {
 	if a == b {
@@ -202,28 +212,26 @@ func RSADecrypt(keyBytes []byte, contentBytes []byte) ([]byte, error) {
 	return rsa.DecryptPKCS1v15(rand.Reader, privKey, contentBytes)
 }
 
-// SHA256Sign signs a content by a private key
+// SHA256Sign signs a content by a private key, the signature algorithm
+// depends on the key type of the key
 func SHA256Sign(keyBytes []byte, contentBytes []byte) ([]byte, error) {
-	privKey, err := getPrivKey(keyBytes)
+	signer, keyType, err := getSigner(keyBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	hashed := sha256.Sum256(contentBytes)
-	return rsa.SignPKCS1v15(rand.Reader, privKey, crypto.SHA256, hashed[:])
+	return signWithType(signer, keyType, contentBytes)
 }
 
-// SHA256Verify verifies if a content is valid by a signed data an a public key
+// SHA256Verify verifies if a content is valid by a signed data an a public key,
+// the signature algorithm depends on the key type of the key
 func SHA256Verify(keyBytes []byte, contentBytes []byte, signBytes []byte) error {
-	pubKey, err := getPubKey(keyBytes)
+	pubKey, keyType, err := getPublicKey(keyBytes)
 	if err != nil {
 		return err
 	}
 
-	signStr := hex.EncodeToString(signBytes)
-	newSignBytes, _ := hex.DecodeString(signStr)
-	hashed := sha256.Sum256(contentBytes)
-	return rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, hashed[:], newSignBytes)
+	return verifyWithType(pubKey, keyType, contentBytes, signBytes)
 }
 
 func getPrivKey(privBytes []byte) (*rsa.PrivateKey, error) {
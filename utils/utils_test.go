@@ -118,3 +118,15 @@ func TestSHA512(t *testing.T) {
 
 	assert.Equal(t, expected, sha512, "Fail to create correct sha512 value")
 }
+
+func TestSHA256(t *testing.T) {
+	expectedSHA256File := filepath.Join(testDataDir, "hello.sha256")
+	expectedBytes, _ := ioutil.ReadFile(expectedSHA256File)
+	expected := strings.TrimSpace(string(expectedBytes))
+
+	testContentFile := filepath.Join(testDataDir, "hello.txt")
+	contentBytes, _ := ioutil.ReadFile(testContentFile)
+	sha256, _ := SHA256(contentBytes)
+
+	assert.Equal(t, expected, sha256, "Fail to create correct sha256 value")
+}